@@ -0,0 +1,33 @@
+// Command auditverify checks that an audit log written by the file sink is intact:
+//
+//	go run ./cmd/auditverify .logs/audit.jsonl
+//
+// It exits with 1 and names the first broken line when an entry was changed, removed, added or reordered.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"xrf197ilz35aq/internal/audit"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: auditverify <audit log>")
+		os.Exit(2)
+	}
+	path := os.Args[1]
+
+	result, err := audit.VerifyFile(path)
+	var tamperErr *audit.TamperError
+	switch {
+	case errors.As(err, &tamperErr):
+		fmt.Fprintf(os.Stderr, "%s has been tampered with: %v (%d entries verified before it)\n", path, tamperErr, result.Entries)
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", path, err)
+		os.Exit(2)
+	}
+	fmt.Printf("%s is intact: %d entries, last hash %s\n", path, result.Entries, result.LastHash)
+}
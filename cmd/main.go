@@ -15,15 +15,27 @@ import (
 	accountV1 "xrf197ilz35aq/gen/xrfq3/account/v1"
 	xrfq3V1 "xrf197ilz35aq/gen/xrfq3/v1"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/cache"
+	"xrf197ilz35aq/internal/certs"
 	"xrf197ilz35aq/internal/client"
 	"xrf197ilz35aq/internal/client/grpc"
+	"xrf197ilz35aq/internal/features"
+	"xrf197ilz35aq/internal/health"
+	"xrf197ilz35aq/internal/idempotency"
+	"xrf197ilz35aq/internal/lifecycle"
+	"xrf197ilz35aq/internal/metrics"
 	"xrf197ilz35aq/internal/processor"
+	"xrf197ilz35aq/internal/ratelimit"
 	"xrf197ilz35aq/internal/server/api"
+	"xrf197ilz35aq/internal/tracing"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
 	env := getAppEnv()
-	config, err := internal.GetConfig(strings.ToLower(env))
+	config, err := internal.GetConfig(env)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
@@ -33,44 +45,155 @@ func main() {
 		log.Fatalf("Failed to setup logger: %v", err)
 	}
 
+	logger.Info("effective configuration", "config", config.Redacted())
+
+	/// Components register as they are created and are closed in reverse order on shutdown
+	lifecycleManager := lifecycle.NewManager(logger, config.Application)
+
+	/// Reload runtime-tunable settings on SIGHUP or when the config file changes
+	configWatcher := internal.NewConfigWatcher(env, config, logger)
+	features.Set(config.Features)
+
+	/// Setup tracing, spans are flushed on shutdown
+	shutdownTracing, err := tracing.Setup(context.Background(), config.Tracing)
+	if err != nil {
+		logger.Error("failed to setup tracing", "err", err)
+		os.Exit(1)
+	}
+	lifecycleManager.Register("tracing", lifecycle.CloseFunc(shutdownTracing))
+
+	/// Readiness checks, dependencies register themselves as they are created, readiness fails first on shutdown
+	healthMonitor := health.NewMonitor(logger, config.Health)
+	lifecycleManager.OnDrain(healthMonitor.Drain)
+
 	/// Create API Client
 	defaultHeaders := make(map[string]string)
 	defaultHeaders["Content-Type"] = "application/json"
 	parsedUrl, err := url.Parse(config.Service.Organization.BaseURL)
 	if err != nil {
 		logger.Error("failed to parse organization base url", "err", err)
+		os.Exit(1)
+	}
+	orgConfig := config.Service.Organization
+	apiClientOptions := []client.Option{
+		client.WithTimeout(orgConfig.APIClientTimeout),
+		client.WithDefaultHeader(defaultHeaders),
+		client.WithServiceName("organization"),
+		client.WithRetry(orgConfig.Retry),
+		client.WithCircuitBreaker(orgConfig.CircuitBreaker),
 	}
-	apiClient := client.NewApiClient(parsedUrl.String(), config.Application, client.WithTimeout(config.Service.Organization.APIClientTimeout), client.WithDefaultHeader(defaultHeaders))
+	if orgConfig.TLS.Enabled {
+		orgCerts, err := certs.NewReloader(orgConfig.TLS, logger)
+		if err != nil {
+			logger.Error("failed to load organization TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		apiClientOptions = append(apiClientOptions, client.WithTLS(orgCerts.ClientTLSConfig()))
+	}
+	apiClient := client.NewApiClient(parsedUrl.String(), config.Application, apiClientOptions...)
 
 	////// Create gRPC connection
-	// The ServerName must match the CN in the certificate.
-	// for local testing, usually it;s /CN=localhost
-	xrfQ3ServerName := "localhost"
-	xrfQ3CertPath := "local/secrets/ssl/server.crt"
-	connManager := grpc.NewConnectionManager(nil, grpc.NewTLSDialOptionProvider(xrfQ3CertPath, xrfQ3ServerName))
-	xrfQ3Conn, err := connManager.CreateOrGetConnection(config.Service.Account.Address, *logger)
-	if err != nil {
+	// The ServerName must match a SAN of the server certificate, for local testing usually it's localhost.
+	accountTimeouts := grpc.NewMethodTimeouts(config.Service.Account)
+	transportProvider := grpc.NewInsecureDialOptionProvider()
+	if config.Service.Account.TLS.Enabled {
+		accountCerts, err := certs.NewReloader(config.Service.Account.TLS, logger)
+		if err != nil {
+			logger.Error("failed to load account service TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		transportProvider = grpc.NewTLSDialOptionProvider(accountCerts)
+	}
+	dialOptionProvider := grpc.ChainDialOptionProviders(
+		transportProvider,
+		grpc.NewServiceConfigDialOptionProvider(config.Service.Account),
+		grpc.NewTracingDialOptionProvider("account"),
+		grpc.NewInterceptorDialOptionProvider(config.Service.Account, accountTimeouts),
+		grpc.NewMetricsDialOptionProvider("account"),
+	)
+	connManager := grpc.NewConnectionManager(nil, dialOptionProvider)
+	lifecycleManager.Register("grpcConnections", func(context.Context) error {
+		connManager.CloseAll(*logger)
+		return nil
+	})
+	if err := metrics.RegisterConnectionStates(connManager); err != nil {
+		logger.Warn("failed to register gRPC connection metrics", "err", err)
+	}
+	xrfQ3Target := grpc.Target(config.Service.Account)
+	if _, err := connManager.CreateOrGetConnection(xrfQ3Target, *logger); err != nil {
 		logger.Error("failed to create xrfQ3 connection", "err", err)
-		return
+		os.Exit(1)
 	}
 
-	////// register gRPC client
+	////// register gRPC client, calls go through the manager's current connection so that re-dials are picked up
+	xrfQ3Conn := connManager.ClientConn(xrfQ3Target, *logger)
 	acctServiceClient := accountV1.NewAccountServiceClient(xrfQ3Conn)
 	xrfQ3AppServiceClient := xrfq3V1.NewAppServiceClient(xrfQ3Conn)
 
 	///// Check xrfQ3 gRPC service health
-	err = checkXrfQ3Health(context.Background(), xrfQ3AppServiceClient, *logger)
-
+	healthResp, err := checkXrfQ3Health(context.Background(), xrfQ3AppServiceClient)
 	if err != nil {
 		logger.Error("XRF-Q3 app is not running", "err", err)
-		return
+		os.Exit(1)
+	}
+	logger.Info("xrfQ3RPC running healthy...", "port", healthResp.Region, "appId", healthResp.AppId)
+
+	// grpc.health.v1 statuses are watched and pushed to readiness as they change, CheckHealth is the fallback
+	checkHealthFallback := func(ctx context.Context) error {
+		_, err := checkXrfQ3Health(ctx, xrfQ3AppServiceClient)
+		return err
+	}
+	for _, service := range healthServices(config.Service.Account) {
+		name := "xrfq3"
+		if service != "" {
+			name += "/" + service
+		}
+		watcher := grpc.NewHealthWatcher(xrfQ3Conn, service, checkHealthFallback, *logger)
+		watcher.OnChange(func() { healthMonitor.Refresh(context.Background(), name) })
+		healthMonitor.Register(name, watcher.Check)
+		lifecycleManager.Go("healthWatcher/"+name, watcher.Watch)
 	}
+	healthMonitor.Register("xrfq3Connection", connManager.ConnectionCheck(xrfQ3Target))
+	healthMonitor.Register("organization", apiClient.Ping)
+	healthMonitor.Register("organizationCircuitBreaker", apiClient.CircuitBreakerCheck)
+
+	// the middleware subscribes to the settings it owns (rate limits, public routes) when the server is created
+	configWatcher.Subscribe(func(change internal.ConfigChange) {
+		current := change.Current
+		if change.Has("log.level") {
+			if err := internal.SetConfiguredLogLevel(current.Log.Level); err != nil {
+				logger.Warn("failed to change log level", "err", err)
+			}
+		}
+		if change.Has("log.packageLevels") {
+			if err := internal.SetPackageLogLevels(current.Log.PackageLevels); err != nil {
+				logger.Warn("failed to change package log levels", "err", err)
+			}
+		}
+		if change.Has("service.organization.apiClientTimeout") {
+			apiClient.SetTimeout(current.Service.Organization.APIClientTimeout)
+		}
+		if change.Has("service.account") {
+			accountTimeouts.Update(current.Service.Account)
+		}
+		if change.Has("features") {
+			features.Set(current.Features)
+		}
+	})
+
+	///// Audit trail of security-relevant actions, written by the processors and the auth middleware
+	auditor, err := createAuditor(config.Audit, logger)
+	if err != nil {
+		logger.Error("failed to create audit sinks", "err", err)
+		os.Exit(1)
+	}
+	lifecycleManager.Register("audit", func(context.Context) error { return auditor.Close() })
 
 	///// Create request processors
 	assetProcessor := processor.NewAssetProcessor()
 	userProcessor := processor.NewUserProcessor(*apiClient)
-	authProcessor := processor.NewAuthProcessor(*apiClient)
-	accountProcessor := processor.NewAccountProcessor(acctServiceClient)
+	authProcessor := processor.NewAuthProcessor(*apiClient, config.Auth, auditor)
+	accountProcessor := processor.NewAccountProcessor(acctServiceClient, auditor)
 
 	processors := processor.Processors{
 		UserProcessor:    *userProcessor,
@@ -79,14 +202,68 @@ func main() {
 		AccountProcessor: accountProcessor,
 	}
 
-	server := api.CreateServer(logger, config.Application, &processors)
+	///// Create middleware dependencies
+	serverDeps, err := createServerDependencies(config, healthMonitor, lifecycleManager)
+	if err != nil {
+		logger.Error("failed to create server dependencies", "err", err)
+		os.Exit(1)
+	}
+	serverDeps.ConfigWatcher = configWatcher
+	serverDeps.Auditor = auditor
+	if config.Application.TLS.Enabled {
+		serverCerts, err := certs.NewReloader(config.Application.TLS.TLSConfig, logger)
+		if err != nil {
+			logger.Error("failed to load server TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		serverDeps.TLSConfig = serverCerts.ServerTLSConfig()
+	}
+
+	lifecycleManager.Go("healthMonitor", healthMonitor.Start)
+	lifecycleManager.Go("configWatcher", configWatcher.Watch)
+
+	server, err := api.CreateServer(logger, config, &processors, serverDeps)
+	if err != nil {
+		logger.Error("failed to create api server", "err", err)
+		os.Exit(1)
+	}
+	lifecycleManager.RegisterServer("api", server)
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := listenAndServe(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("serverStarted=false :: error starting api server", "error", err)
 		}
 	}()
 
-	logger.Info("***** xrf197ilz35aq started successfully *******", "port", config.Application.Port)
+	if config.Application.TLS.Enabled && config.Application.TLS.RedirectPort != 0 {
+		redirectServer := api.CreateRedirectServer(config.Application)
+		lifecycleManager.RegisterServer("httpsRedirect", redirectServer)
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("redirectServerStarted=false :: error starting https redirect server", "error", err)
+			}
+		}()
+	}
+
+	adminDeps := api.AdminDependencies{HealthMonitor: healthMonitor, Connections: connManager}
+	if config.Admin.TLS.Enabled {
+		adminCerts, err := certs.NewReloader(config.Admin.TLS, logger)
+		if err != nil {
+			logger.Error("failed to load admin TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		adminDeps.TLSConfig = adminCerts.ServerTLSConfig()
+	}
+	adminServer := api.CreateAdminServer(logger, configWatcher, adminDeps)
+	lifecycleManager.RegisterServer("admin", adminServer)
+	go func() {
+		if err := listenAndServe(adminServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("adminServerStarted=false :: error starting admin server", "error", err)
+		}
+	}()
+
+	logger.Info("***** xrf197ilz35aq started successfully *******", "port", config.Application.Port,
+		"tls", config.Application.TLS.Enabled, "h2c", config.Application.H2C && !config.Application.TLS.Enabled,
+		"adminPort", config.Application.AdminPort, "adminTLS", config.Admin.TLS.Enabled, "adminTokenRequired", config.Admin.Token != "")
 
 	ch := make(chan os.Signal, 1)
 	// Accept graceful shutdowns when quit via SIGINT (Ctrl+C), SIGTERM (used by Docker, Kubernetes) or SIGQUIT.
@@ -98,30 +275,39 @@ func main() {
 	// Block until we receive shutdown signal.
 	<-ch
 	logger.Info("received shutdown signal, starting graceful shutdown")
+	// a second signal skips what is left of the pre-stop delay and of the drain
+	forceCtx, stopForce := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stopForce()
 
-	ctx, cancel := context.WithTimeout(context.Background(), config.Application.GracefulTimeout)
-	defer cancel()
-
-	// Attempt to gracefully shut down the server.
-	err = server.Shutdown(ctx)
-	if err != nil {
+	summary := lifecycleManager.Shutdown(forceCtx)
+	exitCode := 0
+	if err := summary.Err(); err != nil {
 		logger.Error("xrf197ilz35aq shutdown failed", "error", err)
-		os.Exit(1)
+		exitCode = 1
+	} else {
+		logger.Info("xrf197ilz35aq shutdown successfully")
 	}
-
-	logger.Info("xrf197ilz35aq shutdown successfully")
-	os.Exit(0)
+	if err := internal.CloseLogger(); err != nil {
+		log.Printf("failed to close the log file: %v", err)
+	}
+	os.Exit(exitCode)
 }
 
-func getAppEnv() string {
-	env, ok := os.LookupEnv(internal.Environment)
-	if !ok || env == "" {
-		env = internal.DevelopEnv
+// listenAndServe serves TLS when the server has a TLS config, the certificates come from it and are reloaded when
+// the files are rotated.
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
 	}
+	return server.ListenAndServe()
+}
 
-	switch env {
+// getAppEnv returns the environment named by XRF_ENV (case-insensitive) as one of DEV, STAGING or LIVE,
+// PRODUCTION is an alias of LIVE and anything else, or nothing, is DEV.
+func getAppEnv() string {
+	switch strings.ToUpper(os.Getenv(internal.Environment)) {
 	case internal.StagingEnv:
-		return "STAGING"
+		return internal.StagingEnv
 	case internal.ProductionEnv, internal.LiveEnv:
 		return internal.LiveEnv
 	default:
@@ -129,14 +315,100 @@ func getAppEnv() string {
 	}
 }
 
-func checkXrfQ3Health(ctx context.Context, xrfQ3RPCClient xrfq3V1.AppServiceClient, log slog.Logger) error {
+// createServerDependencies creates the stores used by the middleware chain, the redis client is only
+// created (and shared) when at least one of them is backed by redis, it is then checked for readiness and closed
+// on shutdown.
+func createServerDependencies(config *internal.Config, healthMonitor *health.Monitor, lifecycleManager *lifecycle.Manager) (api.Dependencies, error) {
+	deps := api.Dependencies{HealthMonitor: healthMonitor}
+
+	var redisClient *redis.Client
+	getRedisClient := func() (*redis.Client, error) {
+		if redisClient != nil {
+			return redisClient, nil
+		}
+		var err error
+		redisClient, err = cache.NewRedisClient(context.Background(), config.Redis)
+		if err != nil {
+			return nil, err
+		}
+		healthMonitor.Register("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+		lifecycleManager.Register("redis", func(context.Context) error { return redisClient.Close() })
+		return redisClient, nil
+	}
+
+	switch config.Idempotency.Store {
+	case "", "memory":
+		deps.IdempotencyStore = idempotency.NewMemoryStore()
+	case "redis":
+		client, err := getRedisClient()
+		if err != nil {
+			return deps, err
+		}
+		deps.IdempotencyStore = idempotency.NewRedisStore(client)
+	default:
+		return deps, fmt.Errorf("unknown idempotency store %q", config.Idempotency.Store)
+	}
+
+	switch config.RateLimit.Store {
+	case "", "memory":
+		deps.RateLimiter = ratelimit.NewMemoryLimiter()
+	case "redis":
+		client, err := getRedisClient()
+		if err != nil {
+			return deps, err
+		}
+		deps.RateLimiter = ratelimit.NewRedisLimiter(client)
+	default:
+		return deps, fmt.Errorf("unknown rate limit store %q", config.RateLimit.Store)
+	}
+
+	return deps, nil
+}
+
+// createAuditor opens the configured audit sinks, the auditor is nil (and records nothing) when audit is disabled.
+func createAuditor(config internal.AuditConfig, logger *slog.Logger) (*audit.Auditor, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	sinkNames := config.Sinks
+	if len(sinkNames) == 0 {
+		sinkNames = []string{"file"}
+	}
+	var sinks []audit.Sink
+	for _, name := range sinkNames {
+		switch name {
+		case "file":
+			fileSink, err := audit.NewFileSink(config.FilePath, config.SyncInterval)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+		case "log":
+			sinks = append(sinks, audit.NewLogSink(logger))
+		default:
+			return nil, fmt.Errorf("unknown audit sink %q", name)
+		}
+	}
+	return audit.NewAuditor(logger, sinks...), nil
+}
+
+// healthServices defaults to the health of the upstream server as a whole.
+func healthServices(grpcConfig internal.GrpcConfig) []string {
+	if len(grpcConfig.HealthServices) == 0 {
+		return []string{""}
+	}
+	return grpcConfig.HealthServices
+}
+
+func checkXrfQ3Health(ctx context.Context, xrfQ3RPCClient xrfq3V1.AppServiceClient) (*xrfq3V1.CheckHealthResponse, error) {
 	resp, err := xrfQ3RPCClient.CheckHealth(ctx, &xrfq3V1.CheckHealthRequest{})
 	if err != nil {
-		return fmt.Errorf("xrfQ3RPC-service failure, err:: %w", err)
+		return nil, fmt.Errorf("xrfQ3RPC-service failure, err:: %w", err)
 	}
 	if !resp.IsUp {
-		return fmt.Errorf("xrfQ3RPC is NOT running")
+		return nil, fmt.Errorf("xrfQ3RPC is NOT running")
 	}
-	log.Info("xrfQ3RPC running healthy...", "port", resp.Region, "appId", resp.AppId)
-	return nil
+	return resp, nil
 }
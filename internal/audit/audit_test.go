@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memorySink struct {
+	events []Event
+}
+
+func (s *memorySink) Write(_ context.Context, event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestAuditor_Record(t *testing.T) {
+	sink := &memorySink{}
+	auditor := NewAuditor(slog.New(slog.DiscardHandler), sink)
+	ctx := server.ContextWithRequestId(context.Background(), "req-1")
+	ctx = server.ContextWithClientIP(ctx, "10.0.0.7")
+	ctx = server.ContextWithUserCtx(ctx, &model.UserContext{UserId: "u1", Fingerprint: "fp-1"})
+
+	auditor.Record(ctx, ActionAccountLock, "acct-1", nil)
+	auditor.Record(ctx, ActionAccountUnlock, "acct-1", errors.New("account not found"))
+
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, Event{
+		Time: sink.events[0].Time, Action: ActionAccountLock, Outcome: OutcomeSuccess,
+		Actor: "fp-1", Target: "acct-1", RequestId: "req-1", ClientIP: "10.0.0.7",
+	}, sink.events[0])
+	assert.Equal(t, OutcomeFailure, sink.events[1].Outcome)
+	assert.Equal(t, "account not found", sink.events[1].Reason)
+
+	var nilAuditor *Auditor
+	assert.NotPanics(t, func() { nilAuditor.Record(ctx, ActionLogin, "", nil) })
+}
+
+func writeAuditLog(t *testing.T, path string, targets ...string) {
+	t.Helper()
+	sink, err := NewFileSink(path, 0)
+	require.NoError(t, err)
+	for _, target := range targets {
+		require.NoError(t, sink.Write(context.Background(), Event{Action: ActionAccountUpdate, Outcome: OutcomeSuccess, Target: target}))
+	}
+	require.NoError(t, sink.Close())
+}
+
+func TestFileSink_ChainsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+
+	writeAuditLog(t, path, "acct-1", "acct-2")
+	writeAuditLog(t, path, "acct-3")
+
+	result, err := VerifyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), result.Entries)
+	assert.Len(t, result.LastHash, 64)
+}
+
+func TestFileSink_SyncsOnAnInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write(context.Background(), Event{Action: ActionTokenRejected, Outcome: OutcomeFailure}))
+	assert.Eventually(t, func() bool {
+		sink.mut.Lock()
+		defer sink.mut.Unlock()
+		return !sink.dirty
+	}, time.Second, 5*time.Millisecond, "the background sync flushes the write")
+
+	require.NoError(t, sink.Write(context.Background(), Event{Action: ActionTokenRejected, Outcome: OutcomeFailure}))
+	require.NoError(t, sink.Close())
+	result, err := VerifyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), result.Entries)
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeAuditLog(t, path, "acct-1", "acct-2", "acct-3")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(strings.TrimSpace(string(content)), "\n")
+
+	for name, tampered := range map[string]string{
+		"changed":   strings.Replace(string(content), "acct-2", "acct-9", 1),
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+		"added":     strings.Replace(string(content), `"target":"acct-2"`, `"target":"acct-2","extra":true`, 1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(tampered))
+
+			var tamperErr *TamperError
+			require.ErrorAs(t, err, &tamperErr)
+			assert.LessOrEqual(t, tamperErr.Line, 2)
+		})
+	}
+}
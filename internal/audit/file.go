@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxEntryBytes bounds the size of one line when reading a log back.
+const maxEntryBytes = 1 << 20
+
+// Entry is one line of the file sink. Hash is the SHA-256 of the entry without its hash, since the entry holds the
+// hash of the previous one, changing, removing or reordering a line breaks the chain from that line on.
+type Entry struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prevHash"`
+	Event    Event  `json:"event"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// FileSink appends events to a JSONL file as a hash chain. With a sync interval the file is synced in the background
+// (an event written less than the interval before a crash may be lost), without one each write is synced before
+// returning.
+type FileSink struct {
+	mut          sync.Mutex
+	file         *os.File
+	seq          uint64
+	lastHash     string
+	syncInterval time.Duration
+	// dirty is set by writes not synced yet, syncErr is the last background sync failure, returned by the next Write.
+	dirty   bool
+	syncErr error
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func (s *FileSink) Write(_ context.Context, event Event) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	entry := Entry{Seq: s.seq + 1, PrevHash: s.lastHash, Event: event}
+	hash, err := entry.computeHash()
+	if err != nil {
+		return fmt.Errorf("failed to hash audit entry: %w", err)
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	s.seq, s.lastHash = entry.Seq, entry.Hash
+
+	if s.syncInterval > 0 {
+		s.dirty = true
+		syncErr := s.syncErr
+		s.syncErr = nil
+		return syncErr
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) syncLoop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// sync flushes the entries written since the last sync, outside the lock so that writers do not wait for the disk.
+func (s *FileSink) sync() {
+	s.mut.Lock()
+	if !s.dirty {
+		s.mut.Unlock()
+		return
+	}
+	s.dirty = false
+	s.mut.Unlock()
+
+	if err := s.file.Sync(); err != nil {
+		s.mut.Lock()
+		s.dirty = true
+		s.syncErr = fmt.Errorf("failed to sync audit log: %w", err)
+		s.mut.Unlock()
+	}
+}
+
+// Close syncs the entries not synced yet and closes the file.
+func (s *FileSink) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var syncErr error
+	if s.dirty {
+		if err := s.file.Sync(); err != nil {
+			syncErr = fmt.Errorf("failed to sync audit log: %w", err)
+		}
+	}
+	return errors.Join(syncErr, s.file.Close())
+}
+
+// NewFileSink opens path for appending and continues the chain of the entries already in it, syncInterval is how
+// often written entries are synced to disk, zero syncs every entry.
+func NewFileSink(path string, syncInterval time.Duration) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	last, err := lastEntry(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("cannot continue audit log %s, run auditverify on it: %w", path, err)
+	}
+	sink := &FileSink{file: file, seq: last.Seq, lastHash: last.Hash, syncInterval: syncInterval}
+	if syncInterval > 0 {
+		sink.stop, sink.stopped = make(chan struct{}), make(chan struct{})
+		go sink.syncLoop()
+	}
+	return sink, nil
+}
+
+func lastEntry(r io.Reader) (Entry, error) {
+	var last []byte
+	scanner := newEntryScanner(r)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Entry{}, err
+	}
+	var entry Entry
+	if last == nil {
+		return entry, nil
+	}
+	if err := json.Unmarshal(last, &entry); err != nil {
+		return Entry{}, fmt.Errorf("last entry is not valid: %w", err)
+	}
+	return entry, nil
+}
+
+func newEntryScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxEntryBytes)
+	return scanner
+}
+
+// TamperError reports the first line of an audit log that breaks the chain.
+type TamperError struct {
+	Line   int
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// VerifyResult describes an intact log. Removing entries from the end of a log cannot be detected from the log
+// alone, compare Entries and LastHash with a copy shipped elsewhere (e.g. by the log sink).
+type VerifyResult struct {
+	Entries  uint64
+	LastHash string
+}
+
+// Verify checks every entry of a log written by FileSink, it returns a *TamperError for the first broken entry.
+func Verify(r io.Reader) (VerifyResult, error) {
+	var result VerifyResult
+	scanner := newEntryScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var entry Entry
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil {
+			return result, &TamperError{Line: line, Reason: fmt.Sprintf("not a valid entry: %v", err)}
+		}
+		if entry.Seq != result.Entries+1 {
+			return result, &TamperError{Line: line, Reason: fmt.Sprintf("sequence %d, expected %d", entry.Seq, result.Entries+1)}
+		}
+		if entry.PrevHash != result.LastHash {
+			return result, &TamperError{Line: line, Reason: "previous hash does not match the previous entry"}
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return result, &TamperError{Line: line, Reason: err.Error()}
+		}
+		if hash != entry.Hash {
+			return result, &TamperError{Line: line, Reason: "hash does not match the content of the entry"}
+		}
+		result.Entries, result.LastHash = entry.Seq, entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return result, &TamperError{Line: line + 1, Reason: "entry is too long"}
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// VerifyFile runs Verify on the log at path.
+func VerifyFile(path string) (VerifyResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer file.Close()
+	return Verify(file)
+}
@@ -0,0 +1,33 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogSink writes events to a logger, e.g. to ship them with the application logs to a system the service
+// cannot write to afterwards.
+type LogSink struct {
+	logger *slog.Logger
+}
+
+func (s *LogSink) Write(ctx context.Context, event Event) error {
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "event=audit",
+		slog.String("action", string(event.Action)),
+		slog.String("outcome", string(event.Outcome)),
+		slog.String("actor", event.Actor),
+		slog.String("target", event.Target),
+		slog.String("requestId", event.RequestId),
+		slog.String("clientIp", event.ClientIP),
+		slog.String("reason", event.Reason),
+	)
+	return nil
+}
+
+func (s *LogSink) Close() error {
+	return nil
+}
+
+func NewLogSink(logger *slog.Logger) *LogSink {
+	return &LogSink{logger: logger}
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// MemoryCache is a small in-process TTL cache. When it is full, expired entries are dropped first and then
+// an arbitrary entry, it is meant for short-lived values such as validated auth tokens.
+type MemoryCache[V any] struct {
+	mut        sync.Mutex
+	entries    map[string]memoryEntry[V]
+	maxEntries int
+	now        func() time.Time
+}
+
+func (c *MemoryCache[V]) Get(key string) (V, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+func (c *MemoryCache[V]) Set(key string, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := c.now()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = memoryEntry[V]{value: value, expiresAt: now.Add(ttl)}
+}
+
+func (c *MemoryCache[V]) Delete(key string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	delete(c.entries, key)
+}
+
+// evict frees room for one entry, callers must hold the lock.
+func (c *MemoryCache[V]) evict(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		delete(c.entries, key)
+	}
+}
+
+func NewMemoryCache[V any](maxEntries int) *MemoryCache[V] {
+	if maxEntries <= 0 {
+		maxEntries = 10_000
+	}
+	return &MemoryCache[V]{
+		entries:    make(map[string]memoryEntry[V]),
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedisClient creates a redis client from RedisConfig and pings it so misconfiguration fails at startup.
+// The RedisConfig timeouts are in seconds.
+func NewRedisClient(ctx context.Context, config internal.RedisConfig) (*redis.Client, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("redis address is not configured")
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         config.Address,
+		Password:     config.Password,
+		DB:           config.Database,
+		Protocol:     config.Protocol,
+		PoolSize:     config.PoolSize,
+		MaxRetries:   config.MaxRetries,
+		MinIdleConns: config.MinIdleConns,
+		DialTimeout:  time.Duration(config.DialTimeout) * time.Second,
+		ReadTimeout:  time.Duration(config.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(config.WriteTimeout) * time.Second,
+	})
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", config.Address, err)
+	}
+	return client, nil
+}
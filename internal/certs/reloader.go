@@ -0,0 +1,209 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"xrf197ilz35aq/internal"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+// Reloader holds the CA pool and the certificate of one upstream (or listener) and reloads them when the files change.
+// The files are checked at most once per reload interval, on the next TLS handshake, so rotated certificates are
+// used by every new connection without redialing. A rotation that cannot be loaded keeps the previous material.
+type Reloader struct {
+	mut            sync.Mutex
+	config         internal.TLSConfig
+	roots          *x509.CertPool
+	certificate    *tls.Certificate
+	modTimes       map[string]time.Time
+	lastCheck      time.Time
+	reloadInterval time.Duration
+	logger         *slog.Logger
+	now            func() time.Time
+}
+
+// ClientTLSConfig returns a tls.Config that presents the current client certificate and verifies the server
+// against the current CA pool. Verification is done in VerifyConnection because tls.Config.RootCAs cannot change.
+func (r *Reloader) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: r.config.ServerName,
+		// the server chain is verified by verifyServer against the reloaded pool
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.reloadIfChanged()
+			r.mut.Lock()
+			defer r.mut.Unlock()
+			if r.certificate == nil {
+				// no client certificate configured, let the server decide whether it needs one
+				return &tls.Certificate{}, nil
+			}
+			return r.certificate, nil
+		},
+	}
+}
+
+// ServerTLSConfig returns a tls.Config that presents the current certificate and, when a CA file is configured,
+// requires clients to present a certificate signed by it (mTLS). Each handshake gets the material in effect on a
+// copy of the returned config, its other settings (MinVersion, CipherSuites, NextProtos) can be changed before use.
+func (r *Reloader) ServerTLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.reloadIfChanged()
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		if r.certificate == nil {
+			return nil, errors.New("no server certificate configured")
+		}
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.Certificates = []tls.Certificate{*r.certificate}
+		if r.config.CAFile != "" {
+			config.ClientCAs = r.roots
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return config, nil
+	}
+	return base
+}
+
+func (r *Reloader) verifyServer(state tls.ConnectionState) error {
+	r.reloadIfChanged()
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	r.mut.Lock()
+	roots := r.roots
+	r.mut.Unlock()
+
+	serverName := r.config.ServerName
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// reloadIfChanged reloads the files when one of them was modified since the last load.
+func (r *Reloader) reloadIfChanged() {
+	r.mut.Lock()
+	now := r.now()
+	if now.Sub(r.lastCheck) < r.reloadInterval {
+		r.mut.Unlock()
+		return
+	}
+	r.lastCheck = now
+	changed := false
+	for path, modTime := range r.modTimes {
+		info, err := os.Stat(path)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+			break
+		}
+	}
+	r.mut.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.logger.Error("event=certificateReloadFailure, keeping the previous certificates", "error", err)
+		return
+	}
+	r.logger.Info("event=certificateReloaded", "caFile", r.config.CAFile, "certFile", r.config.CertFile)
+}
+
+func (r *Reloader) load() error {
+	modTimes := make(map[string]time.Time)
+	readFile := func(path string) ([]byte, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+		return os.ReadFile(path)
+	}
+
+	var roots *x509.CertPool
+	if r.config.CAFile == "" {
+		systemRoots, err := x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("could not load system CA pool: %w", err)
+		}
+		roots = systemRoots
+	} else {
+		caPEM, err := readFile(r.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("could not read CA file: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if ok := roots.AppendCertsFromPEM(caPEM); !ok {
+			return fmt.Errorf("no certificate found in CA file %s", r.config.CAFile)
+		}
+	}
+
+	var certificate *tls.Certificate
+	if r.config.CertFile != "" {
+		certPEM, err := readFile(r.config.CertFile)
+		if err != nil {
+			return fmt.Errorf("could not read certificate file: %w", err)
+		}
+		keyPEM, err := readFile(r.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("could not read key file: %w", err)
+		}
+		keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("invalid certificate/key pair: %w", err)
+		}
+		certificate = &keyPair
+	}
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.roots = roots
+	r.certificate = certificate
+	r.modTimes = modTimes
+	return nil
+}
+
+// NewReloader loads the certificates of config, it fails when they cannot be loaded so that misconfiguration
+// is caught at startup.
+func NewReloader(config internal.TLSConfig, logger *slog.Logger) (*Reloader, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("certFile and keyFile must be set together")
+	}
+	reloadInterval := config.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultReloadInterval
+	}
+
+	reloader := &Reloader{
+		config:         config,
+		reloadInterval: reloadInterval,
+		logger:         logger,
+		now:            time.Now,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	reloader.lastCheck = reloader.now()
+	return reloader, nil
+}
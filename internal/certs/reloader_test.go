@@ -0,0 +1,146 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/certs/certtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMTLSServer starts a server presenting a certificate of serverCA and requiring a client certificate of clientCA.
+func newMTLSServer(t *testing.T, serverCA, clientCA *certtest.CA) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCA.Server(t, "localhost").TLSCertificate(t)},
+		ClientCAs:    clientCA.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReloader(t *testing.T) {
+	serverCA := certtest.NewCA(t, "server-ca")
+	oldClientCA := certtest.NewCA(t, "old-client-ca")
+	newClientCA := certtest.NewCA(t, "new-client-ca")
+	server := newMTLSServer(t, serverCA, newClientCA)
+
+	dir := t.TempDir()
+	oldPair := oldClientCA.Client(t, "se-old")
+	config := internal.TLSConfig{
+		Enabled:    true,
+		CAFile:     certtest.WriteFile(t, dir, "ca.crt", serverCA.PEM),
+		CertFile:   certtest.WriteFile(t, dir, "client.crt", oldPair.CertPEM),
+		KeyFile:    certtest.WriteFile(t, dir, "client.key", oldPair.KeyPEM),
+		ServerName: "localhost",
+	}
+	reloader, err := NewReloader(config, slog.New(slog.DiscardHandler))
+	assert.NoError(t, err)
+	reloader.reloadInterval = 0
+
+	get := func() (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: reloader.ClientTLSConfig(), DisableKeepAlives: true}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	t.Run("a certificate the server does not trust is rejected", func(t *testing.T) {
+		_, err := get()
+		assert.Error(t, err)
+	})
+
+	t.Run("rotated certificates are used by the next connection", func(t *testing.T) {
+		newPair := newClientCA.Client(t, "se-new")
+		certtest.WriteFile(t, dir, "client.crt", newPair.CertPEM)
+		certtest.WriteFile(t, dir, "client.key", newPair.KeyPEM)
+		future := time.Now().Add(time.Minute)
+		assert.NoError(t, os.Chtimes(config.CertFile, future, future))
+
+		commonName, err := get()
+		assert.NoError(t, err)
+		assert.Equal(t, "se-new", commonName)
+	})
+
+	t.Run("a broken rotation keeps the previous certificates", func(t *testing.T) {
+		certtest.WriteFile(t, dir, "client.key", []byte("not a key"))
+		future := time.Now().Add(2 * time.Minute)
+		assert.NoError(t, os.Chtimes(config.KeyFile, future, future))
+
+		commonName, err := get()
+		assert.NoError(t, err)
+		assert.Equal(t, "se-new", commonName)
+	})
+
+	t.Run("a server outside the CA pool is rejected", func(t *testing.T) {
+		otherServer := newMTLSServer(t, certtest.NewCA(t, "other-ca"), newClientCA)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: reloader.ClientTLSConfig()}}
+		_, err := client.Get(otherServer.URL)
+		var unknownAuthority x509.UnknownAuthorityError
+		assert.ErrorAs(t, err, &unknownAuthority)
+	})
+}
+
+func TestNewReloader_RequiresCertAndKeyTogether(t *testing.T) {
+	_, err := NewReloader(internal.TLSConfig{CertFile: "client.crt"}, slog.New(slog.DiscardHandler))
+	assert.Error(t, err)
+}
+
+func TestReloader_ServerTLSConfig(t *testing.T) {
+	serverCA := certtest.NewCA(t, "server-ca")
+	clientCA := certtest.NewCA(t, "client-ca")
+	dir := t.TempDir()
+	serverPair := serverCA.Server(t, "localhost")
+	reloader, err := NewReloader(internal.TLSConfig{
+		Enabled:  true,
+		CAFile:   certtest.WriteFile(t, dir, "client-ca.crt", clientCA.PEM),
+		CertFile: certtest.WriteFile(t, dir, "server.crt", serverPair.CertPEM),
+		KeyFile:  certtest.WriteFile(t, dir, "server.key", serverPair.KeyPEM),
+	}, slog.New(slog.DiscardHandler))
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = reloader.ServerTLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	get := func(clientCert *tls.Certificate) (*http.Response, error) {
+		tlsConfig := &tls.Config{RootCAs: serverCA.Pool(), ServerName: "localhost"}
+		if clientCert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		return client.Get(server.URL)
+	}
+
+	t.Run("a client without certificate is rejected", func(t *testing.T) {
+		_, err := get(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("a client certificate signed by the CA is accepted", func(t *testing.T) {
+		clientCert := clientCA.Client(t, "operator").TLSCertificate(t)
+		resp, err := get(&clientCert)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
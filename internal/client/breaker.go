@@ -0,0 +1,162 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"xrf197ilz35aq/internal"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling the upstream while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// circuitBreaker stops calls to a host after failureThreshold consecutive failures. Once openTimeout has passed,
+// a single probe call is let through (half-open): its outcome closes the breaker again or re-opens it.
+type circuitBreaker struct {
+	mut              sync.Mutex
+	state            BreakerState
+	failures         int
+	openedAt         time.Time
+	probeInFlight    bool
+	failureThreshold int
+	openTimeout      time.Duration
+	now              func() time.Time
+}
+
+// allow reports whether a call may be made now.
+func (b *circuitBreaker) allow() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probeInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if b.probeInFlight {
+			return false
+		}
+		b.probeInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record stores the outcome of a call that allow let through.
+func (b *circuitBreaker) record(success bool) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	b.probeInFlight = false
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.failureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// release ends a call that allow let through without recording its outcome, e.g. when the caller gave up on it.
+func (b *circuitBreaker) release() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.probeInFlight = false
+}
+
+func (b *circuitBreaker) currentState() BreakerState {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.state
+}
+
+// circuitBreakers keeps one breaker per upstream host.
+type circuitBreakers struct {
+	mut      sync.Mutex
+	breakers map[string]*circuitBreaker
+	config   internal.CircuitBreakerConfig
+	now      func() time.Time
+}
+
+func (cb *circuitBreakers) forHost(host string) *circuitBreaker {
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	breaker, ok := cb.breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{
+			state:            BreakerClosed,
+			failureThreshold: cb.config.FailureThreshold,
+			openTimeout:      cb.config.OpenTimeout,
+			now:              cb.now,
+		}
+		cb.breakers[host] = breaker
+	}
+	return breaker
+}
+
+// states returns the state of every breaker, keyed by host.
+func (cb *circuitBreakers) states() map[string]BreakerState {
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	states := make(map[string]BreakerState, len(cb.breakers))
+	for host, breaker := range cb.breakers {
+		states[host] = breaker.currentState()
+	}
+	return states
+}
+
+// check fails while a breaker is open, it is registered as a readiness check.
+func (cb *circuitBreakers) check() error {
+	states := cb.states()
+	hosts := make([]string, 0, len(states))
+	for host, state := range states {
+		if state == BreakerOpen {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	sort.Strings(hosts)
+	return fmt.Errorf("circuit breaker open for %v", hosts)
+}
+
+func newCircuitBreakers(config internal.CircuitBreakerConfig) *circuitBreakers {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaultFailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultOpenTimeout
+	}
+	return &circuitBreakers{
+		breakers: make(map[string]*circuitBreaker),
+		config:   config,
+		now:      time.Now,
+	}
+}
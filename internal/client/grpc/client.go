@@ -1,16 +1,20 @@
 package grpc
 
 import (
-	"crypto/x509"
+	"context"
 	"fmt"
 	"log/slog"
-	"os"
 	"sync"
+	"xrf197ilz35aq/internal/certs"
+	"xrf197ilz35aq/internal/tracing"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type RPCClientDialer func(address string, opts ...grpc.DialOption) (*grpc.ClientConn, error)
@@ -19,12 +23,16 @@ type RPCClientDialer func(address string, opts ...grpc.DialOption) (*grpc.Client
 type DialOptionProvider func(address string) ([]grpc.DialOption, error)
 
 // ConnectionManager handles the lifecycle of gRPC client connections.
-// Ensures connections are reused and re-established when needed.
+// Ensures connections are reused and re-established when needed: every connection it creates is watched by a
+// supervisor goroutine (see supervise) until the connection is closed through the manager.
 type ConnectionManager struct {
-	// A mutex is used to protect the conns map during read/write operations to prevent race conditions.
+	// mut is held for reading while a connection is created and for writing while connections are closed,
+	// so that a connection can never be created and closed at the same time.
 	mut sync.RWMutex
 	// connections stores active gRPC connections, keyed by server address. || sync.Map is a thread-safe map
 	connections sync.Map
+	// dialLocks holds a *sync.Mutex per address, so that concurrent callers dial an address only once.
+	dialLocks   sync.Map
 	dialer      RPCClientDialer
 	dialOptions DialOptionProvider
 }
@@ -41,16 +49,23 @@ func NewConnectionManager(dialer RPCClientDialer, optionProvider DialOptionProvi
 }
 
 func (m *ConnectionManager) CreateOrGetConnection(address string, logger slog.Logger) (*grpc.ClientConn, error) {
-	// Ensure that the connection-checking and creation logic is atomic.
+	//////// 1. Check for an existing, healthy connection ---
+	if conn, ok := m.liveConnection(address); ok {
+		return conn, nil
+	}
+
 	m.mut.RLock()
 	defer m.mut.RUnlock()
 
-	//////// 1. Check for an existing, healthy connection ---
-	if conn, ok := m.connections.Load(address); ok && conn != nil {
-		clientConn := conn.(*grpc.ClientConn)
-		if clientConn.GetState() != connectivity.Shutdown {
-			return clientConn, nil
-		}
+	// only one caller dials an address, the others wait and get its connection
+	dialLock, _ := m.dialLocks.LoadOrStore(address, &sync.Mutex{})
+	dialLock.(*sync.Mutex).Lock()
+	defer dialLock.(*sync.Mutex).Unlock()
+
+	if conn, ok := m.liveConnection(address); ok {
+		return conn, nil
+	}
+	if _, ok := m.connections.Load(address); ok {
 		logger.Info("connection was already closed", "address", address)
 	}
 
@@ -69,11 +84,60 @@ func (m *ConnectionManager) CreateOrGetConnection(address string, logger slog.Lo
 		return nil, fmt.Errorf("error creating gRPC connection: %w", err)
 	}
 
-	//////// 4. Store the new connection for future reuse ---
+	//////// 4. Store the new connection for future reuse and watch it ---
 	m.connections.Store(address, newConn)
+	go m.supervise(address, newConn, logger)
 	return newConn, nil
 }
 
+func (m *ConnectionManager) liveConnection(address string) (*grpc.ClientConn, bool) {
+	if conn, ok := m.connections.Load(address); ok && conn != nil {
+		clientConn := conn.(*grpc.ClientConn)
+		if clientConn.GetState() != connectivity.Shutdown {
+			return clientConn, true
+		}
+	}
+	return nil, false
+}
+
+// ClientConn returns a grpc.ClientConnInterface that always calls through the current connection to address.
+// Generated clients built on it keep working after the supervisor replaced a connection that was shut down.
+func (m *ConnectionManager) ClientConn(address string, logger slog.Logger) grpc.ClientConnInterface {
+	return &managedConn{manager: m, address: address, logger: logger}
+}
+
+type managedConn struct {
+	manager *ConnectionManager
+	address string
+	logger  slog.Logger
+}
+
+func (c *managedConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	conn, err := c.manager.CreateOrGetConnection(c.address, c.logger)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *managedConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := c.manager.CreateOrGetConnection(c.address, c.logger)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+// ConnectionStates returns the connectivity state of every managed connection, keyed by address.
+func (m *ConnectionManager) ConnectionStates() map[string]string {
+	states := make(map[string]string)
+	m.connections.Range(func(key, value interface{}) bool {
+		states[key.(string)] = value.(*grpc.ClientConn).GetState().String()
+		return true
+	})
+	return states
+}
+
 func (m *ConnectionManager) CloseConnection(address string, log slog.Logger) {
 	m.mut.Lock()
 	defer m.mut.Unlock()
@@ -115,25 +179,41 @@ func (m *ConnectionManager) Close() {
 	})
 }
 
-// NewTLSDialOptionProvider creates a provider that configures a client for TLS.
-func NewTLSDialOptionProvider(certPath, serverName string) DialOptionProvider {
+// NewTLSDialOptionProvider creates a provider that configures a client for TLS, with a client certificate when
+// the reloader has one (mTLS). Rotated certificates are picked up by new connections, e.g. after a reconnect.
+func NewTLSDialOptionProvider(reloader *certs.Reloader) DialOptionProvider {
 	return func(address string) ([]grpc.DialOption, error) {
-		caCert, err := os.ReadFile(certPath)
-		if err != nil {
-			return nil, fmt.Errorf("could not read certificate: %w", err)
-		}
+		creds := credentials.NewTLS(reloader.ClientTLSConfig())
+		return []grpc.DialOption{grpc.WithTransportCredentials(creds)}, nil
+	}
+}
 
-		certPool := x509.NewCertPool()
-		if ok := certPool.AppendCertsFromPEM(caCert); !ok {
-			return nil, fmt.Errorf("could not append certificate to pool")
-		}
+// NewTracingDialOptionProvider instruments every RPC made on the connection with an OpenTelemetry client span,
+// tagged with the gRPC method, status code and the upstream service name.
+func NewTracingDialOptionProvider(serviceName string) DialOptionProvider {
+	return func(address string) ([]grpc.DialOption, error) {
+		statsHandler := otelgrpc.NewClientHandler(otelgrpc.WithSpanAttributes(tracing.UpstreamServiceKey.String(serviceName)))
+		return []grpc.DialOption{grpc.WithStatsHandler(statsHandler)}, nil
+	}
+}
 
-		creds := credentials.NewClientTLSFromCert(certPool, serverName)
-		return []grpc.DialOption{grpc.WithTransportCredentials(creds)}, nil
+// ChainDialOptionProviders combines providers into one, the options are returned in the order of the providers.
+func ChainDialOptionProviders(providers ...DialOptionProvider) DialOptionProvider {
+	return func(address string) ([]grpc.DialOption, error) {
+		var opts []grpc.DialOption
+		for _, provider := range providers {
+			providerOpts, err := provider(address)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, providerOpts...)
+		}
+		return opts, nil
 	}
 }
 
-func newInsecureDialOptionProvider() DialOptionProvider {
+// NewInsecureDialOptionProvider creates a provider for plaintext connections, e.g. to a local upstream.
+func NewInsecureDialOptionProvider() DialOptionProvider {
 	return func(address string) ([]grpc.DialOption, error) {
 		return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, nil
 	}
@@ -7,7 +7,10 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
 
 	"google.golang.org/grpc"
@@ -31,10 +34,15 @@ func (server *mockAccountServiceServer) FindWallet(context.Context, *v1.FindWall
 
 // newTestDialer creates a dialer function that connects to an in-memory gRPC server.
 func newRPCTestDialer() RPCClientDialer {
+	return newRPCTestDialerFor(&mockAccountServiceServer{})
+}
+
+// newRPCTestDialerFor creates a dialer function that connects to an in-memory gRPC server serving accountServer.
+func newRPCTestDialerFor(accountServer v1.AccountServiceServer) RPCClientDialer {
 	// a fake (simulated), in-memory network connection
 	listener := bufconn.Listen(1024 * 1024)
 	server := grpc.NewServer()
-	v1.RegisterAccountServiceServer(server, &mockAccountServiceServer{})
+	v1.RegisterAccountServiceServer(server, accountServer)
 
 	go func() {
 		if err := server.Serve(listener); err != nil {
@@ -58,7 +66,7 @@ func newRPCTestDialer() RPCClientDialer {
 }
 
 func TestConnectionManager_CreateOrGetConnection(t *testing.T) {
-	manager := NewConnectionManager(newRPCTestDialer(), newInsecureDialOptionProvider())
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
 	defer manager.Close()
 
 	// 1. first call should create connection
@@ -79,7 +87,7 @@ func TestConnectionManager_CreateOrGetConnection(t *testing.T) {
 }
 
 func TestConnectionManager_CloseAll(t *testing.T) {
-	manager := NewConnectionManager(newRPCTestDialer(), newInsecureDialOptionProvider())
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
 	defer manager.Close()
 
 	conn1, err := manager.CreateOrGetConnection(testGrpcAddress, *testLogger)
@@ -95,7 +103,7 @@ func TestConnectionManager_CloseAll(t *testing.T) {
 }
 
 func TestConnectionManager_CloseConnection(t *testing.T) {
-	manager := NewConnectionManager(newRPCTestDialer(), newInsecureDialOptionProvider())
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
 	defer manager.Close()
 
 	conn1, err := manager.CreateOrGetConnection(testGrpcAddress, *testLogger)
@@ -110,7 +118,7 @@ func TestConnectionManager_CloseConnection(t *testing.T) {
 }
 
 func TestConnectionManager_Close(t *testing.T) {
-	manager := NewConnectionManager(newRPCTestDialer(), newInsecureDialOptionProvider())
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
 	defer manager.Close()
 
 	conn1, err := manager.CreateOrGetConnection(testGrpcAddress, *testLogger)
@@ -123,3 +131,83 @@ func TestConnectionManager_Close(t *testing.T) {
 		t.Fatalf("Close() did not shutdown correctly")
 	}
 }
+
+func TestConnectionManager_CreateOrGetConnection_SingleFlight(t *testing.T) {
+	testDialer := newRPCTestDialer()
+	var dials atomic.Int32
+	countingDialer := func(address string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+		dials.Add(1)
+		// widen the window in which concurrent callers could race each other
+		time.Sleep(10 * time.Millisecond)
+		return testDialer(address, opts...)
+	}
+	manager := NewConnectionManager(countingDialer, NewInsecureDialOptionProvider())
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	conns := make([]*grpc.ClientConn, 20)
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conns[i], _ = manager.CreateOrGetConnection(testGrpcAddress, *testLogger)
+		}()
+	}
+	wg.Wait()
+
+	if dials.Load() != 1 {
+		t.Fatalf("address was dialed %d times, want 1", dials.Load())
+	}
+	for _, conn := range conns {
+		if conn != conns[0] {
+			t.Fatalf("CreateOrGetConnection() returned different connections")
+		}
+	}
+}
+
+func TestConnectionManager_RedialsClosedConnection(t *testing.T) {
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
+	defer manager.Close()
+
+	address := "passthrough:///" + testGrpcAddress
+	conn1, err := manager.CreateOrGetConnection(address, *testLogger)
+	if err != nil {
+		t.Fatalf("CreateOrGetConnection() failed: %v", err)
+	}
+	client := v1.NewAccountServiceClient(manager.ClientConn(address, *testLogger))
+
+	// closed behind the manager's back, the supervisor replaces it
+	_ = conn1.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if conn, ok := manager.liveConnection(address); ok && conn != conn1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the closed connection was not re-dialed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := client.FindWallet(context.Background(), &v1.FindWalletRequest{}); err != nil {
+		t.Fatalf("FindWallet() through the managed connection failed: %v", err)
+	}
+	if err := manager.ConnectionCheck(address)(context.Background()); err != nil {
+		t.Fatalf("ConnectionCheck() = %v, want healthy", err)
+	}
+}
+
+func TestConnectionManager_CloseAllStopsSupervision(t *testing.T) {
+	manager := NewConnectionManager(newRPCTestDialer(), NewInsecureDialOptionProvider())
+	defer manager.Close()
+
+	if _, err := manager.CreateOrGetConnection(testGrpcAddress, *testLogger); err != nil {
+		t.Fatalf("CreateOrGetConnection() failed: %v", err)
+	}
+	manager.CloseAll(*testLogger)
+	time.Sleep(50 * time.Millisecond)
+
+	if states := manager.ConnectionStates(); len(states) != 0 {
+		t.Fatalf("connections after CloseAll = %v, want none", states)
+	}
+}
@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ConnectionStateCheck reports the connection as unhealthy while it is in TransientFailure or Shutdown.
+// An idle connection is healthy, it is asked to connect so that the next check sees the real state.
+func ConnectionStateCheck(conn *grpc.ClientConn) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		switch state := conn.GetState(); state {
+		case connectivity.Ready, connectivity.Connecting:
+			return nil
+		case connectivity.Idle:
+			conn.Connect()
+			return nil
+		default:
+			return fmt.Errorf("connection to %s is %s", conn.Target(), state)
+		}
+	}
+}
+
+// ConnectionCheck is ConnectionStateCheck for the current connection to address, it follows the connection
+// when the supervisor replaces it.
+func (m *ConnectionManager) ConnectionCheck(address string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		conn, ok := m.liveConnection(address)
+		if !ok {
+			return fmt.Errorf("no open connection to %s", address)
+		}
+		return ConnectionStateCheck(conn)(ctx)
+	}
+}
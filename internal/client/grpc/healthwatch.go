@@ -0,0 +1,150 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthWatcher follows the grpc.health.v1 status of one service of an upstream through the Watch stream.
+// Servers that do not implement the health protocol are checked with the fallback instead (e.g. CheckHealth).
+type HealthWatcher struct {
+	mut    sync.Mutex
+	client healthpb.HealthClient
+	// service is the name registered in the upstream's health server, "" is the server as a whole
+	service  string
+	fallback func(ctx context.Context) error
+	logger   slog.Logger
+	// onChange is called whenever the watched status changes, e.g. to refresh readiness right away
+	onChange func()
+
+	watching      bool
+	servingStatus healthpb.HealthCheckResponse_ServingStatus
+	unimplemented bool
+}
+
+// Check reports the last status received on the Watch stream. Until the stream delivered one (or while it is
+// broken) it makes a Check call, and it uses the fallback once the upstream turned out not to implement the protocol.
+func (w *HealthWatcher) Check(ctx context.Context) error {
+	w.mut.Lock()
+	unimplemented, watching, servingStatus := w.unimplemented, w.watching, w.servingStatus
+	w.mut.Unlock()
+
+	if unimplemented {
+		return w.fallback(ctx)
+	}
+	if !watching {
+		resp, err := w.client.Check(ctx, &healthpb.HealthCheckRequest{Service: w.service})
+		if status.Code(err) == codes.Unimplemented {
+			w.markUnimplemented()
+			return w.fallback(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		servingStatus = resp.GetStatus()
+	}
+
+	if servingStatus != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q is %s", w.service, servingStatus)
+	}
+	return nil
+}
+
+// Watch follows the status of the service until ctx is done, re-opening the stream with a backoff when it breaks.
+// It returns right away when the upstream does not implement the health protocol.
+func (w *HealthWatcher) Watch(ctx context.Context) {
+	delay := minReconnectDelay
+	for ctx.Err() == nil {
+		received, err := w.watchOnce(ctx)
+		if status.Code(err) == codes.Unimplemented {
+			w.markUnimplemented()
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.logger.Warn("event=grpcHealthWatchBroken", "service", w.service, "error", err)
+		w.setStatus(false, healthpb.HealthCheckResponse_UNKNOWN)
+		if received {
+			delay = minReconnectDelay
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// watchOnce reads the Watch stream until it fails, received tells whether at least one status came through.
+func (w *HealthWatcher) watchOnce(ctx context.Context) (bool, error) {
+	stream, err := w.client.Watch(ctx, &healthpb.HealthCheckRequest{Service: w.service})
+	if err != nil {
+		return false, err
+	}
+	received := false
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		received = true
+		w.setStatus(true, resp.GetStatus())
+	}
+}
+
+func (w *HealthWatcher) setStatus(watching bool, servingStatus healthpb.HealthCheckResponse_ServingStatus) {
+	w.mut.Lock()
+	changed := w.watching != watching || w.servingStatus != servingStatus
+	w.watching = watching
+	w.servingStatus = servingStatus
+	w.mut.Unlock()
+
+	if !changed {
+		return
+	}
+	if watching {
+		w.logger.Info("event=grpcHealthStatus", "service", w.service, "status", servingStatus.String())
+	}
+	if w.onChange != nil {
+		w.onChange()
+	}
+}
+
+func (w *HealthWatcher) markUnimplemented() {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if !w.unimplemented {
+		w.logger.Info("event=grpcHealthUnimplemented, using the fallback check", "service", w.service)
+	}
+	w.unimplemented = true
+	w.watching = false
+}
+
+// OnChange registers a callback invoked whenever the watched status changes.
+func (w *HealthWatcher) OnChange(fn func()) {
+	w.onChange = fn
+}
+
+func NewHealthWatcher(conn grpc.ClientConnInterface, service string, fallback func(ctx context.Context) error, logger slog.Logger) *HealthWatcher {
+	return &HealthWatcher{
+		client:        healthpb.NewHealthClient(conn),
+		service:       service,
+		fallback:      fallback,
+		logger:        logger,
+		servingStatus: healthpb.HealthCheckResponse_UNKNOWN,
+	}
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func startHealthServer(t *testing.T, register func(server *grpc.Server)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	register(server)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func TestHealthWatcher_Watch(t *testing.T) {
+	const service = "xrfq3.account.v1.AccountService"
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	address := startHealthServer(t, func(server *grpc.Server) { healthpb.RegisterHealthServer(server, healthServer) })
+
+	manager := NewConnectionManager(nil, NewInsecureDialOptionProvider())
+	defer manager.Close()
+	watcher := NewHealthWatcher(manager.ClientConn(address, *testLogger), service, nil, *slog.New(slog.DiscardHandler))
+	changes := make(chan struct{}, 10)
+	watcher.OnChange(func() { changes <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Watch(ctx)
+
+	waitForChange := func() {
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status change was pushed")
+		}
+	}
+
+	waitForChange()
+	if err := watcher.Check(ctx); err != nil {
+		t.Fatalf("Check() = %v, want serving", err)
+	}
+
+	healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	waitForChange()
+	if err := watcher.Check(ctx); err == nil {
+		t.Fatal("Check() = nil, want an error once the service is not serving")
+	}
+}
+
+func TestHealthWatcher_FallsBackWithoutHealthService(t *testing.T) {
+	address := startHealthServer(t, func(server *grpc.Server) {})
+
+	manager := NewConnectionManager(nil, NewInsecureDialOptionProvider())
+	defer manager.Close()
+
+	var fallbackCalls atomic.Int32
+	fallbackErr := errors.New("CheckHealth: not up")
+	watcher := NewHealthWatcher(manager.ClientConn(address, *testLogger), "", func(ctx context.Context) error {
+		fallbackCalls.Add(1)
+		return fallbackErr
+	}, *slog.New(slog.DiscardHandler))
+
+	// Watch gives up right away on an Unimplemented health service
+	watcher.Watch(context.Background())
+
+	if err := watcher.Check(context.Background()); !errors.Is(err, fallbackErr) {
+		t.Fatalf("Check() = %v, want the fallback error", err)
+	}
+	if fallbackCalls.Load() != 1 {
+		t.Fatalf("fallback called %d times, want 1", fallbackCalls.Load())
+	}
+}
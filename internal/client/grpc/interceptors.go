@@ -0,0 +1,243 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// statusClientClosedRequest is the de-facto status for requests the caller gave up on, there is nobody to send it to
+// but it keeps cancelled calls apart from real failures in logs and metrics.
+const statusClientClosedRequest = 499
+
+// NewInterceptorDialOptionProvider installs the interceptors every upstream call goes through: they attach the
+// caller's metadata (fingerprint, request id, service token), apply the default deadline of the method and
+// turn gRPC statuses into HTTP errors. timeouts are the deadlines applied, they are taken from config when nil.
+func NewInterceptorDialOptionProvider(config internal.GrpcConfig, timeouts *MethodTimeouts) DialOptionProvider {
+	deadlines := timeouts
+	if deadlines == nil {
+		deadlines = NewMethodTimeouts(config)
+	}
+	return func(address string) ([]grpc.DialOption, error) {
+		return []grpc.DialOption{
+			grpc.WithChainUnaryInterceptor(unaryInterceptor(deadlines, config.ServiceToken)),
+			grpc.WithChainStreamInterceptor(streamInterceptor(deadlines, config.ServiceToken)),
+		}, nil
+	}
+}
+
+func unaryInterceptor(deadlines *MethodTimeouts, serviceToken string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = outgoingContext(ctx, serviceToken)
+		if timeout := deadlines.forMethod(method); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return ToHTTPError(invoker(ctx, method, req, reply, cc, opts...))
+	}
+}
+
+// streamInterceptor does not apply method deadlines, a stream lives as long as its caller's context.
+func streamInterceptor(_ *MethodTimeouts, serviceToken string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(outgoingContext(ctx, serviceToken), desc, cc, method, opts...)
+		if err != nil {
+			return nil, ToHTTPError(err)
+		}
+		return &errorMappingClientStream{ClientStream: stream}, nil
+	}
+}
+
+type errorMappingClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *errorMappingClientStream) SendMsg(m any) error {
+	return mapStreamError(s.ClientStream.SendMsg(m))
+}
+
+func (s *errorMappingClientStream) RecvMsg(m any) error {
+	return mapStreamError(s.ClientStream.RecvMsg(m))
+}
+
+// mapStreamError keeps io.EOF untouched, it is how a stream signals that it ended normally.
+func mapStreamError(err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return err
+	}
+	return ToHTTPError(err)
+}
+
+// outgoingContext adds the caller's metadata to ctx, values already set by the caller win.
+func outgoingContext(ctx context.Context, serviceToken string) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+
+	setIfMissing := func(key, value string) {
+		if value != "" && len(md.Get(key)) == 0 {
+			md.Set(key, value)
+		}
+	}
+	setIfMissing(internal.RequestIdHeader, server.RequestIdFromContext(ctx))
+	if userCtx, ok := server.UserFromContext(ctx); ok && userCtx != nil {
+		setIfMissing(internal.XrfUserFingerPrint, userCtx.Fingerprint)
+	}
+	if token := server.ServiceTokenFromContext(ctx); token != "" {
+		serviceToken = token
+	}
+	setIfMissing(internal.SrvToSrvToken, serviceToken)
+
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// methodDeadlines holds the default timeout of each method, keyed by full method name and by short method name.
+type methodDeadlines struct {
+	defaultTimeout time.Duration
+	byMethod       map[string]time.Duration
+}
+
+func newMethodDeadlines(config internal.GrpcConfig) methodDeadlines {
+	deadlines := methodDeadlines{defaultTimeout: config.DefaultTimeout, byMethod: make(map[string]time.Duration)}
+	for _, methodTimeout := range config.MethodTimeouts {
+		deadlines.byMethod[methodTimeout.Method] = methodTimeout.Timeout
+	}
+	return deadlines
+}
+
+// MethodTimeouts are the default deadlines of the methods of a service, Update replaces them while calls are being made
+// (e.g. on a configuration reload), calls already running keep the deadline they started with.
+type MethodTimeouts struct {
+	current atomic.Pointer[methodDeadlines]
+}
+
+func (t *MethodTimeouts) Update(config internal.GrpcConfig) {
+	deadlines := newMethodDeadlines(config)
+	t.current.Store(&deadlines)
+}
+
+func (t *MethodTimeouts) forMethod(fullMethod string) time.Duration {
+	return t.current.Load().forMethod(fullMethod)
+}
+
+func NewMethodTimeouts(config internal.GrpcConfig) *MethodTimeouts {
+	timeouts := &MethodTimeouts{}
+	timeouts.Update(config)
+	return timeouts
+}
+
+// forMethod returns the timeout of fullMethod ("/pkg.Service/Method"), configured either by its full or its short name.
+func (d methodDeadlines) forMethod(fullMethod string) time.Duration {
+	if timeout, ok := d.byMethod[fullMethod]; ok {
+		return timeout
+	}
+	if timeout, ok := d.byMethod[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return timeout
+	}
+	return d.defaultTimeout
+}
+
+// ToHTTPError converts a gRPC status error into the error types understood by response.WriteErrorResponse.
+// Client errors keep the upstream message, upstream failures are reported without their internal details.
+// Errors that do not carry a gRPC status are returned unchanged, errors it already converted are converted again
+// to the same result.
+func ToHTTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	var httpErr error
+	code := HTTPStatus(st)
+	switch {
+	case code == http.StatusBadGateway:
+		httpErr = &internal.APIClientError{Message: "upstream service failure", Code: code}
+	case code >= http.StatusInternalServerError:
+		httpErr = &internal.ExternalError{Message: http.StatusText(code), Code: code}
+	case code == statusClientClosedRequest:
+		httpErr = &internal.ExternalError{Message: "request cancelled", Code: code}
+	default:
+		httpErr = &internal.ExternalError{Message: st.Message(), Code: code}
+	}
+	return &statusError{err: httpErr, status: st}
+}
+
+// statusError is an HTTP error that keeps its gRPC status, so that status.Code and status.FromError still work
+// on errors returned through the interceptors (e.g. to detect Unimplemented).
+type statusError struct {
+	err    error
+	status *status.Status
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// HTTPStatus maps a gRPC status to the HTTP status returned to our callers.
+func HTTPStatus(st *status.Status) int {
+	switch st.Code() {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return statusClientClosedRequest
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		// field violations mean the request itself cannot be applied, anything else is a conflict with the resource state
+		if hasFieldViolations(st) {
+			return http.StatusUnprocessableEntity
+		}
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		// Unknown, Internal and DataLoss
+		return http.StatusBadGateway
+	}
+}
+
+func hasFieldViolations(st *status.Status) bool {
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok && len(badRequest.GetFieldViolations()) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/server"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type recordingAccountServer struct {
+	v1.UnimplementedAccountServiceServer
+	md       metadata.MD
+	deadline time.Duration
+	err      error
+}
+
+func (s *recordingAccountServer) FindAccountById(ctx context.Context, _ *v1.FindAccountByIdRequest) (*v1.FindAccountByIdResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		s.deadline = time.Until(deadline)
+	}
+	return &v1.FindAccountByIdResponse{}, s.err
+}
+
+func TestInterceptors(t *testing.T) {
+	accountServer := &recordingAccountServer{}
+	config := internal.GrpcConfig{
+		ServiceToken:   "default-token",
+		DefaultTimeout: time.Second,
+		MethodTimeouts: []internal.GrpcMethodTimeout{{Method: "FindAccountById", Timeout: time.Minute}},
+	}
+	timeouts := NewMethodTimeouts(config)
+	manager := NewConnectionManager(newRPCTestDialerFor(accountServer), ChainDialOptionProviders(
+		NewInsecureDialOptionProvider(),
+		NewInterceptorDialOptionProvider(config, timeouts),
+	))
+	defer manager.Close()
+
+	// passthrough skips DNS resolution of the in-memory address
+	conn, err := manager.CreateOrGetConnection("passthrough:///"+testGrpcAddress, *testLogger)
+	if err != nil {
+		t.Fatalf("CreateOrGetConnection() failed: %v", err)
+	}
+	client := v1.NewAccountServiceClient(conn)
+
+	t.Run("injects metadata from context and the method deadline", func(t *testing.T) {
+		ctx := server.ContextWithRequestId(context.Background(), "req-1")
+		ctx = server.ContextWithUserCtx(ctx, &model.UserContext{Fingerprint: "fp-1"})
+		if _, err := client.FindAccountById(ctx, &v1.FindAccountByIdRequest{}); err != nil {
+			t.Fatalf("FindAccountById() failed: %v", err)
+		}
+
+		expected := map[string]string{
+			internal.RequestIdHeader:    "req-1",
+			internal.XrfUserFingerPrint: "fp-1",
+			internal.SrvToSrvToken:      "default-token",
+		}
+		for key, value := range expected {
+			if got := accountServer.md.Get(key); len(got) != 1 || got[0] != value {
+				t.Errorf("metadata %q = %v, want %q", key, got, value)
+			}
+		}
+		if accountServer.deadline <= time.Second {
+			t.Errorf("deadline = %v, want the FindAccountById override", accountServer.deadline)
+		}
+	})
+
+	t.Run("updated timeouts apply to the next calls", func(t *testing.T) {
+		timeouts.Update(internal.GrpcConfig{DefaultTimeout: 2 * time.Second})
+		defer timeouts.Update(config)
+
+		if _, err := client.FindAccountById(context.Background(), &v1.FindAccountByIdRequest{}); err != nil {
+			t.Fatalf("FindAccountById() failed: %v", err)
+		}
+		if accountServer.deadline > 2*time.Second || accountServer.deadline <= time.Second {
+			t.Errorf("deadline = %v, want the updated default of 2s", accountServer.deadline)
+		}
+	})
+
+	t.Run("a service token in context wins over the default", func(t *testing.T) {
+		ctx := server.ContextWithServiceToken(context.Background(), "caller-token")
+		if _, err := client.FindAccountById(ctx, &v1.FindAccountByIdRequest{}); err != nil {
+			t.Fatalf("FindAccountById() failed: %v", err)
+		}
+		if got := accountServer.md.Get(internal.SrvToSrvToken); len(got) != 1 || got[0] != "caller-token" {
+			t.Errorf("service token = %v, want caller-token", got)
+		}
+	})
+
+	t.Run("maps status codes to HTTP errors", func(t *testing.T) {
+		accountServer.err = status.Error(codes.PermissionDenied, "not your account")
+		defer func() { accountServer.err = nil }()
+
+		_, err := client.FindAccountById(context.Background(), &v1.FindAccountByIdRequest{})
+		var externalErr *internal.ExternalError
+		if !errors.As(err, &externalErr) || externalErr.Code != http.StatusForbidden || externalErr.Message != "not your account" {
+			t.Fatalf("FindAccountById() error = %#v, want a 403 ExternalError", err)
+		}
+	})
+}
+
+func TestHTTPStatus(t *testing.T) {
+	withViolations, _ := status.New(codes.FailedPrecondition, "invalid").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "currency", Description: "unsupported"}},
+	})
+
+	tests := []struct {
+		status   *status.Status
+		expected int
+	}{
+		{status.New(codes.Unauthenticated, ""), http.StatusUnauthorized},
+		{status.New(codes.PermissionDenied, ""), http.StatusForbidden},
+		{status.New(codes.ResourceExhausted, ""), http.StatusTooManyRequests},
+		{status.New(codes.DeadlineExceeded, ""), http.StatusGatewayTimeout},
+		{status.New(codes.Unavailable, ""), http.StatusServiceUnavailable},
+		{status.New(codes.FailedPrecondition, "account is locked"), http.StatusConflict},
+		{withViolations, http.StatusUnprocessableEntity},
+		{status.New(codes.Internal, ""), http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		if got := HTTPStatus(test.status); got != test.expected {
+			t.Errorf("HTTPStatus(%s) = %d, want %d", test.status.Code(), got, test.expected)
+		}
+	}
+
+	var apiClientErr *internal.APIClientError
+	if err := ToHTTPError(status.Error(codes.Internal, "stack trace")); !errors.As(err, &apiClientErr) || apiClientErr.Message == "stack trace" {
+		t.Errorf("ToHTTPError() = %#v, want an APIClientError without upstream details", err)
+	}
+}
@@ -0,0 +1,67 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+	"xrf197ilz35aq/internal/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// NewMetricsDialOptionProvider records the latency and status code of every RPC made on the connection.
+func NewMetricsDialOptionProvider(serviceName string) DialOptionProvider {
+	return func(address string) ([]grpc.DialOption, error) {
+		return []grpc.DialOption{
+			grpc.WithChainUnaryInterceptor(unaryMetricsInterceptor(serviceName)),
+			grpc.WithChainStreamInterceptor(streamMetricsInterceptor(serviceName)),
+		}, nil
+	}
+}
+
+func unaryMetricsInterceptor(serviceName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		metrics.ObserveGrpcCall(serviceName, method, status.Code(err).String(), time.Since(start))
+		return err
+	}
+}
+
+func streamMetricsInterceptor(serviceName string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			metrics.ObserveGrpcCall(serviceName, method, status.Code(err).String(), time.Since(start))
+			return nil, err
+		}
+		return &metricsClientStream{ClientStream: stream, serviceName: serviceName, method: method, start: start}, nil
+	}
+}
+
+// metricsClientStream records the call once the stream ends, i.e. when RecvMsg returns an error (io.EOF on success).
+type metricsClientStream struct {
+	grpc.ClientStream
+	serviceName string
+	method      string
+	start       time.Time
+	once        sync.Once
+}
+
+func (s *metricsClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			callErr := err
+			if errors.Is(err, io.EOF) {
+				callErr = nil
+			}
+			metrics.ObserveGrpcCall(s.serviceName, s.method, status.Code(callErr).String(), time.Since(s.start))
+		})
+	}
+	return err
+}
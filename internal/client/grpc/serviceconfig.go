@@ -0,0 +1,149 @@
+package grpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// staticScheme is the resolver scheme of services configured with a list of addresses.
+const staticScheme = "static"
+
+const (
+	PickFirst  = "pick_first"
+	RoundRobin = "round_robin"
+)
+
+// Target returns the target to dial for a service: its Target (e.g. "dns:///account:50053") when set,
+// a static target resolved to Addresses when there are several, and Address otherwise.
+// It is also the key of the connection in the ConnectionManager.
+func Target(config internal.GrpcConfig) string {
+	switch {
+	case config.Target != "":
+		return config.Target
+	case len(config.Addresses) > 0:
+		return staticScheme + ":///" + strings.Join(config.Addresses, ",")
+	default:
+		return config.Address
+	}
+}
+
+// NewServiceConfigDialOptionProvider applies the load balancing policy, retry policy, keepalive and message
+// size limits of a service. Static addresses are handed to the connection through its own resolver.
+func NewServiceConfigDialOptionProvider(config internal.GrpcConfig) DialOptionProvider {
+	return func(address string) ([]grpc.DialOption, error) {
+		serviceConfig, err := serviceConfigJSON(config)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := []grpc.DialOption{grpc.WithDefaultServiceConfig(serviceConfig)}
+		if config.Keepalive.Time > 0 {
+			opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:                config.Keepalive.Time,
+				Timeout:             config.Keepalive.Timeout,
+				PermitWithoutStream: config.Keepalive.PermitWithoutStream,
+			}))
+		}
+
+		var callOpts []grpc.CallOption
+		if config.MaxRecvMsgSize > 0 {
+			callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(config.MaxRecvMsgSize))
+		}
+		if config.MaxSendMsgSize > 0 {
+			callOpts = append(callOpts, grpc.MaxCallSendMsgSize(config.MaxSendMsgSize))
+		}
+		if len(callOpts) > 0 {
+			opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+		}
+
+		if strings.HasPrefix(address, staticScheme+":///") {
+			opts = append(opts, grpc.WithResolvers(staticResolver(config.Addresses)))
+		}
+		return opts, nil
+	}
+}
+
+// staticResolver resolves the static target to addresses. A new resolver is built per dial, the manual
+// resolver only serves the connection it was given to.
+func staticResolver(addresses []string) resolver.Builder {
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addresses))}
+	for _, address := range addresses {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: address})
+	}
+	builder := manual.NewBuilderWithScheme(staticScheme)
+	builder.InitialState(state)
+	return builder
+}
+
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig"`
+	MethodConfig        []methodConfig        `json:"methodConfig,omitempty"`
+}
+
+type methodConfig struct {
+	// an empty name applies the config to every method of every service on the connection
+	Name        []struct{}   `json:"name"`
+	RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+}
+
+type retryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+func serviceConfigJSON(config internal.GrpcConfig) (string, error) {
+	policy := config.LoadBalancingPolicy
+	if policy == "" {
+		policy = PickFirst
+	}
+	if policy != PickFirst && policy != RoundRobin {
+		return "", fmt.Errorf("unsupported gRPC load balancing policy %q, expected %s or %s", policy, PickFirst, RoundRobin)
+	}
+	sc := serviceConfig{LoadBalancingConfig: []map[string]struct{}{{policy: {}}}}
+
+	if retry := config.Retry; retry.MaxAttempts > 1 {
+		codes := retry.RetryableStatusCodes
+		if len(codes) == 0 {
+			codes = []string{"UNAVAILABLE"}
+		}
+		multiplier := retry.BackoffMultiplier
+		if multiplier <= 0 {
+			multiplier = 2
+		}
+		sc.MethodConfig = []methodConfig{{
+			Name: []struct{}{{}},
+			RetryPolicy: &retryPolicy{
+				MaxAttempts:          retry.MaxAttempts,
+				InitialBackoff:       durationOrDefault(retry.InitialBackoff, 100*time.Millisecond),
+				MaxBackoff:           durationOrDefault(retry.MaxBackoff, time.Second),
+				BackoffMultiplier:    multiplier,
+				RetryableStatusCodes: codes,
+			},
+		}}
+	}
+
+	encoded, err := json.Marshal(sc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode gRPC service config: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// durationOrDefault formats a duration the way service config JSON expects it, e.g. "0.1s".
+func durationOrDefault(duration, defaultDuration time.Duration) string {
+	if duration <= 0 {
+		duration = defaultDuration
+	}
+	return fmt.Sprintf("%gs", duration.Seconds())
+}
@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
+	"xrf197ilz35aq/internal"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type countingAccountServer struct {
+	v1.UnimplementedAccountServiceServer
+	calls atomic.Int32
+	// failFirst calls fail with Unavailable
+	failFirst int32
+}
+
+func (s *countingAccountServer) FindWallet(context.Context, *v1.FindWalletRequest) (*v1.FindWalletResponse, error) {
+	if s.calls.Add(1) <= s.failFirst {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &v1.FindWalletResponse{}, nil
+}
+
+// startAccountServer serves accountServer on a random local port and returns its address.
+func startAccountServer(t *testing.T, accountServer v1.AccountServiceServer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	v1.RegisterAccountServiceServer(server, accountServer)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func TestServiceConfig_RoundRobinAcrossAddresses(t *testing.T) {
+	replicas := []*countingAccountServer{{}, {}, {}}
+	config := internal.GrpcConfig{LoadBalancingPolicy: RoundRobin}
+	for _, replica := range replicas {
+		config.Addresses = append(config.Addresses, startAccountServer(t, replica))
+	}
+
+	manager := NewConnectionManager(nil, ChainDialOptionProviders(NewInsecureDialOptionProvider(), NewServiceConfigDialOptionProvider(config)))
+	defer manager.Close()
+	conn, err := manager.CreateOrGetConnection(Target(config), *testLogger)
+	if err != nil {
+		t.Fatalf("CreateOrGetConnection() failed: %v", err)
+	}
+
+	client := v1.NewAccountServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for range 30 {
+		if _, err := client.FindWallet(ctx, &v1.FindWalletRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatalf("FindWallet() failed: %v", err)
+		}
+	}
+
+	for i, replica := range replicas {
+		if replica.calls.Load() == 0 {
+			t.Errorf("replica %d received no calls", i)
+		}
+	}
+}
+
+func TestServiceConfig_RetryPolicy(t *testing.T) {
+	accountServer := &countingAccountServer{failFirst: 2}
+	config := internal.GrpcConfig{
+		Address: startAccountServer(t, accountServer),
+		Retry:   internal.GrpcRetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+	}
+
+	manager := NewConnectionManager(nil, ChainDialOptionProviders(NewInsecureDialOptionProvider(), NewServiceConfigDialOptionProvider(config)))
+	defer manager.Close()
+	conn, err := manager.CreateOrGetConnection(Target(config), *testLogger)
+	if err != nil {
+		t.Fatalf("CreateOrGetConnection() failed: %v", err)
+	}
+
+	if _, err := v1.NewAccountServiceClient(conn).FindWallet(context.Background(), &v1.FindWalletRequest{}); err != nil {
+		t.Fatalf("FindWallet() failed after retries: %v", err)
+	}
+	if calls := accountServer.calls.Load(); calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestServiceConfig_RejectsUnknownPolicy(t *testing.T) {
+	provider := NewServiceConfigDialOptionProvider(internal.GrpcConfig{LoadBalancingPolicy: "least_request"})
+	if _, err := provider("localhost:50053"); err == nil {
+		t.Fatal("expected an error for an unsupported load balancing policy")
+	}
+}
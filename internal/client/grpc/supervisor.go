@@ -0,0 +1,81 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"xrf197ilz35aq/internal/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// supervise follows the state of conn until it is shut down. It logs and counts every transition, keeps the
+// connection warm when it goes idle, retries a connection stuck in TransientFailure sooner than grpc-go's own
+// backoff (up to 2 minutes) would, and re-dials an address whose connection was closed outside the manager.
+func (m *ConnectionManager) supervise(address string, conn *grpc.ClientConn, logger slog.Logger) {
+	reconnectDelay := minReconnectDelay
+	state := conn.GetState()
+	for {
+		switch state {
+		case connectivity.Shutdown:
+			m.handleShutdown(address, conn, logger)
+			return
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.Ready:
+			reconnectDelay = minReconnectDelay
+		}
+
+		changed := m.waitForStateChange(conn, state, reconnectDelay)
+		if !changed {
+			// still in TransientFailure after reconnectDelay: try again now and back off our own retries
+			logger.Info("event=grpcReconnect", "address", address, "delay", reconnectDelay)
+			conn.ResetConnectBackoff()
+			reconnectDelay = min(2*reconnectDelay, maxReconnectDelay)
+			continue
+		}
+
+		newState := conn.GetState()
+		logConnectionTransition(logger, address, state, newState)
+		metrics.IncGrpcConnectionTransition(address, state.String(), newState.String())
+		state = newState
+	}
+}
+
+// waitForStateChange blocks until conn leaves state. In TransientFailure it gives up after reconnectDelay and
+// returns false, every other state is waited on indefinitely.
+func (m *ConnectionManager) waitForStateChange(conn *grpc.ClientConn, state connectivity.State, reconnectDelay time.Duration) bool {
+	ctx := context.Background()
+	if state == connectivity.TransientFailure {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, reconnectDelay)
+		defer cancel()
+	}
+	return conn.WaitForStateChange(ctx, state)
+}
+
+// handleShutdown re-dials the address when conn was closed while still being the managed connection, connections
+// closed through CloseConnection/CloseAll are removed from the manager first and are left alone.
+func (m *ConnectionManager) handleShutdown(address string, conn *grpc.ClientConn, logger slog.Logger) {
+	if current, ok := m.connections.Load(address); !ok || current != conn {
+		return
+	}
+	logger.Warn("event=grpcConnectionShutdown, re-dialing", "address", address)
+	if _, err := m.CreateOrGetConnection(address, logger); err != nil {
+		logger.Error("event=grpcRedialFailure", "address", address, "error", err)
+	}
+}
+
+func logConnectionTransition(logger slog.Logger, address string, from, to connectivity.State) {
+	if to == connectivity.TransientFailure {
+		logger.Warn("event=grpcConnectionStateChange", "address", address, "from", from.String(), "to", to.String())
+		return
+	}
+	logger.Info("event=grpcConnectionStateChange", "address", address, "from", from.String(), "to", to.String())
+}
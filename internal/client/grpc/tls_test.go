@@ -0,0 +1,60 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"testing"
+	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/certs"
+	"xrf197ilz35aq/internal/certs/certtest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestTLSDialOptionProvider_MutualTLS(t *testing.T) {
+	ca := certtest.NewCA(t, "xrfq3-ca")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.Server(t, "localhost").TLSCertificate(t)},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	v1.RegisterAccountServiceServer(server, &mockAccountServiceServer{})
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	dir := t.TempDir()
+	clientPair := ca.Client(t, "xrf-se")
+	call := func(config internal.TLSConfig) error {
+		reloader, err := certs.NewReloader(config, slog.New(slog.DiscardHandler))
+		if err != nil {
+			t.Fatalf("NewReloader() failed: %v", err)
+		}
+		manager := NewConnectionManager(nil, NewTLSDialOptionProvider(reloader))
+		defer manager.Close()
+		conn, err := manager.CreateOrGetConnection(listener.Addr().String(), *testLogger)
+		if err != nil {
+			t.Fatalf("CreateOrGetConnection() failed: %v", err)
+		}
+		_, err = v1.NewAccountServiceClient(conn).FindWallet(context.Background(), &v1.FindWalletRequest{})
+		return err
+	}
+
+	config := internal.TLSConfig{Enabled: true, CAFile: certtest.WriteFile(t, dir, "ca.crt", ca.PEM), ServerName: "localhost"}
+	if err := call(config); err == nil {
+		t.Fatal("expected the server to reject a client without certificate")
+	}
+
+	config.CertFile = certtest.WriteFile(t, dir, "client.crt", clientPair.CertPEM)
+	config.KeyFile = certtest.WriteFile(t, dir, "client.key", clientPair.KeyPEM)
+	if err := call(config); err != nil {
+		t.Fatalf("FindWallet() over mTLS failed: %v", err)
+	}
+}
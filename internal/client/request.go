@@ -3,13 +3,23 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/metrics"
+	"xrf197ilz35aq/internal/redact"
+	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ApiClientResponse[T any] struct {
@@ -18,16 +28,60 @@ type ApiClientResponse[T any] struct {
 }
 
 type ApiClient struct {
-	baseURL        string
-	appId          string
-	httpClient     *http.Client
+	baseURL     string
+	appId       string
+	serviceName string
+	// httpClient is swapped as a whole by SetTimeout, the pointer is shared by copies of the ApiClient.
+	httpClient     *atomic.Pointer[http.Client]
 	defaultHeaders map[string]string
 	appConfig      internal.AppConfig
+	retry          retryPolicy
+	breakers       *circuitBreakers
 }
 
 type Option func(*ApiClient)
 
-func (c *ApiClient) do(ctx context.Context, method, path string, body interface{}, customHeaders map[string]string, into interface{}, log slog.Logger) error {
+type pathTemplateKey struct{}
+
+// ContextWithPathTemplate names the path template (e.g. "/user/{userId}") of the next call made with ctx.
+// Metrics and spans are labelled with the template instead of the raw path, which may contain ids.
+func ContextWithPathTemplate(ctx context.Context, pathTemplate string) context.Context {
+	return context.WithValue(ctx, pathTemplateKey{}, pathTemplate)
+}
+
+func pathTemplateFromContext(ctx context.Context, path string) string {
+	if pathTemplate, ok := ctx.Value(pathTemplateKey{}).(string); ok && pathTemplate != "" {
+		return pathTemplate
+	}
+	return path
+}
+
+func (c *ApiClient) do(ctx context.Context, method, path string, body interface{}, customHeaders map[string]string, into interface{}, log slog.Logger) (err error) {
+	pathTemplate := pathTemplateFromContext(ctx, path)
+	ctx, span := tracing.Tracer().Start(ctx, method+" "+pathTemplate,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(method),
+			semconv.URLPath(path),
+			semconv.URLTemplate(pathTemplate),
+			tracing.UpstreamServiceKey.String(c.serviceName),
+		),
+	)
+	start := time.Now()
+	statusCode := 0
+	errorReason := ""
+	defer func() {
+		metrics.ObserveUpstreamRequest(c.serviceName, method, pathTemplate, statusCode, time.Since(start))
+		if err != nil {
+			if errorReason == "" {
+				errorReason = upstreamErrorReason(statusCode)
+			}
+			metrics.IncUpstreamError(c.serviceName, method, pathTemplate, errorReason)
+		}
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	// 1. Create the full URL path.
 	fullURL := c.baseURL + path
 
@@ -39,7 +93,8 @@ func (c *ApiClient) do(ctx context.Context, method, path string, body interface{
 			log.Error("failed to marshal request body", "error", err)
 			return &internal.ServerError{Err: fmt.Errorf("failed to marshal request body: %w", err)}
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
+		// a bytes.Reader lets http.NewRequest set GetBody, so that the body can be re-sent on retries
+		reqBody = bytes.NewReader(jsonBody)
 	}
 
 	// 3. Create the HTTP request with context
@@ -68,9 +123,18 @@ func (c *ApiClient) do(ctx context.Context, method, path string, body interface{
 	}
 	// Always, set the service/app id header
 	req.Header.Set(internal.XrfHeaderAppId, c.appId)
+	// Forward the request id and trace context so upstream logs can be matched with ours
+	for k, v := range server.PropagationHeaders(ctx) {
+		req.Header.Set(k, v)
+	}
 
-	// 5. Execute the request
-	resp, err := c.httpClient.Do(req)
+	// 5. Execute the request, retrying transient failures
+	resp, err := c.execute(req, pathTemplate, log)
+	if errors.Is(err, ErrCircuitOpen) {
+		errorReason = "circuit_open"
+		log.Warn("upstream circuit breaker is open, failing fast", "service", c.serviceName, "host", req.URL.Host)
+		return &internal.APIClientError{Message: c.serviceName + " service is temporarily unavailable", Code: http.StatusServiceUnavailable}
+	}
 	if err != nil {
 		log.Error("failed to execute request", "error", err)
 		return &internal.ServerError{
@@ -79,7 +143,8 @@ func (c *ApiClient) do(ctx context.Context, method, path string, body interface{
 	}
 	defer resp.Body.Close()
 
-	statusCode := resp.StatusCode
+	statusCode = resp.StatusCode
+	span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
 	if statusCode < 200 || statusCode >= 400 {
 		var apiClientError internal.APIClientError
 		if err := parseClientResponse(resp.Body, &apiClientError, log); err != nil {
@@ -95,7 +160,7 @@ func (c *ApiClient) do(ctx context.Context, method, path string, body interface{
 			log.Error("failed to parse client response body", "error", err)
 			return err
 		}
-		log.Info("API client returned successfully", "code", statusCode, "body", into)
+		log.Info("API client returned successfully", "code", statusCode, "body", redact.Value(into))
 	}
 	return nil
 }
@@ -129,6 +194,121 @@ func (c *ApiClient) Delete(ctx context.Context, path string, customHeaders map[s
 	return c.do(ctx, http.MethodDelete, path, nil, customHeaders, into, log)
 }
 
+// execute sends req through the circuit breaker of its host and retries it, per the retry policy, when the
+// method is idempotent and the failure is transient (502/503/504 or a reset connection).
+func (c *ApiClient) execute(req *http.Request, pathTemplate string, log slog.Logger) (*http.Response, error) {
+	ctx := req.Context()
+	breaker := c.breakers.forHost(req.URL.Host)
+	retryableMethod := isRetryableMethod(req)
+
+	for attempt := 1; ; attempt++ {
+		if !breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		attemptReq, err := rewindRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Load().Do(attemptReq)
+		if ctx.Err() != nil {
+			// the caller cancelled or ran out of time (e.g. its request deadline), that says nothing about the host
+			breaker.release()
+		} else {
+			// 4xx means the upstream is up and answering, only transport errors and 5xx count against the host
+			breaker.record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		}
+
+		retryable := retryableMethod && attempt < c.retry.maxAttempts &&
+			(err != nil && isRetryableError(err) || err == nil && isRetryableStatus(resp.StatusCode))
+		if !retryable {
+			return resp, err
+		}
+
+		delay, ok := c.retry.delay(attempt, resp)
+		if deadline, hasDeadline := ctx.Deadline(); !ok || hasDeadline && time.Until(deadline) < delay {
+			// the wait asked for does not fit, hand the last outcome to the caller
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			_ = resp.Body.Close()
+		}
+
+		log.Warn("retrying upstream request", "service", c.serviceName, "method", req.Method, "path", pathTemplate,
+			"attempt", attempt, "delay", delay, "error", err)
+		metrics.IncUpstreamRetry(c.serviceName, req.Method, pathTemplate)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// rewindRequest returns the request to send for attempt, retries need a fresh copy of the body.
+func rewindRequest(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 1 || req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind request body: %w", err)
+	}
+	retryReq := req.Clone(req.Context())
+	retryReq.Body = body
+	return retryReq, nil
+}
+
+// CircuitBreakerCheck fails while the circuit breaker of an upstream host is open, it is a readiness check.
+func (c *ApiClient) CircuitBreakerCheck(_ context.Context) error {
+	return c.breakers.check()
+}
+
+// CircuitBreakerStates returns the state of the circuit breaker of every host called so far.
+func (c *ApiClient) CircuitBreakerStates() map[string]BreakerState {
+	return c.breakers.states()
+}
+
+// Ping checks that the upstream base URL answers, any response below 500 means the service is reachable.
+// It bypasses do() so probes do not show up in the upstream request metrics.
+func (c *ApiClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ping request: %w", err)
+	}
+	req.Header.Set(internal.XrfHeaderAppId, c.appId)
+
+	resp, err := c.httpClient.Load().Do(req)
+	if err != nil {
+		return fmt.Errorf("%s is unreachable: %w", c.serviceName, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s answered with status %d", c.serviceName, resp.StatusCode)
+	}
+	return nil
+}
+
+// upstreamErrorReason classifies a failed call for metrics, statusCode is 0 when no response was received.
+func upstreamErrorReason(statusCode int) string {
+	switch {
+	case statusCode == 0:
+		return "transport"
+	case statusCode >= 500:
+		return "5xx"
+	case statusCode >= 400:
+		return "4xx"
+	default:
+		return "decode"
+	}
+}
+
 func parseClientResponse(body io.Reader, into interface{}, log slog.Logger) error {
 	responseBytes, err := io.ReadAll(body)
 	if err != nil {
@@ -143,13 +323,28 @@ func parseClientResponse(body io.Reader, into interface{}, log slog.Logger) erro
 	return nil
 }
 
+// SetTimeout changes the timeout of the next attempts (e.g. on a configuration reload), values <=0 are ignored.
+func (c *ApiClient) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	httpClient := *c.httpClient.Load()
+	httpClient.Timeout = timeout
+	c.httpClient.Store(&httpClient)
+}
+
 func NewApiClient(baseURL string, appConfig internal.AppConfig, options ...Option) *ApiClient {
+	httpClient := &atomic.Pointer[http.Client]{}
+	httpClient.Store(&http.Client{Transport: newTransport(), Timeout: appConfig.DefaultClientTimeout})
 	apiClient := &ApiClient{
 		baseURL:        baseURL,
 		appConfig:      appConfig,
 		appId:          getAppId(),
-		httpClient:     http.DefaultClient,
+		serviceName:    "upstream",
+		httpClient:     httpClient,
 		defaultHeaders: make(map[string]string),
+		retry:          newRetryPolicy(internal.RetryConfig{}),
+		breakers:       newCircuitBreakers(internal.CircuitBreakerConfig{}),
 	}
 
 	// apply all the options
@@ -159,9 +354,41 @@ func NewApiClient(baseURL string, appConfig internal.AppConfig, options ...Optio
 	return apiClient
 }
 
+// WithTimeout bounds each attempt of a request, it defaults to AppConfig.DefaultClientTimeout.
 func WithTimeout(timeout time.Duration) Option {
 	return func(apiClient *ApiClient) {
-		apiClient.httpClient.Timeout = timeout
+		if timeout > 0 {
+			apiClient.httpClient.Load().Timeout = timeout
+		}
+	}
+}
+
+// WithRetry enables retries of idempotent requests, without it every request is attempted once.
+func WithRetry(config internal.RetryConfig) Option {
+	return func(apiClient *ApiClient) {
+		apiClient.retry = newRetryPolicy(config)
+	}
+}
+
+func WithCircuitBreaker(config internal.CircuitBreakerConfig) Option {
+	return func(apiClient *ApiClient) {
+		apiClient.breakers = newCircuitBreakers(config)
+	}
+}
+
+// WithTLS sets the TLS configuration of the client's transport, e.g. certs.Reloader.ClientTLSConfig for mTLS.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(apiClient *ApiClient) {
+		if transport, ok := apiClient.httpClient.Load().Transport.(*http.Transport); ok {
+			transport.TLSClientConfig = tlsConfig
+		}
+	}
+}
+
+// WithServiceName names the upstream service in traces and logs (e.g. "organization").
+func WithServiceName(serviceName string) Option {
+	return func(apiClient *ApiClient) {
+		apiClient.serviceName = serviceName
 	}
 }
 
@@ -177,6 +404,13 @@ func WithDefaultHeader(defaultHeaders map[string]string) Option {
 	}
 }
 
+// newTransport gives each client its own connection pool instead of sharing http.DefaultTransport.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	return transport
+}
+
 func getAppId() string {
 	return "xrf-aq-SE"
 }
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.DiscardHandler)
+
+func newTestClient(baseURL string, options ...Option) *ApiClient {
+	options = append([]Option{
+		WithRetry(internal.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}),
+	}, options...)
+	return NewApiClient(baseURL, internal.AppConfig{}, options...)
+}
+
+func TestApiClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"unavailable","code":503}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":200,"data":"ok"}`))
+	}))
+	defer upstream.Close()
+
+	t.Run("retries idempotent requests until they succeed", func(t *testing.T) {
+		calls.Store(0)
+		var response ApiClientResponse[string]
+		err := newTestClient(upstream.URL).Get(context.Background(), "/user/1", nil, &response, *testLogger)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", response.Data)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("does not retry a POST without an Idempotency-Key", func(t *testing.T) {
+		calls.Store(0)
+		err := newTestClient(upstream.URL).Post(context.Background(), "/user", map[string]string{}, nil, nil, *testLogger)
+		var apiClientErr *internal.APIClientError
+		assert.True(t, errors.As(err, &apiClientErr))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("retries a POST carrying an Idempotency-Key and re-sends its body", func(t *testing.T) {
+		calls.Store(0)
+		headers := map[string]string{internal.IdempotencyKey: "key-1"}
+		err := newTestClient(upstream.URL).Post(context.Background(), "/user", map[string]string{"name": "x"}, headers, nil, *testLogger)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+}
+
+func TestApiClientRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"maintenance","code":503}`))
+	}))
+	defer upstream.Close()
+
+	// waiting two minutes is longer than MaxDelay, the upstream answer is returned right away
+	err := newTestClient(upstream.URL).Get(context.Background(), "/user/1", nil, nil, *testLogger)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestApiClientCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"boom","code":500}`))
+	}))
+	defer upstream.Close()
+
+	apiClient := newTestClient(upstream.URL, WithCircuitBreaker(internal.CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}))
+	for range 2 {
+		_ = apiClient.Get(context.Background(), "/user/1", nil, nil, *testLogger)
+	}
+	assert.Error(t, apiClient.CircuitBreakerCheck(context.Background()))
+
+	err := apiClient.Get(context.Background(), "/user/1", nil, nil, *testLogger)
+	var apiClientErr *internal.APIClientError
+	assert.True(t, errors.As(err, &apiClientErr))
+	assert.Equal(t, http.StatusServiceUnavailable, apiClientErr.Code)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestApiClientCircuitBreakerIgnoresCallerCancellation(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		_, _ = w.Write([]byte(`{"code":200,"data":"ok"}`))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	apiClient := newTestClient(upstream.URL, WithCircuitBreaker(internal.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := apiClient.Get(ctx, "/user/1", nil, nil, *testLogger)
+		cancel()
+		assert.Error(t, err)
+	}
+
+	assert.NoError(t, apiClient.CircuitBreakerCheck(context.Background()), "request deadlines do not open the breaker")
+	for _, state := range apiClient.CircuitBreakerStates() {
+		assert.Equal(t, BreakerClosed, state)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	breakers := newCircuitBreakers(internal.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second})
+	breakers.now = func() time.Time { return now }
+	breaker := breakers.forHost("org")
+
+	assert.True(t, breaker.allow())
+	breaker.record(false)
+	assert.False(t, breaker.allow())
+
+	now = now.Add(time.Second)
+	assert.True(t, breaker.allow(), "a probe is let through after the open timeout")
+	assert.False(t, breaker.allow(), "only one probe at a time")
+	breaker.release()
+	assert.Equal(t, BreakerHalfOpen, breaker.currentState(), "a cancelled probe decides nothing")
+	assert.True(t, breaker.allow(), "the next call is the probe")
+	breaker.record(true)
+	assert.Equal(t, BreakerClosed, breaker.currentState())
+}
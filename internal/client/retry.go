@@ -0,0 +1,102 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+	"xrf197ilz35aq/internal"
+)
+
+const (
+	defaultBaseDelay = 100 * time.Millisecond
+	defaultMaxDelay  = 2 * time.Second
+)
+
+// retryPolicy decides whether and when a failed attempt is retried. MaxAttempts includes the first attempt.
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// backoff returns the delay before the next attempt: "full jitter" exponential backoff, a random delay
+// between zero and baseDelay*2^(attempt-1) capped at maxDelay, so that clients do not retry in lockstep.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.baseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.maxDelay {
+		ceiling = p.maxDelay
+	}
+	return rand.N(ceiling) + 1
+}
+
+// delay returns how long to wait before retrying, the upstream Retry-After header wins when it is set.
+// The second return value is false when Retry-After asks for a longer wait than maxDelay.
+func (p retryPolicy) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return retryAfter, retryAfter <= p.maxDelay
+		}
+	}
+	return p.backoff(attempt), true
+}
+
+// parseRetryAfter reads either form of the Retry-After header: delay seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// isRetryableMethod reports whether a request can safely be sent twice. POST/PATCH are only retried when
+// they carry an Idempotency-Key, the upstream then de-duplicates them.
+func isRetryableMethod(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return req.Header.Get(internal.IdempotencyKey) != ""
+	}
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isRetryableError reports transport errors where the upstream most likely did not process the request.
+// Timeouts are not retried, the upstream may still be working on the request.
+func isRetryableError(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+func newRetryPolicy(config internal.RetryConfig) retryPolicy {
+	policy := retryPolicy{maxAttempts: config.MaxAttempts, baseDelay: config.BaseDelay, maxDelay: config.MaxDelay}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = 1
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = defaultBaseDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = defaultMaxDelay
+	}
+	return policy
+}
@@ -1,26 +1,157 @@
 package internal
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
+// LogRotationConfig rotates the log file once it reaches MaxSizeMB, zero values keep the defaults
+// (100MB, 5 backups, 10 days).
+type LogRotationConfig struct {
+	MaxSizeMB  int  `yaml:"maxSizeMB"`
+	MaxBackups int  `yaml:"maxBackups"`
+	MaxAgeDays int  `yaml:"maxAgeDays"`
+	Compress   bool `yaml:"compress"`
+}
+
+// RedactionConfig adds to the JSON fields and headers always masked in logs (token, password, email, encryptionKey,
+// fingerprint and the auth headers). Fields match keys ending with them, case-insensitively.
+type RedactionConfig struct {
+	Fields  []string `yaml:"fields"`
+	Headers []string `yaml:"headers"`
+}
+
+// LogBodyRule sets the body logging policy of the routes under PathPrefix, the longest matching prefix wins.
+type LogBodyRule struct {
+	PathPrefix string `yaml:"pathPrefix"`
+	Policy     string `yaml:"policy"`
+}
+
+// LogBodyConfig decides what the request logger writes about response bodies. Policies are "none",
+// "metadata" (size and content type, the default) and "full" (the JSON body with sensitive fields masked).
+type LogBodyConfig struct {
+	Policy string        `yaml:"policy"`
+	Routes []LogBodyRule `yaml:"routes"`
+	// Bodies larger than LargeBodyBytes are logged in full for a LargeSampleRate fraction (0 to 1) of the
+	// requests only, the others get metadata. Zero LargeBodyBytes treats every body alike.
+	LargeBodyBytes  int     `yaml:"largeBodyBytes"`
+	LargeSampleRate float64 `yaml:"largeSampleRate"`
+}
+
 type LogConfig struct {
 	OutputFile string `yaml:"outputFile"`
+	// Level overrides the default level of the environment (DEBUG in dev, INFO in production), it can be reloaded.
+	Level string `yaml:"level"`
+	// Format is "console" (colored, the default in dev), "text" (the default in LIVE) or "json".
+	Format string `yaml:"format"`
+	// Output is "stdout" (the default in dev), "file" or "both" (the default in LIVE), the file is OutputFile.
+	Output   string            `yaml:"output"`
+	Rotation LogRotationConfig `yaml:"rotation"`
+	// PackageLevels override Level for a package and its sub-packages, keyed by import path
+	// (e.g. "xrf197ilz35aq/internal/client"), they can be reloaded.
+	PackageLevels map[string]string `yaml:"packageLevels"`
+	// DebugToken enables debug logs for the requests sending it in the Xrf-debug-log header, empty disables the header.
+	DebugToken string          `yaml:"debugToken" secret:"true"`
+	Redaction  RedactionConfig `yaml:"redaction"`
+	Body       LogBodyConfig   `yaml:"body"`
+}
+
+// GrpcMethodTimeout overrides the default deadline of one method, Method is either the full
+// ("/xrfq3.account.v1.AccountService/CreateAccount") or the short ("CreateAccount") method name.
+type GrpcMethodTimeout struct {
+	Method  string        `yaml:"method"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// GrpcRetryConfig is the retry policy of the gRPC service config, retries are made by grpc-go itself.
+// MaxAttempts includes the first attempt and is capped at 5 by grpc-go, 0 or 1 disables retries.
+type GrpcRetryConfig struct {
+	MaxAttempts       int           `yaml:"maxAttempts"`
+	InitialBackoff    time.Duration `yaml:"initialBackoff"`
+	MaxBackoff        time.Duration `yaml:"maxBackoff"`
+	BackoffMultiplier float64       `yaml:"backoffMultiplier"`
+	// RetryableStatusCodes are status code names (e.g. "UNAVAILABLE"), it defaults to UNAVAILABLE only.
+	RetryableStatusCodes []string `yaml:"retryableStatusCodes"`
+}
+
+// GrpcKeepaliveConfig pings the server after Time without activity, Time must not be shorter than the
+// server's enforcement policy (5m by default) or the server closes the connection.
+type GrpcKeepaliveConfig struct {
+	Time                time.Duration `yaml:"time"`
+	Timeout             time.Duration `yaml:"timeout"`
+	PermitWithoutStream bool          `yaml:"permitWithoutStream"`
 }
 
 type GrpcConfig struct {
 	Address string `yaml:"address"`
 	Port    string `yaml:"port"`
+	// Addresses lists the replicas of the service, Target is a gRPC target such as "dns:///account:50053".
+	// Target wins over Addresses, which wins over Address.
+	Addresses []string `yaml:"addresses"`
+	Target    string   `yaml:"target"`
+	// LoadBalancingPolicy is either "pick_first" (default) or "round_robin".
+	LoadBalancingPolicy string              `yaml:"loadBalancingPolicy"`
+	Retry               GrpcRetryConfig     `yaml:"retry"`
+	Keepalive           GrpcKeepaliveConfig `yaml:"keepalive"`
+	// MaxRecvMsgSize and MaxSendMsgSize are in bytes, zero keeps the grpc-go defaults (4MB received, unlimited sent).
+	MaxRecvMsgSize int       `yaml:"maxRecvMsgSize"`
+	MaxSendMsgSize int       `yaml:"maxSendMsgSize"`
+	TLS            TLSConfig `yaml:"tls"`
+	// HealthServices are the grpc.health.v1 service names watched for readiness, "" is the server as a whole.
+	// Upstreams that do not implement the health protocol fall back to their own health check.
+	HealthServices []string `yaml:"healthServices"`
+	// ServiceToken is sent as xrf-to-xrf-token on every call unless the request context carries its own.
+	ServiceToken string `yaml:"serviceToken" secret:"true"`
+	// DefaultTimeout is the deadline of calls whose context has none (or a later one), zero means no deadline.
+	DefaultTimeout time.Duration       `yaml:"defaultTimeout"`
+	MethodTimeouts []GrpcMethodTimeout `yaml:"methodTimeouts"`
+}
+
+// RetryConfig applies to idempotent requests failing with 502/503/504 or a reset connection.
+// MaxAttempts includes the first attempt, the delay between attempts is a jittered exponential backoff
+// starting at BaseDelay and capped at MaxDelay, unless the upstream sends Retry-After.
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"maxAttempts"`
+	BaseDelay   time.Duration `yaml:"baseDelay"`
+	MaxDelay    time.Duration `yaml:"maxDelay"`
+}
+
+// CircuitBreakerConfig opens the breaker of a host after FailureThreshold consecutive failures,
+// calls then fail fast for OpenTimeout before a single probe call is let through.
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold"`
+	OpenTimeout      time.Duration `yaml:"openTimeout"`
+}
+
+// TLSConfig secures the connection to an upstream. CAFile verifies the server (the system pool when empty),
+// CertFile/KeyFile are the client certificate presented for mTLS. Files are checked for rotation every ReloadInterval.
+type TLSConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	CAFile         string        `yaml:"caFile"`
+	CertFile       string        `yaml:"certFile"`
+	KeyFile        string        `yaml:"keyFile"`
+	ServerName     string        `yaml:"serverName"`
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
 }
 
 type OrgConfig struct {
-	BaseURL          string        `yaml:"baseURL"`
-	APIClientTimeout time.Duration `yaml:"apiClientTimeout"`
+	BaseURL          string               `yaml:"baseURL"`
+	APIClientTimeout time.Duration        `yaml:"apiClientTimeout"`
+	Retry            RetryConfig          `yaml:"retry"`
+	CircuitBreaker   CircuitBreakerConfig `yaml:"circuitBreaker"`
+	TLS              TLSConfig            `yaml:"tls"`
 }
 
 type ServiceConfig struct {
@@ -30,17 +161,110 @@ type ServiceConfig struct {
 
 type AppConfig struct {
 	Port int `yaml:"port"`
+	// AdminPort serves the operational endpoints (e.g. /metrics), it must not be exposed publicly.
+	AdminPort int `yaml:"adminPort"`
+	// MaxBodyBytes is the default request body limit, routes can override it when they are registered.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
 
 	IdleTimeout          time.Duration `yaml:"idleTimeout"`
 	ReadTimeout          time.Duration `yaml:"readTimeout"`
 	WriteTimeout         time.Duration `yaml:"writeTimeout"`
 	GracefulTimeout      time.Duration `yaml:"gracefulTimeout"`
 	DefaultClientTimeout time.Duration `yaml:"defaultClientTimeout"`
+	// PreStopDelay is how long the service keeps serving once readiness fails on shutdown, so that load balancers
+	// stop routing to it before its listeners close. GracefulTimeout then bounds the drain of in-flight requests.
+	PreStopDelay time.Duration `yaml:"preStopDelay"`
+	// ComponentCloseTimeout bounds the close of each component on shutdown (e.g. a connection or an audit sink).
+	ComponentCloseTimeout time.Duration `yaml:"componentCloseTimeout"`
+	// RequestTimeout is the deadline of a request, upstream calls are cancelled with it and the client gets a 504.
+	// RouteTimeouts override it per route, zero disables it.
+	RequestTimeout time.Duration  `yaml:"requestTimeout"`
+	RouteTimeouts  []RouteTimeout `yaml:"routeTimeouts"`
+
+	// TLS terminates TLS on the public port, HTTP/2 is then negotiated with ALPN.
+	TLS ServerTLSConfig `yaml:"tls"`
+	// H2C serves HTTP/2 without TLS (prior knowledge) next to HTTP/1.1, for deployments behind a plaintext L4 proxy.
+	H2C bool `yaml:"h2c"`
+	// TrustedProxies are the addresses (e.g. "10.0.0.7") or CIDRs (e.g. "10.0.0.0/8") of the proxies in front of the
+	// service, the client IP is taken from X-Forwarded-For only when the request comes from one of them.
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// ParseIPPrefix parses a CIDR or a single address, which is a prefix of its full length (e.g. an entry of
+// AppConfig.TrustedProxies).
+func ParseIPPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// RouteTimeout is the deadline of the requests whose path starts with PathPrefix, and whose method is Method when set.
+// Streaming routes get no deadline (nor server write timeout) and their response is not buffered.
+type RouteTimeout struct {
+	PathPrefix string        `yaml:"pathPrefix"`
+	Method     string        `yaml:"method"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Streaming  bool          `yaml:"streaming"`
+}
+
+// ServerTLSConfig is the TLS of a listener, CAFile (optional) is the CA of client certificates and ServerName is unused.
+type ServerTLSConfig struct {
+	TLSConfig `yaml:",inline"`
+	// MinVersion is "1.2" (the default) or "1.3".
+	MinVersion string `yaml:"minVersion"`
+	// CipherSuites restricts the TLS 1.2 cipher suites to these crypto/tls names, TLS 1.3 suites are not configurable.
+	CipherSuites []string `yaml:"cipherSuites"`
+	// RedirectPort, when set, serves a redirect of every plain HTTP request to the TLS port.
+	RedirectPort int `yaml:"redirectPort"`
+	// PublicHost is the host, optionally with a port, that clients reach the TLS port at, the redirects go to it
+	// rather than to the Host header of the request. The TLS port is added when it has no port and is not 443.
+	PublicHost string `yaml:"publicHost"`
+}
+
+// TLSMinVersion returns the crypto/tls constant of MinVersion.
+func (t ServerTLSConfig) TLSMinVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("must be 1.2 or 1.3, got %q", t.MinVersion)
+	}
+}
+
+// CipherSuiteIds returns the ids of CipherSuites, nil lets crypto/tls choose. Insecure suites are refused.
+func (t ServerTLSConfig) CipherSuiteIds() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
 
 type RedisConfig struct {
 	Address      string `yaml:"address"`
-	Password     string `yaml:"password"`
+	Password     string `yaml:"password" secret:"true"`
 	Database     int    `yaml:"database"`
 	Protocol     int    `yaml:"protocol"`
 	PoolSize     int    `yaml:"poolSize"`
@@ -51,11 +275,117 @@ type RedisConfig struct {
 	WriteTimeout int    `yaml:"writeTimeout"`
 }
 
+type IdempotencyConfig struct {
+	// Store is either "memory" or "redis", redis is needed to de-duplicate retries across replicas.
+	Store string        `yaml:"store"`
+	TTL   time.Duration `yaml:"ttl"`
+	// LockTimeout is how long a request holds its key before a retry may take over.
+	LockTimeout time.Duration `yaml:"lockTimeout"`
+}
+
+type RateLimitRule struct {
+	// RequestsPerSecond is the refill rate of the bucket, zero disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+// RateLimitGroup applies its own rule to every route under PathPrefix, the longest matching prefix wins.
+type RateLimitGroup struct {
+	Name          string `yaml:"name"`
+	PathPrefix    string `yaml:"pathPrefix"`
+	RateLimitRule `yaml:",inline"`
+}
+
+// RateLimitRules are the limits of a kind of bucket, the most specific group applies, Default to routes without one.
+type RateLimitRules struct {
+	Default RateLimitRule    `yaml:"default"`
+	Groups  []RateLimitGroup `yaml:"groups"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Store is either "memory" or "redis", redis is needed for the limits to hold across replicas.
+	Store string `yaml:"store"`
+	// RateLimitRules (default, groups) are the limits per user, applied once the request is authenticated.
+	RateLimitRules `yaml:",inline"`
+	// IP are the limits per client IP, applied to every request before its token is checked. Every user behind
+	// the same address (a NAT, or a load balancer missing from application.trustedProxies) shares them, so they
+	// are set higher than the limits per user.
+	IP RateLimitRules `yaml:"ip"`
+}
+
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Exporter is one of "otlp" (gRPC), "stdout" or "file", the stdout/file exporters are meant for local development.
+	Exporter    string `yaml:"exporter"`
+	Endpoint    string `yaml:"endpoint"`
+	Insecure    bool   `yaml:"insecure"`
+	FilePath    string `yaml:"filePath"`
+	ServiceName string `yaml:"serviceName"`
+	// SampleRatio is the fraction of new traces that are recorded, traces started upstream follow the caller's decision.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// AdminConfig protects the admin server listening on application.adminPort. Without a token or client certificates
+// it relies on the port not being reachable from outside.
+type AdminConfig struct {
+	// Token, when set, must be sent as "Authorization: Bearer <token>" on every admin request.
+	Token string `yaml:"token" secret:"true"`
+	// TLS serves the admin endpoints with CertFile and KeyFile, clients must present a certificate signed by
+	// CAFile when it is set (mTLS).
+	TLS TLSConfig `yaml:"tls"`
+}
+
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Sinks are "file" (the hash-chained log at FilePath) and "log" (the application log, to ship events elsewhere),
+	// it defaults to file.
+	Sinks    []string `yaml:"sinks"`
+	FilePath string   `yaml:"filePath"`
+	// SyncInterval is how often the file sink syncs to disk, zero syncs every event before the request goes on.
+	SyncInterval time.Duration `yaml:"syncInterval"`
+}
+
+// PublicRoute is a route served without an auth token, Method is an HTTP method or ANY.
+type PublicRoute struct {
+	Path   string `yaml:"path"`
+	Method string `yaml:"method"`
+}
+
+type AuthConfig struct {
+	// TokenCacheTTL is how long a validated token is trusted without asking the auth service, zero (the default)
+	// disables the cache. A revoked or expired token keeps working on this replica for up to this long.
+	TokenCacheTTL  time.Duration `yaml:"tokenCacheTTL"`
+	TokenCacheSize int           `yaml:"tokenCacheSize"`
+	// PublicRoutes are added to the built-in public routes (health probes, sign up and login).
+	PublicRoutes []PublicRoute `yaml:"publicRoutes"`
+}
+
+type HealthConfig struct {
+	// Interval is how often readiness checks run, Timeout bounds each individual check.
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// NonCritical lists dependencies (xrfq3, xrfq3/<health service>, xrfq3Connection, organization,
+	// organizationCircuitBreaker, redis) that are reported but do not fail readiness, every other dependency is critical.
+	NonCritical []string `yaml:"nonCritical"`
+}
+
 type Config struct {
-	Log         LogConfig     `yml:"log"`
-	Redis       RedisConfig   `yml:"redis"`
-	Service     ServiceConfig `yml:"service"`
-	Application AppConfig     `yml:"application"`
+	// Environment is one of DEV, STAGING or LIVE and must match the environment the file is loaded for.
+	Environment string            `yaml:"environment"`
+	Log         LogConfig         `yaml:"log"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Service     ServiceConfig     `yaml:"service"`
+	Application AppConfig         `yaml:"application"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	RateLimit   RateLimitConfig   `yaml:"rateLimit"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Health      HealthConfig      `yaml:"health"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Admin       AdminConfig       `yaml:"admin"`
+	// Features are feature flags by name, a flag missing from the map is off.
+	Features map[string]bool `yaml:"features"`
 }
 
 var (
@@ -66,41 +396,162 @@ var (
 
 func loadConfigs(env string) (*Config, error) {
 	configOnce.Do(func() {
-		configFilePath := fmt.Sprintf("config-%s.yaml", env)
-		viper.SetConfigName(configFilePath)
-		viper.AddConfigPath("./configs")
-		viper.SetConfigType("yaml")
+		config, configErr = readConfigFile(env)
+	})
 
-		// AutomaticEnv check for an environment variable any time a viper.Get request is made.
+	// Important: Check the global error variable *after* once.Do.
+	if configErr != nil {
+		return nil, configErr // Return the stored error
+	}
+	return config, nil
+}
 
-		// Rules: viper checks for an environment variable w/ a name matching the key uppercased and prefixed with the EnvPrefix if set.
-		viper.AutomaticEnv()
-		viper.SetEnvPrefix("XRF_SE") // will be uppercased automatically
-		// this is useful, e.g., want to use . in Get() calls, but environmental variables are to use _ delimiters (e.g., app.port -> APP_PORT)
-		viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+// configDir holds config.yaml, the base shared by every environment, and the config-<env>.yaml overlays.
+const configDir = "configs"
 
-		// Read the config file
-		err := viper.ReadInConfig()
-		if err != nil {
-			configErr = fmt.Errorf("failed to read config file: %w :: env=%s", err, env)
-			return
+// EnvPrefix prefixes the environment variables overriding configuration keys, see readConfigFiles.
+const EnvPrefix = "XRF_SE"
+
+// ConfigFiles are the files the configuration of env is merged from, base first, they are also the files
+// watched for reloads. Environment names are case-insensitive, files are named in lowercase (config-staging.yaml).
+func ConfigFiles(env string) []string {
+	return configFiles(configDir, env)
+}
+
+func configFiles(dir, env string) []string {
+	return []string{
+		filepath.Join(dir, "config.yaml"),
+		filepath.Join(dir, fmt.Sprintf("config-%s.yaml", strings.ToLower(env))),
+	}
+}
+
+func readConfigFile(env string) (*Config, error) {
+	return readConfigFiles(configDir, env)
+}
+
+// readConfigFiles reads, decodes and validates the configuration of env, it is used for the initial load and
+// for reloads. From the highest precedence to the lowest, a key is taken from:
+//  1. XRF_SE_<KEY>_FILE, the path of a file holding the value, for secrets (fields tagged `secret:"true"`) only
+//  2. XRF_SE_<KEY>, e.g. XRF_SE_APPLICATION_PORT for application.port (camelCase keys are uppercased as a whole,
+//     XRF_SE_APPLICATION_ADMINPORT), lists take comma separated values
+//  3. config-<env>.yaml, the overlay of the environment, optional
+//  4. config.yaml, the base
+//
+// The overlay is deep-merged into the base: maps are merged key by key, lists and scalars replace the base value.
+func readConfigFiles(dir, env string) (*Config, error) {
+	files := configFiles(dir, env)
+	v := viper.New()
+	v.SetConfigFile(files[0])
+	v.SetConfigType("yaml")
+
+	v.SetEnvPrefix(EnvPrefix) // will be uppercased automatically
+	// this is useful, e.g., want to use . in Get() calls, but environmental variables are to use _ delimiters (e.g., app.port -> APP_PORT)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	// keys are bound explicitly, AutomaticEnv alone only overrides keys present in the files
+	forEachConfigKey(func(key string, _ reflect.StructField) {
+		_ = v.BindEnv(key)
+	})
+
+	// Read the config files
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w :: env=%s", err, env)
+	}
+	overlay, err := os.Open(files[1])
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read config file: %w :: env=%s", err, env)
+	}
+	if overlay != nil {
+		defer overlay.Close()
+		if err := v.MergeConfig(overlay); err != nil {
+			return nil, fmt.Errorf("failed to merge config file %s: %w :: env=%s", files[1], err, env)
 		}
+	}
 
-		appConfig := Config{}
-		err = viper.Unmarshal(&appConfig)
+	appConfig, err := unmarshalConfig(v, env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file: %w :: env=%s", err, env)
+	}
+	return appConfig, nil
+}
+
+// resolveSecretFiles sets the secrets given as XRF_SE_<KEY>_FILE to the content of their file, the trailing
+// newline left by editors and `echo` is dropped. Setting both XRF_SE_<KEY> and XRF_SE_<KEY>_FILE is an error.
+func resolveSecretFiles(v *viper.Viper) error {
+	var errs []error
+	forEachConfigKey(func(key string, field reflect.StructField) {
+		if field.Tag.Get("secret") != "true" {
+			return
+		}
+		envName := configKeyEnv(key)
+		file, ok := os.LookupEnv(envName + "_FILE")
+		if !ok {
+			return
+		}
+		if _, set := os.LookupEnv(envName); set {
+			errs = append(errs, fmt.Errorf("%s: both %s and %s_FILE are set", key, envName, envName))
+			return
+		}
+		content, err := os.ReadFile(file)
 		if err != nil {
-			configErr = fmt.Errorf("failed to unmarshal config file: %w :: env=%s", err, env)
+			errs = append(errs, fmt.Errorf("%s: cannot read %s_FILE: %w", key, envName, err))
 			return
 		}
+		v.Set(key, strings.TrimRight(string(content), "\r\n"))
+	})
+	return errors.Join(errs...)
+}
 
-		config = &appConfig
+func configKeyEnv(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// forEachConfigKey calls fn with the key (e.g. "service.account.serviceToken") of every field of Config
+// that is not a struct, lists of structs are not walked into.
+func forEachConfigKey(fn func(key string, field reflect.StructField)) {
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, inline := configKeyName(field)
+			key := prefix
+			if !inline {
+				key = joinConfigKey(prefix, name)
+			}
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				walk(key, field.Type)
+				continue
+			}
+			fn(key, field)
+		}
+	}
+	walk("", reflect.TypeOf(Config{}))
+}
+
+// unmarshalConfig decodes the configuration using the yaml tags, keys that do not match a field are
+// reported instead of being silently ignored. Decoding and validation problems are returned together.
+func unmarshalConfig(v *viper.Viper, env string) (*Config, error) {
+	validator := &configValidator{}
+	if err := resolveSecretFiles(v); err != nil {
+		validator.addError(err)
+	}
+	appConfig := Config{}
+	err := v.Unmarshal(&appConfig, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+		dc.SquashTagOption = "inline"
+		dc.ErrorUnused = true
 	})
+	if err != nil {
+		validator.addError(err)
+	}
 
-	// Important: Check the global error variable *after* once.Do.
-	if configErr != nil {
-		return nil, configErr // Return the stored error
+	appConfig.validate(validator)
+	if appConfig.Environment != "" && !strings.EqualFold(appConfig.Environment, env) {
+		validator.addf("environment", "is %q but the configuration was loaded for %q", appConfig.Environment, env)
 	}
-	return config, nil
+	if err := validator.err(); err != nil {
+		return nil, err
+	}
+	return &appConfig, nil
 }
 
 func GetConfig(env string) (*Config, error) {
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"reflect"
+	"time"
+)
+
+// RedactedValue replaces the value of fields tagged `secret:"true"`, empty secrets are left empty
+// so that a missing secret still shows up in the dump.
+const RedactedValue = "[REDACTED]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Redacted returns the effective configuration keyed like the config file, with secrets replaced by
+// RedactedValue and durations written the way they are configured (e.g. "30s"). It is meant to be logged
+// at startup and served on the admin server, never to be decoded back.
+func (c *Config) Redacted() map[string]any {
+	return redactStruct(reflect.ValueOf(*c))
+}
+
+func redactStruct(v reflect.Value) map[string]any {
+	out := make(map[string]any, v.NumField())
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, inline := configKeyName(field)
+		if inline && field.Type.Kind() == reflect.Struct {
+			for k, value := range redactStruct(v.Field(i)) {
+				out[k] = value
+			}
+			continue
+		}
+		if field.Tag.Get("secret") == "true" {
+			if !v.Field(i).IsZero() {
+				out[name] = RedactedValue
+			} else {
+				out[name] = ""
+			}
+			continue
+		}
+		out[name] = redactValue(v.Field(i))
+	}
+	return out
+}
+
+func redactValue(v reflect.Value) any {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return redactStruct(v)
+	case reflect.Slice:
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = redactValue(v.Index(i))
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validConfig = `
+environment: DEV
+redis:
+  address: "127.0.0.1:6379"
+  password: "redis-secret"
+application:
+  port: 8008
+  adminPort: 9008
+  gracefulTimeout: 15s
+  defaultClientTimeout: 20s
+service:
+  account:
+    address: "localhost:50053"
+    serviceToken: "srv-token"
+  organization:
+    baseURL: "http://127.0.0.1:8009/api/v1"
+rateLimit:
+  enabled: true
+  default:
+    requestsPerSecond: 20
+    burst: 40
+  groups:
+    - name: "auth"
+      pathPrefix: "/api/v1/auth"
+      requestsPerSecond: 1
+      burst: 5
+  ip:
+    default:
+      requestsPerSecond: 200
+      burst: 400
+health:
+  interval: 10s
+  timeout: 2s
+`
+
+func readConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(strings.NewReader(content)))
+	return unmarshalConfig(v, "dev")
+}
+
+func configProblems(t *testing.T, err error) []string {
+	t.Helper()
+	var configErr *ConfigError
+	require.ErrorAs(t, err, &configErr)
+	return configErr.Problems
+}
+
+func TestUnmarshalConfig_Valid(t *testing.T) {
+	config, err := readConfig(t, validConfig)
+	require.NoError(t, err)
+
+	assert.Equal(t, DevelopEnv, config.Environment)
+	assert.Equal(t, 9008, config.Application.AdminPort)
+	assert.Equal(t, "20s", config.Application.DefaultClientTimeout.String())
+	require.Len(t, config.RateLimit.Groups, 1)
+	assert.Equal(t, 5, config.RateLimit.Groups[0].Burst, "inline rule fields are decoded into the group")
+	assert.Equal(t, 400, config.RateLimit.IP.Default.Burst)
+	assert.Empty(t, config.RateLimit.IP.Groups)
+}
+
+func TestUnmarshalConfig_UnknownKeys(t *testing.T) {
+	content := strings.Replace(validConfig, "  defaultClientTimeout: 20s", "  apiClientTimeout: 20s", 1)
+
+	_, err := readConfig(t, content)
+
+	problems := configProblems(t, err)
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "application")
+	assert.Contains(t, problems[0], "apiclienttimeout")
+}
+
+func TestUnmarshalConfig_AggregatesProblems(t *testing.T) {
+	content := strings.NewReplacer(
+		"environment: DEV", "environment: PROD",
+		"adminPort: 9008", "adminPort: 8008",
+		"gracefulTimeout: 15s", "gracefulTimeout: soon",
+		`baseURL: "http://127.0.0.1:8009/api/v1"`, `baseURL: "127.0.0.1:8009"`,
+		`pathPrefix: "/api/v1/auth"`, `pathPrefix: "api/v1/auth"`,
+		"timeout: 2s", "timeout: 20s",
+	).Replace(validConfig)
+
+	_, err := readConfig(t, content)
+
+	problems := strings.Join(configProblems(t, err), "\n")
+	for _, path := range []string{
+		"environment:",
+		"application.adminPort:",
+		"gracefulTimeout",
+		"service.organization.baseURL:",
+		"rateLimit.groups[0].pathPrefix:",
+		"health.timeout:",
+	} {
+		assert.Contains(t, problems, path)
+	}
+}
+
+func TestUnmarshalConfig_EnvironmentMismatch(t *testing.T) {
+	content := strings.Replace(validConfig, "environment: DEV", "environment: LIVE\nlog:\n  outputFile: x.log", 1)
+
+	_, err := readConfig(t, content)
+
+	problems := configProblems(t, err)
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], `loaded for "dev"`)
+}
+
+func TestValidate_CrossFieldRules(t *testing.T) {
+	config, err := readConfig(t, validConfig)
+	require.NoError(t, err)
+
+	config.Idempotency.Store = "redis"
+	config.Redis.Address = ""
+	config.Service.Account.Address = ""
+	config.Service.Organization.TLS.Enabled = true
+	config.Tracing = TracingConfig{Enabled: true, Exporter: "file", SampleRatio: 2}
+	config.Application.WriteTimeout = 5 * time.Second
+	config.Application.RouteTimeouts = []RouteTimeout{{PathPrefix: "/api/v1/account", Timeout: 5 * time.Second}}
+	config.Application.TrustedProxies = []string{"10.0.0.0/8", "10.0.0.7", "proxy.internal"}
+	config.Audit = AuditConfig{Enabled: true, FilePath: "audit.jsonl", SyncInterval: -time.Second}
+	config.Application.TLS = ServerTLSConfig{TLSConfig: TLSConfig{Enabled: true}, RedirectPort: 8080, PublicHost: "https://api.example.com/"}
+	config.Application.RequestTimeout = 2 * time.Second
+	config.Service.Organization.APIClientTimeout = 6 * time.Second
+	config.Service.Account.DefaultTimeout = time.Second
+	config.Service.Account.MethodTimeouts = []GrpcMethodTimeout{{Method: "FindAccountById", Timeout: 3 * time.Second}}
+	config.RateLimit.IP = RateLimitRules{
+		Default: RateLimitRule{RequestsPerSecond: 200},
+		Groups:  []RateLimitGroup{{Name: "auth", PathPrefix: "api/v1/auth"}},
+	}
+
+	problems := strings.Join(configProblems(t, config.Validate()), "\n")
+	assert.Contains(t, problems, "redis.address: is required")
+	assert.Contains(t, problems, "service.account: one of address, addresses or target is required")
+	assert.Contains(t, problems, "service.organization.baseURL: must use https")
+	assert.Contains(t, problems, "tracing.filePath: is required")
+	assert.Contains(t, problems, "tracing.sampleRatio:")
+	assert.Contains(t, problems, "application.routeTimeouts[0].timeout: must be shorter than the write timeout (5s)")
+	assert.Contains(t, problems, `application.trustedProxies[2]: must be an IP address or a CIDR, got "proxy.internal"`)
+	assert.NotContains(t, problems, "application.trustedProxies[0]")
+	assert.NotContains(t, problems, "application.trustedProxies[1]")
+	assert.Contains(t, problems, "audit.syncInterval: must not be negative")
+	assert.Contains(t, problems, "rateLimit.ip.default.burst: must be positive when requestsPerSecond is set")
+	assert.Contains(t, problems, `rateLimit.ip.groups[0].pathPrefix: must start with /, got "api/v1/auth"`)
+	assert.Contains(t, problems, `application.tls.publicHost: must be a host or host:port, got "https://api.example.com/"`)
+	assert.Contains(t, problems, "service.organization.apiClientTimeout: must not be longer than the longest request deadline (5s)")
+	assert.NotContains(t, problems, "service.account.defaultTimeout")
+	assert.NotContains(t, problems, "service.account.methodTimeouts[0]", "the route timeout is the longest deadline")
+
+	config.Application.TLS.PublicHost = ""
+	problems = strings.Join(configProblems(t, config.Validate()), "\n")
+	assert.Contains(t, problems, "application.tls.publicHost: is required with a redirect port")
+
+	config.Application.TLS.PublicHost = "api.example.com:8443"
+	problems = strings.Join(configProblems(t, config.Validate()), "\n")
+	assert.NotContains(t, problems, "application.tls.publicHost")
+}
+
+func TestRedacted(t *testing.T) {
+	config, err := readConfig(t, validConfig)
+	require.NoError(t, err)
+
+	dump := config.Redacted()
+
+	redis := dump["redis"].(map[string]any)
+	assert.Equal(t, RedactedValue, redis["password"])
+	assert.Equal(t, "127.0.0.1:6379", redis["address"])
+	account := dump["service"].(map[string]any)["account"].(map[string]any)
+	assert.Equal(t, RedactedValue, account["serviceToken"])
+	assert.Equal(t, "15s", dump["application"].(map[string]any)["gracefulTimeout"])
+	group := dump["rateLimit"].(map[string]any)["groups"].([]any)[0].(map[string]any)
+	assert.Equal(t, 5, group["burst"], "inline fields are flattened like in the file")
+	assert.NotContains(t, group, "RateLimitRule")
+}
+
+// writeConfigDir writes config.yaml (the valid config without environment) and the overlays, by file name.
+func writeConfigDir(t *testing.T, overlays map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	base := strings.Replace(validConfig, "environment: DEV", "", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(base), 0o600))
+	for name, content := range overlays {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	return dir
+}
+
+func TestReadConfigFiles_MergeSemantics(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"config-staging.yaml": `
+environment: STAGING
+application:
+  port: 8080
+rateLimit:
+  groups:
+    - name: "accounts"
+      pathPrefix: "/api/v1/account"
+      requestsPerSecond: 10
+      burst: 20
+`})
+
+	config, err := readConfigFiles(dir, "STAGING")
+	require.NoError(t, err)
+
+	assert.Equal(t, StagingEnv, config.Environment, "env names are case-insensitive, the overlay is lowercase")
+	assert.Equal(t, 8080, config.Application.Port, "overlay scalars win")
+	assert.Equal(t, 9008, config.Application.AdminPort, "maps are merged key by key")
+	assert.Equal(t, "127.0.0.1:6379", config.Redis.Address, "sections missing from the overlay come from the base")
+	require.Len(t, config.RateLimit.Groups, 1, "lists are replaced, not appended")
+	assert.Equal(t, "accounts", config.RateLimit.Groups[0].Name)
+	assert.Equal(t, 40, config.RateLimit.Default.Burst)
+}
+
+func TestReadConfigFiles_MissingOverlay(t *testing.T) {
+	dir := writeConfigDir(t, nil)
+
+	_, err := readConfigFiles(dir, "LIVE")
+
+	problems := configProblems(t, err)
+	assert.Equal(t, []string{"environment: is required"}, problems)
+}
+
+func TestReadConfigFiles_EnvironmentVariables(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"config-dev.yaml": "environment: DEV\napplication:\n  port: 8080\n"})
+	t.Setenv("XRF_SE_APPLICATION_PORT", "8090")
+	t.Setenv("XRF_SE_LOG_LEVEL", "WARN")
+	t.Setenv("XRF_SE_APPLICATION_GRACEFULTIMEOUT", "5s")
+
+	config, err := readConfigFiles(dir, "dev")
+	require.NoError(t, err)
+
+	assert.Equal(t, 8090, config.Application.Port, "env vars win over the overlay")
+	assert.Equal(t, "WARN", config.Log.Level, "keys missing from the files can be set")
+	assert.Equal(t, 5*time.Second, config.Application.GracefulTimeout)
+}
+
+func TestReadConfigFiles_SecretFiles(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"config-dev.yaml": "environment: DEV\n"})
+	secret := filepath.Join(t.TempDir(), "redis-password")
+	require.NoError(t, os.WriteFile(secret, []byte("from-file\n"), 0o600))
+
+	t.Run("the file content is the value", func(t *testing.T) {
+		t.Setenv("XRF_SE_REDIS_PASSWORD_FILE", secret)
+
+		config, err := readConfigFiles(dir, "dev")
+		require.NoError(t, err)
+		assert.Equal(t, "from-file", config.Redis.Password)
+	})
+
+	t.Run("setting the value and the file is an error", func(t *testing.T) {
+		t.Setenv("XRF_SE_REDIS_PASSWORD_FILE", secret)
+		t.Setenv("XRF_SE_REDIS_PASSWORD", "from-env")
+
+		_, err := readConfigFiles(dir, "dev")
+		problems := configProblems(t, err)
+		require.Len(t, problems, 1)
+		assert.Contains(t, problems[0], "both XRF_SE_REDIS_PASSWORD and XRF_SE_REDIS_PASSWORD_FILE are set")
+	})
+
+	t.Run("an unreadable file is an error", func(t *testing.T) {
+		t.Setenv("XRF_SE_SERVICE_ACCOUNT_SERVICETOKEN_FILE", filepath.Join(dir, "missing"))
+
+		_, err := readConfigFiles(dir, "dev")
+		problems := configProblems(t, err)
+		require.Len(t, problems, 1)
+		assert.Contains(t, problems[0], "service.account.serviceToken: cannot read")
+	})
+}
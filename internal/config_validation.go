@@ -0,0 +1,516 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigError lists every problem found in the configuration, so that they can all be fixed at once
+// instead of one restart at a time. Each problem is prefixed with the path of the offending key.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration, %d problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+type configValidator struct {
+	problems []string
+}
+
+func (v *configValidator) addf(path, format string, args ...any) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ConfigError{Problems: v.problems}
+}
+
+// addError records errors found while loading (unknown keys, malformed durations or numbers, unreadable secret
+// files), the decoder keeps going after a bad key so it reports all of them, joined at every nesting level.
+func (v *configValidator) addError(err error) {
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		v.problems = append(v.problems, err.Error())
+		return
+	}
+	for _, e := range joined.Unwrap() {
+		v.addError(e)
+	}
+}
+
+// Validate checks the required fields, formats and cross-field rules of the configuration,
+// the returned error is a *ConfigError listing every problem.
+func (c *Config) Validate() error {
+	v := &configValidator{}
+	c.validate(v)
+	return v.err()
+}
+
+func (c *Config) validate(v *configValidator) {
+	switch c.Environment {
+	case DevelopEnv, StagingEnv, LiveEnv:
+	case "":
+		v.addf("environment", "is required")
+	default:
+		v.addf("environment", "must be one of %s, %s or %s, got %q", DevelopEnv, StagingEnv, LiveEnv, c.Environment)
+	}
+
+	c.Log.validate(v, "log", c.Environment)
+
+	c.Application.validate(v, "application")
+	c.Service.Account.validate(v, "service.account")
+	c.Service.Organization.validate(v, "service.organization")
+	c.validateUpstreamTimeouts(v)
+
+	usesRedis := false
+	validateStore(v, "idempotency.store", c.Idempotency.Store, &usesRedis)
+	validateStore(v, "rateLimit.store", c.RateLimit.Store, &usesRedis)
+	if usesRedis && c.Redis.Address == "" {
+		v.addf("redis.address", "is required when a store is redis")
+	} else if c.Redis.Address != "" {
+		validateHostPort(v, "redis.address", c.Redis.Address)
+	}
+	if c.Idempotency.TTL < 0 || c.Idempotency.LockTimeout < 0 {
+		v.addf("idempotency", "ttl and lockTimeout must not be negative")
+	}
+	if c.Idempotency.TTL > 0 && c.Idempotency.LockTimeout > c.Idempotency.TTL {
+		v.addf("idempotency.lockTimeout", "must not be longer than idempotency.ttl")
+	}
+
+	c.RateLimit.validate(v, "rateLimit")
+	c.Tracing.validate(v, "tracing")
+	c.Audit.validate(v, "audit")
+	c.Admin.validate(v, "admin")
+
+	if c.Auth.TokenCacheTTL < 0 {
+		v.addf("auth.tokenCacheTTL", "must not be negative")
+	}
+	if c.Auth.TokenCacheTTL > 0 && c.Auth.TokenCacheSize <= 0 {
+		v.addf("auth.tokenCacheSize", "must be positive when auth.tokenCacheTTL is set")
+	}
+	for i, route := range c.Auth.PublicRoutes {
+		routePath := fmt.Sprintf("auth.publicRoutes[%d]", i)
+		if !strings.HasPrefix(route.Path, "/") {
+			v.addf(routePath+".path", "must start with /, got %q", route.Path)
+		}
+		switch route.Method {
+		case "ANY", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			v.addf(routePath+".method", "must be ANY or an HTTP method, got %q", route.Method)
+		}
+	}
+
+	if c.Health.Interval < 0 || c.Health.Timeout < 0 {
+		v.addf("health", "interval and timeout must not be negative")
+	}
+	if c.Health.Interval > 0 && c.Health.Timeout > c.Health.Interval {
+		v.addf("health.timeout", "must not be longer than health.interval")
+	}
+}
+
+func (l LogConfig) validate(v *configValidator, path, env string) {
+	if l.Level != "" {
+		if _, err := parseLogLevel(l.Level); err != nil {
+			v.addf(path+".level", "must be DEBUG, INFO, WARN or ERROR, got %q", l.Level)
+		}
+	}
+	for pkg, level := range l.PackageLevels {
+		if _, err := parseLogLevel(level); err != nil {
+			v.addf(path+".packageLevels."+pkg, "must be DEBUG, INFO, WARN or ERROR, got %q", level)
+		}
+	}
+	switch l.Format {
+	case "", LogFormatConsole, LogFormatText, LogFormatJSON:
+	default:
+		v.addf(path+".format", "must be console, text or json, got %q", l.Format)
+	}
+	switch output := logOutput(env, l); output {
+	case LogOutputStdout:
+	case LogOutputFile, LogOutputBoth:
+		if l.OutputFile == "" {
+			v.addf(path+".outputFile", "is required when logging to a file")
+		}
+	default:
+		v.addf(path+".output", "must be stdout, file or both, got %q", output)
+	}
+	if l.Rotation.MaxSizeMB < 0 || l.Rotation.MaxBackups < 0 || l.Rotation.MaxAgeDays < 0 {
+		v.addf(path+".rotation", "maxSizeMB, maxBackups and maxAgeDays must not be negative")
+	}
+
+	validateBodyPolicy(v, path+".body.policy", l.Body.Policy)
+	for i, rule := range l.Body.Routes {
+		rulePath := fmt.Sprintf("%s.body.routes[%d]", path, i)
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			v.addf(rulePath+".pathPrefix", "must start with /, got %q", rule.PathPrefix)
+		}
+		if rule.Policy == "" {
+			v.addf(rulePath+".policy", "is required")
+		} else {
+			validateBodyPolicy(v, rulePath+".policy", rule.Policy)
+		}
+	}
+	if l.Body.LargeBodyBytes < 0 {
+		v.addf(path+".body.largeBodyBytes", "must not be negative")
+	}
+	if l.Body.LargeSampleRate < 0 || l.Body.LargeSampleRate > 1 {
+		v.addf(path+".body.largeSampleRate", "must be between 0 and 1, got %v", l.Body.LargeSampleRate)
+	}
+}
+
+func validateBodyPolicy(v *configValidator, path, policy string) {
+	switch policy {
+	case "", LogBodyNone, LogBodyMetadata, LogBodyFull:
+	default:
+		v.addf(path, "must be none, metadata or full, got %q", policy)
+	}
+}
+
+func (a AppConfig) validate(v *configValidator, path string) {
+	validatePort(v, path+".port", a.Port)
+	if a.AdminPort != 0 {
+		validatePort(v, path+".adminPort", a.AdminPort)
+		if a.AdminPort == a.Port {
+			v.addf(path+".adminPort", "must differ from %s.port", path)
+		}
+	}
+	if a.MaxBodyBytes < 0 {
+		v.addf(path+".maxBodyBytes", "must not be negative")
+	}
+	if a.ReadTimeout < 0 || a.WriteTimeout < 0 || a.IdleTimeout < 0 || a.DefaultClientTimeout < 0 {
+		v.addf(path, "timeouts must not be negative")
+	}
+	if a.GracefulTimeout <= 0 {
+		v.addf(path+".gracefulTimeout", "must be positive")
+	}
+	for i, proxy := range a.TrustedProxies {
+		if _, err := ParseIPPrefix(proxy); err != nil {
+			v.addf(fmt.Sprintf("%s.trustedProxies[%d]", path, i), "must be an IP address or a CIDR, got %q", proxy)
+		}
+	}
+	if a.PreStopDelay < 0 {
+		v.addf(path+".preStopDelay", "must not be negative")
+	}
+	if a.ComponentCloseTimeout < 0 {
+		v.addf(path+".componentCloseTimeout", "must not be negative")
+	}
+	validateRequestTimeout(v, path+".requestTimeout", a.RequestTimeout, a.WriteTimeout)
+	for i, route := range a.RouteTimeouts {
+		routePath := fmt.Sprintf("%s.routeTimeouts[%d]", path, i)
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			v.addf(routePath+".pathPrefix", "must start with /, got %q", route.PathPrefix)
+		}
+		switch route.Method {
+		case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			v.addf(routePath+".method", "must be empty or an HTTP method, got %q", route.Method)
+		}
+		if route.Streaming && route.Timeout != 0 {
+			v.addf(routePath+".timeout", "must not be set on a streaming route")
+		}
+		validateRequestTimeout(v, routePath+".timeout", route.Timeout, a.WriteTimeout)
+	}
+	a.TLS.validate(v, path+".tls", a)
+}
+
+// validateUpstreamTimeouts checks that the timeouts of upstream calls fit inside the longest request deadline, the
+// deadline would otherwise cancel the calls first and the longer timeout would never apply. Nothing is checked when
+// some requests have no deadline (no requestTimeout or a streaming route), their calls are bounded by these timeouts only.
+func (c *Config) validateUpstreamTimeouts(v *configValidator) {
+	deadline := c.Application.RequestTimeout
+	if deadline <= 0 {
+		return
+	}
+	for _, route := range c.Application.RouteTimeouts {
+		if route.Streaming {
+			return
+		}
+		deadline = max(deadline, route.Timeout)
+	}
+	check := func(path string, timeout time.Duration) {
+		if timeout > deadline {
+			v.addf(path, "must not be longer than the longest request deadline (%s)", deadline)
+		}
+	}
+
+	if c.Service.Organization.APIClientTimeout > 0 {
+		check("service.organization.apiClientTimeout", c.Service.Organization.APIClientTimeout)
+	} else {
+		check("application.defaultClientTimeout", c.Application.DefaultClientTimeout)
+	}
+	check("service.account.defaultTimeout", c.Service.Account.DefaultTimeout)
+	for i, method := range c.Service.Account.MethodTimeouts {
+		check(fmt.Sprintf("service.account.methodTimeouts[%d].timeout", i), method.Timeout)
+	}
+}
+
+// validateRequestTimeout checks that the 504 of an expired request can still be written before the server write timeout.
+func validateRequestTimeout(v *configValidator, path string, timeout, writeTimeout time.Duration) {
+	if timeout < 0 {
+		v.addf(path, "must not be negative")
+	} else if writeTimeout > 0 && timeout >= writeTimeout {
+		v.addf(path, "must be shorter than the write timeout (%s)", writeTimeout)
+	}
+}
+
+func (t ServerTLSConfig) validate(v *configValidator, path string, app AppConfig) {
+	if !t.Enabled {
+		return
+	}
+	if t.CertFile == "" {
+		v.addf(path+".certFile", "is required to serve TLS")
+	}
+	t.TLSConfig.validate(v, path)
+	if _, err := t.TLSMinVersion(); err != nil {
+		v.addf(path+".minVersion", "%v", err)
+	}
+	if _, err := t.CipherSuiteIds(); err != nil {
+		v.addf(path+".cipherSuites", "%v", err)
+	}
+	if t.RedirectPort != 0 {
+		validatePort(v, path+".redirectPort", t.RedirectPort)
+		if t.RedirectPort == app.Port || t.RedirectPort == app.AdminPort {
+			v.addf(path+".redirectPort", "must differ from the port and the admin port")
+		}
+		if t.PublicHost == "" {
+			v.addf(path+".publicHost", "is required with a redirect port")
+		}
+	}
+	if t.PublicHost != "" {
+		if parsed, err := url.Parse("https://" + t.PublicHost); err != nil || parsed.Host != t.PublicHost || parsed.Hostname() == "" {
+			v.addf(path+".publicHost", "must be a host or host:port, got %q", t.PublicHost)
+		}
+	}
+}
+
+func (g GrpcConfig) validate(v *configValidator, path string) {
+	switch {
+	case g.Target != "":
+		if _, err := url.Parse(g.Target); err != nil {
+			v.addf(path+".target", "is not a valid gRPC target: %v", err)
+		}
+	case len(g.Addresses) > 0:
+		for i, address := range g.Addresses {
+			validateHostPort(v, fmt.Sprintf("%s.addresses[%d]", path, i), address)
+		}
+	case g.Address != "":
+		validateHostPort(v, path+".address", g.Address)
+	default:
+		v.addf(path, "one of address, addresses or target is required")
+	}
+	if g.Port != "" {
+		port, err := strconv.Atoi(g.Port)
+		if err != nil {
+			v.addf(path+".port", "must be a number, got %q", g.Port)
+		} else {
+			validatePort(v, path+".port", port)
+		}
+	}
+
+	switch g.LoadBalancingPolicy {
+	case "", "pick_first", "round_robin":
+	default:
+		v.addf(path+".loadBalancingPolicy", "must be pick_first or round_robin, got %q", g.LoadBalancingPolicy)
+	}
+	if g.Retry.MaxAttempts < 0 || g.Retry.MaxAttempts > 5 {
+		v.addf(path+".retry.maxAttempts", "must be between 0 and 5, got %d", g.Retry.MaxAttempts)
+	}
+	if g.Retry.MaxAttempts > 1 {
+		if g.Retry.InitialBackoff <= 0 || g.Retry.MaxBackoff < g.Retry.InitialBackoff {
+			v.addf(path+".retry", "initialBackoff must be positive and not longer than maxBackoff")
+		}
+		if g.Retry.BackoffMultiplier <= 0 {
+			v.addf(path+".retry.backoffMultiplier", "must be positive")
+		}
+	}
+	if g.Keepalive.Time < 0 || g.Keepalive.Timeout < 0 {
+		v.addf(path+".keepalive", "time and timeout must not be negative")
+	}
+	if g.MaxRecvMsgSize < 0 || g.MaxSendMsgSize < 0 {
+		v.addf(path, "maxRecvMsgSize and maxSendMsgSize must not be negative")
+	}
+	g.TLS.validate(v, path+".tls")
+	if g.DefaultTimeout < 0 {
+		v.addf(path+".defaultTimeout", "must not be negative")
+	}
+	for i, mt := range g.MethodTimeouts {
+		if mt.Method == "" {
+			v.addf(fmt.Sprintf("%s.methodTimeouts[%d].method", path, i), "is required")
+		}
+		if mt.Timeout <= 0 {
+			v.addf(fmt.Sprintf("%s.methodTimeouts[%d].timeout", path, i), "must be positive")
+		}
+	}
+}
+
+func (o OrgConfig) validate(v *configValidator, path string) {
+	if o.BaseURL == "" {
+		v.addf(path+".baseURL", "is required")
+	} else if u, err := url.Parse(o.BaseURL); err != nil {
+		v.addf(path+".baseURL", "is not a valid URL: %v", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(path+".baseURL", "must be an absolute http(s) URL, got %q", o.BaseURL)
+	} else if o.TLS.Enabled && u.Scheme != "https" {
+		v.addf(path+".baseURL", "must use https when %s.tls is enabled", path)
+	}
+	if o.APIClientTimeout < 0 {
+		v.addf(path+".apiClientTimeout", "must not be negative")
+	}
+	if o.Retry.MaxAttempts < 0 {
+		v.addf(path+".retry.maxAttempts", "must not be negative")
+	}
+	if o.Retry.MaxAttempts > 1 && o.Retry.MaxDelay > 0 && o.Retry.MaxDelay < o.Retry.BaseDelay {
+		v.addf(path+".retry.maxDelay", "must not be shorter than baseDelay")
+	}
+	if o.CircuitBreaker.FailureThreshold < 0 || o.CircuitBreaker.OpenTimeout < 0 {
+		v.addf(path+".circuitBreaker", "failureThreshold and openTimeout must not be negative")
+	}
+	o.TLS.validate(v, path+".tls")
+}
+
+func (t TLSConfig) validate(v *configValidator, path string) {
+	if !t.Enabled {
+		return
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		v.addf(path, "certFile and keyFile must be set together")
+	}
+	for _, file := range []struct{ key, name string }{{"caFile", t.CAFile}, {"certFile", t.CertFile}, {"keyFile", t.KeyFile}} {
+		if file.name == "" {
+			continue
+		}
+		if _, err := os.Stat(file.name); err != nil {
+			v.addf(path+"."+file.key, "cannot be read: %v", err)
+		}
+	}
+	if t.ReloadInterval < 0 {
+		v.addf(path+".reloadInterval", "must not be negative")
+	}
+}
+
+func (r RateLimitConfig) validate(v *configValidator, path string) {
+	r.RateLimitRules.validate(v, path, r.Enabled)
+	r.IP.validate(v, path+".ip", r.Enabled)
+}
+
+func (r RateLimitRules) validate(v *configValidator, path string, enabled bool) {
+	if r.Default.RequestsPerSecond < 0 || r.Default.Burst < 0 {
+		v.addf(path+".default", "requestsPerSecond and burst must not be negative")
+	}
+	if enabled && r.Default.RequestsPerSecond > 0 && r.Default.Burst == 0 {
+		v.addf(path+".default.burst", "must be positive when requestsPerSecond is set")
+	}
+	names := make(map[string]bool, len(r.Groups))
+	prefixes := make(map[string]bool, len(r.Groups))
+	for i, group := range r.Groups {
+		groupPath := fmt.Sprintf("%s.groups[%d]", path, i)
+		if group.Name == "" {
+			v.addf(groupPath+".name", "is required")
+		} else if names[group.Name] {
+			v.addf(groupPath+".name", "%q is used by another group", group.Name)
+		}
+		names[group.Name] = true
+
+		if !strings.HasPrefix(group.PathPrefix, "/") {
+			v.addf(groupPath+".pathPrefix", "must start with /, got %q", group.PathPrefix)
+		} else if prefixes[group.PathPrefix] {
+			v.addf(groupPath+".pathPrefix", "%q is used by another group", group.PathPrefix)
+		}
+		prefixes[group.PathPrefix] = true
+
+		if group.RequestsPerSecond < 0 || group.Burst < 0 {
+			v.addf(groupPath, "requestsPerSecond and burst must not be negative")
+		}
+	}
+}
+
+func (t TracingConfig) validate(v *configValidator, path string) {
+	if !t.Enabled {
+		return
+	}
+	switch t.Exporter {
+	case "", "otlp":
+		if t.Endpoint != "" {
+			validateHostPort(v, path+".endpoint", t.Endpoint)
+		}
+	case "stdout":
+	case "file":
+		if t.FilePath == "" {
+			v.addf(path+".filePath", "is required with the file exporter")
+		}
+	default:
+		v.addf(path+".exporter", "must be otlp, stdout or file, got %q", t.Exporter)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		v.addf(path+".sampleRatio", "must be between 0 and 1, got %v", t.SampleRatio)
+	}
+}
+
+func (a AdminConfig) validate(v *configValidator, path string) {
+	if a.TLS.Enabled && a.TLS.CertFile == "" {
+		v.addf(path+".tls.certFile", "is required to serve TLS")
+	}
+	a.TLS.validate(v, path+".tls")
+}
+
+func (a AuditConfig) validate(v *configValidator, path string) {
+	if !a.Enabled {
+		return
+	}
+	if a.SyncInterval < 0 {
+		v.addf(path+".syncInterval", "must not be negative")
+	}
+	sinks := a.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{"file"}
+	}
+	for i, sink := range sinks {
+		switch sink {
+		case "file":
+			if a.FilePath == "" {
+				v.addf(path+".filePath", "is required with the file sink")
+			}
+		case "log":
+		default:
+			v.addf(fmt.Sprintf("%s.sinks[%d]", path, i), "must be file or log, got %q", sink)
+		}
+	}
+}
+
+func validateStore(v *configValidator, path, store string, usesRedis *bool) {
+	switch store {
+	case "", "memory":
+	case "redis":
+		*usesRedis = true
+	default:
+		v.addf(path, "must be memory or redis, got %q", store)
+	}
+}
+
+func validatePort(v *configValidator, path string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf(path, "must be a port between 1 and 65535, got %d", port)
+	}
+}
+
+func validateHostPort(v *configValidator, path, address string) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		v.addf(path, "must be host:port, got %q", address)
+		return
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		v.addf(path, "has an invalid port %q", port)
+	}
+}
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadableKeys are the settings applied without a restart, any other change is reported as RestartRequired
+// and keeps its current value until the process restarts (e.g. ports, TLS, stores, connection settings).
+var reloadableKeys = []string{
+	"log.level",
+	"log.packageLevels",
+	"service.account.defaultTimeout",
+	"service.account.methodTimeouts",
+	"service.organization.apiClientTimeout",
+	"application.requestTimeout",
+	"application.routeTimeouts",
+	"rateLimit.default",
+	"rateLimit.groups",
+	"rateLimit.ip",
+	"auth.publicRoutes",
+	"features",
+}
+
+// reloadDebounce groups the burst of events an editor or a config map update makes into one reload.
+const reloadDebounce = 200 * time.Millisecond
+
+// ConfigChange is sent to subscribers after a reload applied at least one setting.
+type ConfigChange struct {
+	Previous *Config
+	Current  *Config
+	// Changed lists the applied keys (e.g. "rateLimit.groups"), RestartRequired the keys that changed
+	// in the file but only take effect after a restart, Current still holds their previous value.
+	Changed         []string
+	RestartRequired []string
+}
+
+// Has tells whether key (e.g. "rateLimit") or one of its children changed.
+func (c ConfigChange) Has(key string) bool {
+	for _, changed := range c.Changed {
+		if changed == key || strings.HasPrefix(changed, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigWatcher reloads the configuration when the file changes or on SIGHUP. An invalid file is rejected
+// and the previous configuration kept, so a typo never takes a running replica down.
+type ConfigWatcher struct {
+	logger      *slog.Logger
+	files       []string
+	load        func() (*Config, error)
+	current     atomic.Pointer[Config]
+	mut         sync.Mutex
+	subscribers []func(ConfigChange)
+}
+
+// Current is the configuration in effect, it must not be modified.
+func (w *ConfigWatcher) Current() *Config {
+	return w.current.Load()
+}
+
+// Subscribe registers fn to be called after each reload that applied a change, calls are made one at a time.
+func (w *ConfigWatcher) Subscribe(fn func(ConfigChange)) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload reads the configuration again and applies the reloadable settings that changed.
+func (w *ConfigWatcher) Reload() (ConfigChange, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	next, err := w.load()
+	if err != nil {
+		w.logger.Error("event=configReloadRejected :: keeping the current configuration", "error", err)
+		return ConfigChange{}, err
+	}
+
+	previous := w.current.Load()
+	change := ConfigChange{Previous: previous}
+	effective := *previous
+	for _, key := range diffConfig(previous, next) {
+		if !isReloadable(key) {
+			change.RestartRequired = append(change.RestartRequired, key)
+			continue
+		}
+		change.Changed = append(change.Changed, key)
+	}
+	for _, key := range reloadableKeys {
+		if change.Has(key) {
+			copyConfigKey(&effective, next, key)
+		}
+	}
+	change.Current = &effective
+
+	if len(change.RestartRequired) > 0 {
+		w.logger.Warn("event=configRestartRequired :: changes are ignored until the next restart", "keys", change.RestartRequired)
+	}
+	if len(change.Changed) == 0 {
+		w.logger.Info("event=configReloaded :: nothing to apply")
+		return change, nil
+	}
+
+	w.current.Store(change.Current)
+	w.logger.Info("event=configReloaded", "keys", change.Changed)
+	for _, subscriber := range w.subscribers {
+		subscriber(change)
+	}
+	return change, nil
+}
+
+// Watch reloads on SIGHUP and when one of the configuration files changes until ctx is done. The directory is watched
+// rather than the files so that atomic replacements (editors, Kubernetes config maps swapping ..data) are seen,
+// when it cannot be watched SIGHUP still works. Secret files are read again on every reload but not watched.
+func (w *ConfigWatcher) Watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	fileWatcher, err := newFileWatcher(filepath.Dir(w.files[0]))
+	if err != nil {
+		w.logger.Warn("config files are not watched, reload with SIGHUP", "files", w.files, "error", err)
+	} else {
+		defer fileWatcher.Close()
+		events, watchErrors = fileWatcher.Events, fileWatcher.Errors
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			w.logger.Info("event=configReloadRequested", "trigger", "SIGHUP")
+			_, _ = w.Reload()
+		case event := <-events:
+			if w.isConfigFile(event.Name) {
+				debounce.Reset(reloadDebounce)
+			}
+		case err := <-watchErrors:
+			w.logger.Warn("config file watcher error", "error", err)
+		case <-debounce.C:
+			w.logger.Info("event=configReloadRequested", "trigger", "file")
+			_, _ = w.Reload()
+		}
+	}
+}
+
+func (w *ConfigWatcher) isConfigFile(path string) bool {
+	name := filepath.Base(path)
+	for _, file := range w.files {
+		if name == filepath.Base(file) {
+			return true
+		}
+	}
+	return name == "..data"
+}
+
+func newFileWatcher(dir string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("failed to watch config directory: %w", err)
+	}
+	return watcher, nil
+}
+
+func isReloadable(key string) bool {
+	for _, reloadable := range reloadableKeys {
+		if key == reloadable || strings.HasPrefix(key, reloadable+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffConfig lists the keys whose value differ, structs are compared field by field and everything else as a whole.
+func diffConfig(a, b *Config) []string {
+	var keys []string
+	diffValue("", reflect.ValueOf(*a), reflect.ValueOf(*b), &keys)
+	slices.Sort(keys)
+	return keys
+}
+
+func diffValue(key string, a, b reflect.Value, keys *[]string) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*keys = append(*keys, key)
+		}
+		return
+	}
+	for i := 0; i < a.NumField(); i++ {
+		name, inline := configKeyName(a.Type().Field(i))
+		fieldKey := key
+		if !inline {
+			fieldKey = joinConfigKey(key, name)
+		}
+		diffValue(fieldKey, a.Field(i), b.Field(i), keys)
+	}
+}
+
+// copyConfigKey sets key of dst to its value in src, key is a path of yaml names (e.g. "rateLimit.groups").
+func copyConfigKey(dst, src *Config, key string) {
+	dstValue, srcValue := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for _, name := range strings.Split(key, ".") {
+		index := configFieldIndex(dstValue.Type(), name)
+		if index == nil {
+			return
+		}
+		dstValue, srcValue = dstValue.FieldByIndex(index), srcValue.FieldByIndex(index)
+	}
+	dstValue.Set(srcValue)
+}
+
+// configFieldIndex returns the index sequence of the field named name, the fields of inline structs included.
+func configFieldIndex(t reflect.Type, name string) []int {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldName, inline := configKeyName(field)
+		if !inline {
+			if fieldName == name {
+				return []int{i}
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if index := configFieldIndex(field.Type, name); index != nil {
+				return append([]int{i}, index...)
+			}
+		}
+	}
+	return nil
+}
+
+func configKeyName(field reflect.StructField) (string, bool) {
+	name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if opts == "inline" {
+		return "", true
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name, false
+}
+
+func joinConfigKey(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func NewConfigWatcher(env string, initial *Config, logger *slog.Logger) *ConfigWatcher {
+	watcher := &ConfigWatcher{
+		logger: logger,
+		files:  ConfigFiles(env),
+		load:   func() (*Config, error) { return readConfigFile(env) },
+	}
+	watcher.current.Store(initial)
+	return watcher
+}
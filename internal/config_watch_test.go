@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfigWatcher(t *testing.T, next func(c *Config)) (*ConfigWatcher, *Config) {
+	t.Helper()
+	initial, err := readConfig(t, validConfig)
+	require.NoError(t, err)
+
+	watcher := NewConfigWatcher("dev", initial, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	watcher.load = func() (*Config, error) {
+		reloaded, err := readConfig(t, validConfig)
+		require.NoError(t, err)
+		next(reloaded)
+		return reloaded, nil
+	}
+	return watcher, initial
+}
+
+func TestConfigWatcher_AppliesReloadableChanges(t *testing.T) {
+	watcher, initial := newTestConfigWatcher(t, func(c *Config) {
+		c.Log.Level = "WARN"
+		c.RateLimit.Groups[0].Burst = 50
+		c.RateLimit.IP.Default.Burst = 500
+		c.Service.Account.DefaultTimeout = 3 * time.Second
+		c.Features = map[string]bool{"newcheckout": true}
+	})
+	var notified []ConfigChange
+	watcher.Subscribe(func(change ConfigChange) { notified = append(notified, change) })
+
+	change, err := watcher.Reload()
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"features", "log.level", "rateLimit.groups", "rateLimit.ip.default.burst", "service.account.defaultTimeout"}, change.Changed)
+	assert.Empty(t, change.RestartRequired)
+	require.Len(t, notified, 1)
+	assert.True(t, notified[0].Has("rateLimit"))
+	assert.False(t, notified[0].Has("auth"))
+	assert.Same(t, initial, notified[0].Previous)
+	assert.Equal(t, 50, watcher.Current().RateLimit.Groups[0].Burst)
+	assert.Equal(t, 500, watcher.Current().RateLimit.IP.Default.Burst)
+	assert.Equal(t, "WARN", watcher.Current().Log.Level)
+	assert.Equal(t, 5, initial.RateLimit.Groups[0].Burst, "the previous configuration is left untouched")
+}
+
+func TestConfigWatcher_FlagsRestartRequiredChanges(t *testing.T) {
+	watcher, _ := newTestConfigWatcher(t, func(c *Config) {
+		c.Application.Port = 8010
+		c.RateLimit.Default.Burst = 80
+	})
+
+	change, err := watcher.Reload()
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"application.port"}, change.RestartRequired)
+	assert.Equal(t, []string{"rateLimit.default.burst"}, change.Changed)
+	assert.Equal(t, 8008, watcher.Current().Application.Port, "restart-required settings keep their value")
+	assert.Equal(t, 80, watcher.Current().RateLimit.Default.Burst)
+}
+
+func TestConfigWatcher_RejectsInvalidReload(t *testing.T) {
+	initial, err := readConfig(t, validConfig)
+	require.NoError(t, err)
+	watcher := NewConfigWatcher("dev", initial, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	watcher.load = func() (*Config, error) {
+		return nil, &ConfigError{Problems: []string{"rateLimit.groups[0].pathPrefix: must start with /"}}
+	}
+	watcher.Subscribe(func(ConfigChange) { t.Error("subscribers must not be notified of a rejected reload") })
+
+	_, err = watcher.Reload()
+
+	var configErr *ConfigError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Same(t, initial, watcher.Current())
+}
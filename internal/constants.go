@@ -15,4 +15,9 @@ const (
 	XrfAuthToken       = "XRF-auth-token"
 	ApplicationJson    = "application/json"
 	SrvToSrvToken      = "xrf-to-xrf-token"
+	IdempotencyKey     = "Idempotency-Key"
+	IdempotentReplayed = "Idempotent-Replayed"
+	RequestIdHeader    = "req-trace-id"
+	TraceParentHeader  = "traceparent"
+	XrfDebugLog        = "Xrf-debug-log"
 )
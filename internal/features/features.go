@@ -0,0 +1,28 @@
+// Package features holds the feature flags of the running configuration, they are replaced as a whole
+// when the configuration is reloaded so that code can check a flag on every request.
+package features
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+var flags atomic.Pointer[map[string]bool]
+
+// Set replaces the feature flags, names are case-insensitive (the config loader lowercases keys).
+func Set(features map[string]bool) {
+	normalized := make(map[string]bool, len(features))
+	for name, enabled := range features {
+		normalized[strings.ToLower(name)] = enabled
+	}
+	flags.Store(&normalized)
+}
+
+// Enabled tells whether the flag name is on, unknown flags are off.
+func Enabled(name string) bool {
+	current := flags.Load()
+	if current == nil {
+		return false
+	}
+	return (*current)[strings.ToLower(name)]
+}
@@ -0,0 +1,208 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+	"xrf197ilz35aq/internal"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+var errNotChecked = errors.New("not checked yet")
+
+// CheckFunc checks a single dependency, a nil error means the dependency is usable.
+type CheckFunc func(ctx context.Context) error
+
+type dependency struct {
+	name     string
+	critical bool
+	check    CheckFunc
+}
+
+// Status is the result of the last check of a dependency.
+type Status struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	Critical  bool      `json:"critical"`
+	LatencyMs float64   `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+}
+
+// Report is the readiness of the service, it is not ready as soon as one critical dependency is unhealthy.
+type Report struct {
+	Ready bool `json:"ready"`
+	// Draining is set once shutdown started, the service is then not ready whatever the state of its dependencies.
+	Draining     bool     `json:"draining,omitempty"`
+	Dependencies []Status `json:"dependencies"`
+}
+
+// Monitor periodically checks the registered dependencies and keeps their last status, so that probes
+// are answered from memory and never fan out to upstream services themselves.
+type Monitor struct {
+	mut          sync.RWMutex
+	dependencies []dependency
+	statuses     map[string]Status
+	nonCritical  map[string]bool
+	draining     bool
+	interval     time.Duration
+	timeout      time.Duration
+	logger       *slog.Logger
+}
+
+// Register adds a dependency to check, it is critical unless it is listed in HealthConfig.NonCritical.
+func (m *Monitor) Register(name string, check CheckFunc) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.dependencies = append(m.dependencies, dependency{name: name, critical: !m.nonCritical[name], check: check})
+}
+
+// Start checks every dependency right away and then every interval, until ctx is done.
+func (m *Monitor) Start(ctx context.Context) {
+	m.CheckAll(ctx)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll runs every check concurrently, each one bounded by the check timeout.
+func (m *Monitor) CheckAll(ctx context.Context) {
+	m.mut.RLock()
+	dependencies := append([]dependency(nil), m.dependencies...)
+	m.mut.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, dep := range dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.setStatus(m.check(ctx, dep))
+		}()
+	}
+	wg.Wait()
+}
+
+// Refresh checks one dependency right away instead of at the next interval, e.g. when a watch stream
+// reported a change.
+func (m *Monitor) Refresh(ctx context.Context, name string) {
+	m.mut.RLock()
+	var found *dependency
+	for _, dep := range m.dependencies {
+		if dep.name == name {
+			found = &dep
+			break
+		}
+	}
+	m.mut.RUnlock()
+
+	if found != nil {
+		m.setStatus(m.check(ctx, *found))
+	}
+}
+
+func (m *Monitor) check(ctx context.Context, dep dependency) Status {
+	checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := dep.check(checkCtx)
+	status := Status{
+		Name:      dep.name,
+		Healthy:   err == nil,
+		Critical:  dep.critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func (m *Monitor) setStatus(status Status) {
+	m.mut.Lock()
+	previous, checkedBefore := m.statuses[status.Name]
+	m.statuses[status.Name] = status
+	m.mut.Unlock()
+
+	if checkedBefore && previous.Healthy == status.Healthy {
+		return
+	}
+	if status.Healthy {
+		m.logger.Info("event=dependencyHealthy", "dependency", status.Name, "latencyMs", status.LatencyMs)
+	} else {
+		m.logger.Warn("event=dependencyUnhealthy", "dependency", status.Name, "critical", status.Critical, "error", status.Error)
+	}
+}
+
+// Drain marks the service as not ready for good, so that load balancers stop sending it new requests before
+// shutdown.
+func (m *Monitor) Drain() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.draining {
+		return
+	}
+	m.draining = true
+	m.logger.Info("event=readinessDraining")
+}
+
+// Report returns the last known status of every dependency, sorted by name.
+func (m *Monitor) Report() Report {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	report := Report{Ready: !m.draining, Draining: m.draining, Dependencies: make([]Status, 0, len(m.dependencies))}
+	for _, dep := range m.dependencies {
+		status, ok := m.statuses[dep.name]
+		if !ok {
+			status = Status{Name: dep.name, Critical: dep.critical, Error: errNotChecked.Error()}
+		}
+		if !status.Healthy && status.Critical {
+			report.Ready = false
+		}
+		report.Dependencies = append(report.Dependencies, status)
+	}
+	sort.Slice(report.Dependencies, func(i, j int) bool {
+		return report.Dependencies[i].Name < report.Dependencies[j].Name
+	})
+	return report
+}
+
+func NewMonitor(logger *slog.Logger, config internal.HealthConfig) *Monitor {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	nonCritical := make(map[string]bool, len(config.NonCritical))
+	for _, name := range config.NonCritical {
+		nonCritical[name] = true
+	}
+	return &Monitor{
+		statuses:    make(map[string]Status),
+		nonCritical: nonCritical,
+		interval:    interval,
+		timeout:     timeout,
+		logger:      logger,
+	}
+}
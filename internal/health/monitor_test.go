@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorReport(t *testing.T) {
+	monitor := NewMonitor(slog.New(slog.DiscardHandler), internal.HealthConfig{
+		Timeout:     50 * time.Millisecond,
+		NonCritical: []string{"redis"},
+	})
+
+	orgErr := errors.New("organization is unreachable")
+	var failOrg bool
+	monitor.Register("organization", func(ctx context.Context) error {
+		if failOrg {
+			return orgErr
+		}
+		return nil
+	})
+	monitor.Register("redis", func(ctx context.Context) error { return errors.New("connection refused") })
+	monitor.Register("xrfq3", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	t.Run("is not ready before the first check", func(t *testing.T) {
+		report := monitor.Report()
+		assert.False(t, report.Ready)
+		assert.Equal(t, errNotChecked.Error(), report.Dependencies[0].Error)
+	})
+
+	t.Run("a slow critical dependency times out and fails readiness", func(t *testing.T) {
+		monitor.CheckAll(context.Background())
+		report := monitor.Report()
+		assert.False(t, report.Ready)
+		assert.Equal(t, []string{"organization", "redis", "xrfq3"}, names(report))
+		assert.False(t, report.Dependencies[2].Healthy)
+		assert.Contains(t, report.Dependencies[2].Error, "deadline exceeded")
+	})
+
+	t.Run("non critical failures do not fail readiness", func(t *testing.T) {
+		monitor.mut.Lock()
+		monitor.dependencies = monitor.dependencies[:2]
+		monitor.mut.Unlock()
+		monitor.CheckAll(context.Background())
+
+		report := monitor.Report()
+		assert.True(t, report.Ready)
+		assert.False(t, report.Dependencies[1].Healthy)
+		assert.False(t, report.Dependencies[1].Critical)
+
+		failOrg = true
+		monitor.CheckAll(context.Background())
+		assert.False(t, monitor.Report().Ready)
+		assert.Equal(t, orgErr.Error(), monitor.Report().Dependencies[0].Error)
+	})
+}
+
+func names(report Report) []string {
+	result := make([]string, 0, len(report.Dependencies))
+	for _, status := range report.Dependencies {
+		result = append(result, status.Name)
+	}
+	return result
+}
+
+func TestMonitorRefresh(t *testing.T) {
+	monitor := NewMonitor(slog.New(slog.DiscardHandler), internal.HealthConfig{})
+	healthy := true
+	monitor.Register("xrfq3", func(ctx context.Context) error {
+		if !healthy {
+			return errors.New("NOT_SERVING")
+		}
+		return nil
+	})
+	monitor.CheckAll(context.Background())
+	assert.True(t, monitor.Report().Ready)
+
+	healthy = false
+	monitor.Refresh(context.Background(), "xrfq3")
+	assert.False(t, monitor.Report().Ready)
+
+	// unknown names are ignored
+	monitor.Refresh(context.Background(), "unknown")
+	assert.Len(t, monitor.Report().Dependencies, 1)
+}
+
+func TestMonitorDrain(t *testing.T) {
+	monitor := NewMonitor(slog.New(slog.DiscardHandler), internal.HealthConfig{})
+	monitor.Register("organization", func(ctx context.Context) error { return nil })
+	monitor.CheckAll(context.Background())
+	assert.True(t, monitor.Report().Ready)
+
+	monitor.Drain()
+	monitor.CheckAll(context.Background())
+
+	report := monitor.Report()
+	assert.False(t, report.Ready)
+	assert.True(t, report.Draining)
+	assert.True(t, report.Dependencies[0].Healthy)
+}
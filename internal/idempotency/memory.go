@@ -0,0 +1,76 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	record    Record
+	expiresAt time.Time
+}
+
+// MemoryStore keeps records in process memory, records are only shared by requests hitting the same replica.
+type MemoryStore struct {
+	mut       sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, key, requestHash string, lockTTL time.Duration) (*Record, bool, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.now()
+	s.purgeExpired(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		existing := entry.record
+		return &existing, false, nil
+	}
+
+	s.entries[key] = memoryEntry{
+		record:    Record{RequestHash: requestHash, CreatedAt: now},
+		expiresAt: now.Add(lockTTL),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, record Record, ttl time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	record.Completed = true
+	s.entries[key] = memoryEntry{record: record, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// purgeExpired removes expired entries at most once a minute, callers must hold the lock.
+func (s *MemoryStore) purgeExpired(now time.Time) {
+	if now.Sub(s.lastPurge) < time.Minute {
+		return
+	}
+	s.lastPurge = now
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		now:     time.Now,
+	}
+}
@@ -0,0 +1,74 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "xrf-se:idempotency:"
+
+// RedisStore shares records between replicas, the reservation relies on SET NX being atomic.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func (s *RedisStore) Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (*Record, bool, error) {
+	value, err := json.Marshal(Record{RequestHash: requestHash, CreatedAt: time.Now()})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the key can expire between SETNX and GET, so try a second time before giving up
+	for attempt := 0; attempt < 2; attempt++ {
+		reserved, err := s.client.SetNX(ctx, redisKeyPrefix+key, value, lockTTL).Result()
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+		if reserved {
+			return nil, true, nil
+		}
+
+		existing, err := s.client.Get(ctx, redisKeyPrefix+key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+
+		var record Record
+		if err := json.Unmarshal(existing, &record); err != nil {
+			return nil, false, fmt.Errorf("corrupt idempotency record for key %s: %w", key, err)
+		}
+		return &record, false, nil
+	}
+	return nil, false, fmt.Errorf("%w: could not reserve key %s", ErrStoreUnavailable, key)
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, record Record, ttl time.Duration) error {
+	record.Completed = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, redisKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+	}
+	return nil
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
@@ -0,0 +1,32 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Record is what gets stored against an Idempotency-Key.
+// While the first request is still being processed Completed is false and only RequestHash is set.
+type Record struct {
+	RequestHash string      `json:"requestHash"`
+	Completed   bool        `json:"completed"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// Store persists idempotency records, implementations must make Reserve atomic across callers.
+type Store interface {
+	// Reserve claims key for a new in-flight request.
+	// If the key is already taken, the existing record is returned and reserved is false.
+	Reserve(ctx context.Context, key, requestHash string, lockTTL time.Duration) (existing *Record, reserved bool, err error)
+	// Complete stores the final response for key, it is replayed for repeated requests until ttl expires.
+	Complete(ctx context.Context, key string, record Record, ttl time.Duration) error
+	// Release drops the reservation on key so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+var ErrStoreUnavailable = errors.New("idempotency store unavailable")
@@ -0,0 +1,174 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStoreFunc returns a store and a function moving its clock forward.
+type newStoreFunc func(t *testing.T) (Store, func(time.Duration))
+
+func newTestMemoryStore(t *testing.T) (Store, func(time.Duration)) {
+	store := NewMemoryStore()
+	var mut sync.Mutex
+	now := time.Now()
+	store.now = func() time.Time {
+		mut.Lock()
+		defer mut.Unlock()
+		return now
+	}
+	return store, func(d time.Duration) {
+		mut.Lock()
+		defer mut.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func newTestRedisStore(t *testing.T) (Store, func(time.Duration)) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRedisStore(client), server.FastForward
+}
+
+func TestStores(t *testing.T) {
+	for name, newStore := range map[string]newStoreFunc{
+		"memory": newTestMemoryStore,
+		"redis":  newTestRedisStore,
+	} {
+		t.Run(name, func(t *testing.T) {
+			testStore(t, newStore)
+		})
+	}
+}
+
+// testStore checks the contract of Store that the middleware relies on.
+func testStore(t *testing.T, newStore newStoreFunc) {
+	ctx := context.Background()
+
+	t.Run("the first request reserves the key", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		existing, reserved, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, reserved)
+		assert.Nil(t, existing)
+	})
+
+	t.Run("a duplicate in flight gets the pending record with the original hash", func(t *testing.T) {
+		store, _ := newStore(t)
+		_, _, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+
+		// a different payload under the same key is detected by comparing the hashes
+		existing, reserved, err := store.Reserve(ctx, "key", "hash-2", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		require.NotNil(t, existing)
+		assert.Equal(t, "hash-1", existing.RequestHash)
+		assert.False(t, existing.Completed)
+	})
+
+	t.Run("a completed record is replayed until its ttl expires", func(t *testing.T) {
+		store, advance := newStore(t)
+		_, _, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+
+		record := Record{
+			RequestHash: "hash-1",
+			Status:      http.StatusCreated,
+			Header:      http.Header{"Content-Type": {"application/json"}},
+			Body:        []byte(`{"code":201}`),
+		}
+		require.NoError(t, store.Complete(ctx, "key", record, time.Hour))
+
+		// the lock ttl no longer applies
+		advance(2 * time.Minute)
+		existing, reserved, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		require.NotNil(t, existing)
+		assert.True(t, existing.Completed)
+		assert.Equal(t, http.StatusCreated, existing.Status)
+		assert.Equal(t, "application/json", existing.Header.Get("Content-Type"))
+		assert.Equal(t, `{"code":201}`, string(existing.Body))
+
+		advance(time.Hour)
+		_, reserved, err = store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("an abandoned reservation expires with its lock ttl", func(t *testing.T) {
+		store, advance := newStore(t)
+		_, _, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+
+		advance(time.Minute + time.Second)
+		_, reserved, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("a released key can be reserved again", func(t *testing.T) {
+		store, _ := newStore(t)
+		_, _, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, store.Release(ctx, "key"))
+
+		_, reserved, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("exactly one of concurrent duplicates reserves the key", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		var reservations atomic.Int32
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, reserved, err := store.Reserve(ctx, "key", "hash-1", time.Minute)
+				assert.NoError(t, err)
+				if reserved {
+					reservations.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), reservations.Load())
+	})
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	store := NewRedisStore(client)
+	server.Close()
+
+	_, _, err := store.Reserve(context.Background(), "key", "hash-1", time.Minute)
+	assert.ErrorIs(t, err, ErrStoreUnavailable)
+}
+
+func TestRedisStoreCorruptRecord(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	require.NoError(t, server.Set(redisKeyPrefix+"key", "not json"))
+
+	_, _, err := NewRedisStore(client).Reserve(context.Background(), "key", "hash-1", time.Minute)
+	assert.ErrorContains(t, err, "corrupt idempotency record for key key")
+	assert.NotErrorIs(t, err, ErrStoreUnavailable)
+}
@@ -0,0 +1,251 @@
+// Package lifecycle shuts the service down in order: readiness fails first, the servers drain their requests once
+// load balancers had time to notice, the background workers stop and the components close in the reverse order of
+// their registration, so that nothing is closed while something registered after it may still use it.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+	"xrf197ilz35aq/internal"
+)
+
+const (
+	defaultDrainTimeout = 15 * time.Second
+	defaultCloseTimeout = 5 * time.Second
+)
+
+// CloseFunc releases a component, it should give up when ctx is done.
+type CloseFunc func(ctx context.Context) error
+
+type component struct {
+	name  string
+	close CloseFunc
+}
+
+type namedServer struct {
+	name   string
+	server *http.Server
+}
+
+// StepResult is the outcome of one shutdown step, as logged in the shutdown summary.
+type StepResult struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"durationMs"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Summary lists the shutdown steps in the order they ran.
+type Summary struct {
+	Steps    []StepResult
+	Duration time.Duration
+}
+
+// Err joins the errors of the failed steps.
+func (s Summary) Err() error {
+	var errs []error
+	for _, step := range s.Steps {
+		if step.Error != "" {
+			errs = append(errs, fmt.Errorf("%s: %s", step.Name, step.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Manager owns what has to be stopped on shutdown. Components are registered as they are created, a component
+// registered after another may use it and is therefore closed before it.
+type Manager struct {
+	mut          sync.Mutex
+	onDrain      []func()
+	servers      []namedServer
+	workers      []string
+	components   []component
+	workersCtx   context.Context
+	stopWorkers  context.CancelFunc
+	running      sync.WaitGroup
+	preStopDelay time.Duration
+	drainTimeout time.Duration
+	closeTimeout time.Duration
+	logger       *slog.Logger
+}
+
+// OnDrain adds a function called as soon as shutdown starts, e.g. to fail readiness.
+func (m *Manager) OnDrain(fn func()) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.onDrain = append(m.onDrain, fn)
+}
+
+// RegisterServer adds a server to drain, the servers are drained together once the pre-stop delay elapsed.
+func (m *Manager) RegisterServer(name string, server *http.Server) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.servers = append(m.servers, namedServer{name: name, server: server})
+}
+
+// Go runs a background worker until shutdown, run must return once ctx is done. Workers are stopped after the
+// servers drained and before the components close.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.workers = append(m.workers, name)
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		run(m.workersCtx)
+	}()
+}
+
+// Register adds a component to close on shutdown.
+func (m *Manager) Register(name string, close CloseFunc) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.components = append(m.components, component{name: name, close: close})
+}
+
+// Shutdown fails readiness, waits for the pre-stop delay, drains the servers, stops the workers and closes the
+// components in reverse order, each within the close timeout. A component that does not close in time is left
+// behind and reported, so that one stuck dependency does not keep the others open. ctx cuts the pre-stop delay
+// and the drain short when it is done (e.g. on a second signal).
+func (m *Manager) Shutdown(ctx context.Context) Summary {
+	m.mut.Lock()
+	onDrain := append([]func(){}, m.onDrain...)
+	servers := append([]namedServer(nil), m.servers...)
+	workers := append([]string(nil), m.workers...)
+	components := append([]component(nil), m.components...)
+	m.mut.Unlock()
+
+	start := time.Now()
+	var summary Summary
+	record := func(name string, stepStart time.Time, err error) {
+		step := StepResult{Name: name, DurationMs: float64(time.Since(stepStart).Microseconds()) / 1000}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		summary.Steps = append(summary.Steps, step)
+	}
+
+	for _, fn := range onDrain {
+		fn()
+	}
+
+	if m.preStopDelay > 0 {
+		stepStart := time.Now()
+		m.logger.Info("event=preStopDelay, waiting for load balancers to stop routing requests", "delay", m.preStopDelay)
+		timer := time.NewTimer(m.preStopDelay)
+		var err error
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err = fmt.Errorf("cut short: %w", context.Cause(ctx))
+		}
+		record("preStopDelay", stepStart, err)
+	}
+
+	summary.Steps = append(summary.Steps, m.drain(ctx, servers)...)
+
+	if len(workers) > 0 {
+		stepStart := time.Now()
+		m.stopWorkers()
+		stopped := make(chan struct{})
+		go func() {
+			m.running.Wait()
+			close(stopped)
+		}()
+		var err error
+		select {
+		case <-stopped:
+		case <-time.After(m.closeTimeout):
+			err = fmt.Errorf("not stopped within %s", m.closeTimeout)
+		}
+		record("workers", stepStart, err)
+	}
+
+	for i := len(components) - 1; i >= 0; i-- {
+		stepStart := time.Now()
+		err := m.close(components[i])
+		record(components[i].name, stepStart, err)
+	}
+
+	summary.Duration = time.Since(start)
+	if err := summary.Err(); err != nil {
+		m.logger.Warn("event=shutdownSummary", "durationMs", summary.Duration.Milliseconds(), "steps", summary.Steps, "error", err)
+	} else {
+		m.logger.Info("event=shutdownSummary", "durationMs", summary.Duration.Milliseconds(), "steps", summary.Steps)
+	}
+	return summary
+}
+
+// drain shuts the servers down concurrently within the drain timeout, the connections of a server still busy after
+// it are closed.
+func (m *Manager) drain(ctx context.Context, servers []namedServer) []StepResult {
+	ctx, cancel := context.WithTimeout(ctx, m.drainTimeout)
+	defer cancel()
+
+	results := make([]StepResult, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stepStart := time.Now()
+			err := s.server.Shutdown(ctx)
+			if err != nil {
+				err = errors.Join(fmt.Errorf("requests still in flight were cut: %w", err), s.server.Close())
+			}
+			results[i] = StepResult{Name: s.name, DurationMs: float64(time.Since(stepStart).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (m *Manager) close(c component) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.closeTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Errorf("panic: %v", recovered)
+			}
+		}()
+		done <- c.close(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("not closed within %s", m.closeTimeout)
+	}
+}
+
+// NewManager uses AppConfig.PreStopDelay, GracefulTimeout as the drain timeout and ComponentCloseTimeout.
+func NewManager(logger *slog.Logger, config internal.AppConfig) *Manager {
+	drainTimeout := config.GracefulTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	closeTimeout := config.ComponentCloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = defaultCloseTimeout
+	}
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	return &Manager{
+		workersCtx:   workersCtx,
+		stopWorkers:  stopWorkers,
+		preStopDelay: config.PreStopDelay,
+		drainTimeout: drainTimeout,
+		closeTimeout: closeTimeout,
+		logger:       logger,
+	}
+}
@@ -0,0 +1,124 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLogger = slog.New(slog.DiscardHandler)
+
+func TestManagerShutdown(t *testing.T) {
+	manager := NewManager(testLogger, internal.AppConfig{
+		PreStopDelay:          20 * time.Millisecond,
+		GracefulTimeout:       time.Second,
+		ComponentCloseTimeout: 50 * time.Millisecond,
+	})
+
+	var mut sync.Mutex
+	var events []string
+	record := func(event string) {
+		mut.Lock()
+		defer mut.Unlock()
+		events = append(events, event)
+	}
+
+	manager.OnDrain(func() { record("drain") })
+	manager.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		record("worker")
+	})
+	for _, name := range []string{"cache", "connections", "audit"} {
+		manager.Register(name, func(context.Context) error {
+			record(name)
+			return nil
+		})
+	}
+	unblock := make(chan struct{})
+	t.Cleanup(func() { close(unblock) })
+	manager.Register("stuck", func(context.Context) error {
+		// ignores its deadline
+		<-unblock
+		return nil
+	})
+	manager.Register("failing", func(context.Context) error { return errors.New("flush failed") })
+
+	summary := manager.Shutdown(context.Background())
+
+	assert.Equal(t, []string{"drain", "worker", "audit", "connections", "cache"}, events)
+	assert.GreaterOrEqual(t, summary.Duration, 20*time.Millisecond, "the pre-stop delay elapsed")
+
+	var names []string
+	for _, step := range summary.Steps {
+		names = append(names, step.Name)
+	}
+	assert.Equal(t, []string{"preStopDelay", "workers", "failing", "stuck", "audit", "connections", "cache"}, names)
+	assert.Equal(t, "flush failed", summary.Steps[2].Error)
+	assert.Equal(t, "not closed within 50ms", summary.Steps[3].Error)
+	assert.ErrorContains(t, summary.Err(), "stuck: not closed within 50ms")
+}
+
+func TestManagerDrainsServers(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go func() { _ = server.Serve(listener) }()
+
+	manager := NewManager(testLogger, internal.AppConfig{GracefulTimeout: time.Second})
+	manager.RegisterServer("api", server)
+	var closedAfterDrain bool
+	manager.Register("connections", func(context.Context) error {
+		// the request in flight completed before the components close
+		select {
+		case <-release:
+			closedAfterDrain = true
+		default:
+		}
+		return nil
+	})
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if assert.NoError(t, err) {
+			_ = resp.Body.Close()
+			responses <- resp.StatusCode
+		}
+	}()
+	<-started
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+
+	summary := manager.Shutdown(context.Background())
+
+	require.NoError(t, summary.Err())
+	assert.Equal(t, http.StatusNoContent, <-responses)
+	assert.True(t, closedAfterDrain)
+	assert.Equal(t, "api", summary.Steps[0].Name)
+}
+
+func TestManagerShutdownIsCutShort(t *testing.T) {
+	manager := NewManager(testLogger, internal.AppConfig{PreStopDelay: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	summary := manager.Shutdown(ctx)
+
+	assert.Less(t, summary.Duration, time.Second)
+	assert.ErrorContains(t, summary.Err(), "preStopDelay: cut short")
+}
@@ -2,54 +2,186 @@ package internal
 
 import (
 	"fmt"
-	"github.com/lmittmann/tint"
-	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"xrf197ilz35aq/internal/redact"
+
+	"github.com/lmittmann/tint"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	LogFormatConsole = "console"
+	LogFormatText    = "text"
+	LogFormatJSON    = "json"
+
+	LogOutputStdout = "stdout"
+	LogOutputFile   = "file"
+	LogOutputBoth   = "both"
+
+	LogBodyNone     = "none"
+	LogBodyMetadata = "metadata"
+	LogBodyFull     = "full"
 )
 
 var (
 	lock    = &sync.Mutex{}
 	sLogger *slog.Logger
+	// logFile is the rotated log file of SetupLogger, nil when logging to stdout only.
+	logFile *lumberjack.Logger
+	// envLogLevel is the level of the environment, used when no level is configured.
+	envLogLevel slog.Level
+	// configuredLogLevel is the global level set by SetConfiguredLogLevel, restored by SetLogLevel("").
+	configuredLogLevel atomic.Int64
 )
 
+// SetLogLevel changes the global level of the logger created by SetupLogger, level is a slog level name
+// (DEBUG, INFO, WARN, ERROR) optionally with an offset such as "INFO+2", "" restores the configured level.
+func SetLogLevel(level string) error {
+	if level == "" {
+		levels.global.Set(slog.Level(configuredLogLevel.Load()))
+		return nil
+	}
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	levels.global.Set(l)
+	return nil
+}
+
+// SetConfiguredLogLevel sets the global level from LogConfig.Level, "" being the level of the environment, and keeps
+// it as the level SetLogLevel("") restores.
+func SetConfiguredLogLevel(level string) error {
+	l := envLogLevel
+	if level != "" {
+		var err error
+		if l, err = parseLogLevel(level); err != nil {
+			return err
+		}
+	}
+	configuredLogLevel.Store(int64(l))
+	levels.global.Set(l)
+	return nil
+}
+
+// isLiveEnv tells whether env is production, getAppEnv names it LIVE and PRODUCTION is accepted as an alias.
+func isLiveEnv(env string) bool {
+	return strings.EqualFold(env, LiveEnv) || strings.EqualFold(env, ProductionEnv)
+}
+
+// logFormat is the configured format, it defaults to colored console output in development and text in LIVE.
+func logFormat(env string, config LogConfig) string {
+	if config.Format != "" {
+		return config.Format
+	}
+	if isLiveEnv(env) {
+		return LogFormatText
+	}
+	return LogFormatConsole
+}
+
+// logOutput is the configured output, it defaults to the console and the file in LIVE and the console elsewhere.
+func logOutput(env string, config LogConfig) string {
+	if config.Output != "" {
+		return config.Output
+	}
+	if isLiveEnv(env) {
+		return LogOutputBoth
+	}
+	return LogOutputStdout
+}
+
+func newLogFileWriter(config LogConfig) *lumberjack.Logger {
+	rotation := config.Rotation
+	return &lumberjack.Logger{
+		Filename:   config.OutputFile,
+		MaxSize:    positiveOr(rotation.MaxSizeMB, 100), // megabytes
+		MaxBackups: positiveOr(rotation.MaxBackups, 5),
+		MaxAge:     positiveOr(rotation.MaxAgeDays, 10), // days
+		LocalTime:  true,
+		Compress:   rotation.Compress,
+	}
+}
+
+func positiveOr(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
 func SetupLogger(env string, config LogConfig) (*slog.Logger, error) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	var logLevel = new(slog.LevelVar)
-	opts := slog.HandlerOptions{Level: logLevel, AddSource: true}
-
-	if strings.ToUpper(env) == ProductionEnv {
-		fileOutput := config.OutputFile
-		logFileWriter := &lumberjack.Logger{
-			Filename:   fileOutput,
-			MaxSize:    100, // megabytes
-			MaxBackups: 5,
-			MaxAge:     10, // days
-			LocalTime:  true,
-			Compress:   true,
+	envLogLevel = slog.LevelDebug // "dev", "test" or any other environment
+	if isLiveEnv(env) {
+		envLogLevel = slog.LevelInfo
+	}
+	if err := SetConfiguredLogLevel(config.Level); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", config.Level, err)
+	}
+	packageLevels := make(map[string]slog.Level, len(config.PackageLevels))
+	for pkg, level := range config.PackageLevels {
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level of package %s: %w", pkg, err)
 		}
+		packageLevels[pkg] = l
+	}
+	levels.setPackages(packageLevels)
 
-		logLevel.Set(slog.LevelInfo)
+	output := logOutput(env, config)
+	var writer io.Writer = os.Stdout
+	logFile = nil
+	switch output {
+	case LogOutputFile:
+		logFile = newLogFileWriter(config)
+		writer = logFile
+	case LogOutputBoth:
+		logFile = newLogFileWriter(config)
+		writer = io.MultiWriter(os.Stdout, logFile)
+	}
 
-		wo := io.MultiWriter(os.Stdout, logFileWriter)
-		multiWriter := slog.NewTextHandler(wo, &opts)
+	redact.Configure(config.Redaction.Fields, config.Redaction.Headers)
 
-		sLogger = slog.New(multiWriter)
-		sLogger.Info("logger setup", "level", "INFO", "logOutputs", "console and file")
-		return sLogger, nil
+	// levelHandler does the filtering, the handler it wraps accepts every level
+	opts := slog.HandlerOptions{Level: allLevels, AddSource: true, ReplaceAttr: redact.ReplaceAttr}
+	var handler slog.Handler
+	format := logFormat(env, config)
+	switch format {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(writer, &opts)
+	case LogFormatText:
+		handler = slog.NewTextHandler(writer, &opts)
+	default:
+		handler = tint.NewHandler(writer, &tint.Options{
+			Level:       opts.Level,
+			AddSource:   opts.AddSource,
+			ReplaceAttr: opts.ReplaceAttr,
+			// escape codes are only meant for a terminal
+			NoColor: output != LogOutputStdout,
+		})
 	}
 
-	logLevel.Set(slog.LevelDebug) // "dev", "test" or any other environment
-	sLogger = slog.New(tint.NewHandler(os.Stdout, &tint.Options{
-		Level:       opts.Level,
-		AddSource:   opts.AddSource,
-		ReplaceAttr: opts.ReplaceAttr,
-	}))
-	sLogger.Debug(fmt.Sprintf("running in %s mode", env), "level", "DEBUG", "logOutput", "console only")
+	sLogger = slog.New(&levelHandler{inner: handler, levels: levels})
+	sLogger.Info("logger setup", "env", env, "level", levels.global.Level().String(), "format", format, "output", output)
 	return sLogger, nil
 }
+
+// CloseLogger flushes and closes the log file of SetupLogger, it is the last thing to do on shutdown since a log
+// written afterwards reopens the file.
+func CloseLogger() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Close()
+}
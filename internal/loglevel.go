@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// allLevels is the level of the handlers wrapped by levelHandler, filtering is done by levelHandler only.
+const allLevels = slog.Level(math.MinInt)
+
+type packageLevel struct {
+	pkg   string
+	level slog.Level
+}
+
+// logLevels are the global level and the per package overrides shared by every logger created by SetupLogger.
+type logLevels struct {
+	global *slog.LevelVar
+	// packages are sorted by descending length so that the most specific package matches first.
+	packages atomic.Pointer[[]packageLevel]
+	// pcPackages caches the package of the program counters seen in records.
+	pcPackages sync.Map
+}
+
+// minimum is the lowest level any record may be logged at, records below it are dropped before being built.
+func (l *logLevels) minimum() slog.Level {
+	minimum := l.global.Level()
+	for _, pl := range l.packageLevels() {
+		minimum = min(minimum, pl.level)
+	}
+	return minimum
+}
+
+func (l *logLevels) forPC(pc uintptr) slog.Level {
+	packages := l.packageLevels()
+	if len(packages) == 0 || pc == 0 {
+		return l.global.Level()
+	}
+	cached, ok := l.pcPackages.Load(pc)
+	if !ok {
+		cached = packageOfPC(pc)
+		l.pcPackages.Store(pc, cached)
+	}
+	pkg := cached.(string)
+	for _, pl := range packages {
+		if pkg == pl.pkg || strings.HasPrefix(pkg, pl.pkg+"/") {
+			return pl.level
+		}
+	}
+	return l.global.Level()
+}
+
+func (l *logLevels) packageLevels() []packageLevel {
+	if packages := l.packages.Load(); packages != nil {
+		return *packages
+	}
+	return nil
+}
+
+func (l *logLevels) setPackages(levels map[string]slog.Level) {
+	packages := make([]packageLevel, 0, len(levels))
+	for pkg, level := range levels {
+		packages = append(packages, packageLevel{pkg: strings.ToLower(pkg), level: level})
+	}
+	slices.SortFunc(packages, func(a, b packageLevel) int {
+		return len(b.pkg) - len(a.pkg)
+	})
+	l.packages.Store(&packages)
+}
+
+func packageOfPC(pc uintptr) string {
+	frames := runtime.CallersFrames([]uintptr{pc})
+	frame, _ := frames.Next()
+	return packageOfFunction(frame.Function)
+}
+
+// packageOfFunction returns the lowercase import path of a function, e.g. "xrf197ilz35aq/internal/processor"
+// for "xrf197ilz35aq/internal/processor.(*UserProcessor).CreateUser".
+func packageOfFunction(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		name = name[:slash+1+dot]
+	}
+	return strings.ToLower(name)
+}
+
+// levelHandler filters records by the level of the package logging them, or by forced when it is set
+// (a request asking for debug logs).
+type levelHandler struct {
+	inner  slog.Handler
+	levels *logLevels
+	forced *slog.Level
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.forced != nil && level >= *h.forced {
+		return true
+	}
+	return level >= h.levels.minimum()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.forced == nil || record.Level < *h.forced {
+		if record.Level < h.levels.forPC(record.PC) {
+			return nil
+		}
+	}
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{inner: h.inner.WithAttrs(attrs), levels: h.levels, forced: h.forced}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{inner: h.inner.WithGroup(name), levels: h.levels, forced: h.forced}
+}
+
+var levels = &logLevels{global: new(slog.LevelVar)}
+
+// WithForcedLevel returns a logger that logs from level up whatever the global and package levels are,
+// e.g. for a request asking for debug logs. Loggers not created by SetupLogger are returned unchanged.
+func WithForcedLevel(logger *slog.Logger, level slog.Level) *slog.Logger {
+	h, ok := logger.Handler().(*levelHandler)
+	if !ok {
+		return logger
+	}
+	return slog.New(&levelHandler{inner: h.inner, levels: h.levels, forced: &level})
+}
+
+// LogLevels are the levels in effect, as served by the admin endpoint.
+type LogLevels struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+}
+
+// CurrentLogLevels returns the global level and the per package overrides.
+func CurrentLogLevels() LogLevels {
+	current := LogLevels{Level: levels.global.Level().String(), Packages: make(map[string]string)}
+	for _, pl := range levels.packageLevels() {
+		current.Packages[pl.pkg] = pl.level.String()
+	}
+	return current
+}
+
+// SetPackageLogLevel overrides the level of a package and its sub-packages (e.g. "xrf197ilz35aq/internal/client"),
+// an empty level removes the override.
+func SetPackageLogLevel(pkg, level string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	packageLevels := make(map[string]slog.Level)
+	for _, pl := range levels.packageLevels() {
+		packageLevels[pl.pkg] = pl.level
+	}
+	if level == "" {
+		delete(packageLevels, strings.ToLower(pkg))
+	} else {
+		parsed, err := parseLogLevel(level)
+		if err != nil {
+			return err
+		}
+		packageLevels[strings.ToLower(pkg)] = parsed
+	}
+	levels.setPackages(packageLevels)
+	return nil
+}
+
+// SetPackageLogLevels replaces every package override, e.g. with LogConfig.PackageLevels on a reload.
+func SetPackageLogLevels(packageLevels map[string]string) error {
+	parsed := make(map[string]slog.Level, len(packageLevels))
+	for pkg, level := range packageLevels {
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return fmt.Errorf("package %s: %w", pkg, err)
+		}
+		parsed[pkg] = l
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	levels.setPackages(parsed)
+	return nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("invalid log level %q, expected DEBUG, INFO, WARN or ERROR", level)
+	}
+	return l, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLevelLogger(global slog.Level, packages map[string]slog.Level) (*slog.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	testLevels := &logLevels{global: new(slog.LevelVar)}
+	testLevels.global.Set(global)
+	testLevels.setPackages(packages)
+	inner := slog.NewTextHandler(buf, &slog.HandlerOptions{Level: allLevels})
+	return slog.New(&levelHandler{inner: inner, levels: testLevels}), buf
+}
+
+func TestLevelHandler_GlobalLevel(t *testing.T) {
+	logger, buf := newTestLevelLogger(slog.LevelWarn, nil)
+
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.Contains(t, buf.String(), "kept")
+}
+
+func TestLevelHandler_PackageLevels(t *testing.T) {
+	t.Run("the package of the caller overrides the global level", func(t *testing.T) {
+		logger, buf := newTestLevelLogger(slog.LevelWarn, map[string]slog.Level{"xrf197ilz35aq/internal": slog.LevelDebug})
+
+		logger.Debug("package debug")
+
+		assert.Contains(t, buf.String(), "package debug")
+	})
+
+	t.Run("overrides of other packages do not apply", func(t *testing.T) {
+		logger, buf := newTestLevelLogger(slog.LevelWarn, map[string]slog.Level{
+			"xrf197ilz35aq/internal/client": slog.LevelDebug,
+			"xrf197ilz35aq/internal2":       slog.LevelDebug,
+		})
+
+		logger.Info("other package")
+
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("a package can be quieter than the global level", func(t *testing.T) {
+		logger, buf := newTestLevelLogger(slog.LevelDebug, map[string]slog.Level{"xrf197ilz35aq": slog.LevelError})
+
+		logger.Warn("quiet package")
+
+		assert.Empty(t, buf.String())
+	})
+}
+
+func TestWithForcedLevel(t *testing.T) {
+	logger, buf := newTestLevelLogger(slog.LevelError, map[string]slog.Level{"xrf197ilz35aq": slog.LevelError})
+
+	debugLogger := WithForcedLevel(logger.With("requestId", "req-1"), slog.LevelDebug)
+	debugLogger.Debug("request debug")
+	logger.Debug("other request")
+
+	assert.Contains(t, buf.String(), "request debug")
+	assert.Contains(t, buf.String(), "requestId=req-1")
+	assert.NotContains(t, buf.String(), "other request")
+}
+
+func TestSetLogLevel_RestoresConfiguredLevel(t *testing.T) {
+	previous, previousEnv := levels.global.Level(), envLogLevel
+	t.Cleanup(func() {
+		envLogLevel = previousEnv
+		_ = SetConfiguredLogLevel(previous.String())
+	})
+	envLogLevel = slog.LevelDebug
+
+	assert.NoError(t, SetConfiguredLogLevel("WARN"))
+	assert.NoError(t, SetLogLevel("ERROR"))
+	assert.NoError(t, SetLogLevel(""))
+	assert.Equal(t, slog.LevelWarn, levels.global.Level(), "the configured level, not the one of the environment")
+
+	assert.NoError(t, SetConfiguredLogLevel(""))
+	assert.NoError(t, SetLogLevel("ERROR"))
+	assert.NoError(t, SetLogLevel(""))
+	assert.Equal(t, slog.LevelDebug, levels.global.Level(), "the environment level when none is configured")
+
+	assert.Error(t, SetConfiguredLogLevel("LOUD"))
+	assert.NoError(t, SetLogLevel(""))
+	assert.Equal(t, slog.LevelDebug, levels.global.Level(), "an invalid level does not replace the configured one")
+}
+
+func TestPackageOfPC(t *testing.T) {
+	tests := map[string]string{
+		"xrf197ilz35aq/internal/processor.(*UserProcessor).CreateUser": "xrf197ilz35aq/internal/processor",
+		"main.main":                        "main",
+		"github.com/a/b.v2/pkg.Func.func1": "github.com/a/b.v2/pkg",
+	}
+	for function, expected := range tests {
+		assert.Equal(t, expected, packageOfFunction(function), function)
+	}
+}
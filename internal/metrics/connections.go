@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// connectionStates are the gRPC connectivity states, reported for every connection so absent states read as 0.
+var connectionStates = []string{"IDLE", "CONNECTING", "READY", "TRANSIENT_FAILURE", "SHUTDOWN"}
+
+// ConnectionStateSource reports the current state of each gRPC connection, keyed by address.
+type ConnectionStateSource interface {
+	ConnectionStates() map[string]string
+}
+
+var connectionStateDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "grpc_client", "connection_state"),
+	"Current state of gRPC client connections, 1 for the state the connection is in.",
+	[]string{"address", "state"}, nil,
+)
+
+// connectionStateCollector reads the states at scrape time, so the gauges are never stale.
+type connectionStateCollector struct {
+	source ConnectionStateSource
+}
+
+func (c *connectionStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionStateDesc
+}
+
+func (c *connectionStateCollector) Collect(ch chan<- prometheus.Metric) {
+	for address, current := range c.source.ConnectionStates() {
+		for _, state := range connectionStates {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(connectionStateDesc, prometheus.GaugeValue, value, address, state)
+		}
+	}
+}
+
+// RegisterConnectionStates exposes the connection states of source, it should be called once per source.
+func RegisterConnectionStates(source ConnectionStateSource) error {
+	return Registry.Register(&connectionStateCollector{source: source})
+}
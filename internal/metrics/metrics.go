@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "xrf_se"
+
+// Registry holds every metric of the service, it is served by Handler on the admin listener.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	httpRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests served, by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests, by route pattern, method and status.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "status"})
+
+	httpInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests currently being served.",
+	})
+
+	httpPanics = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "panics_total",
+		Help:      "Number of panics recovered while serving HTTP requests.",
+	})
+
+	upstreamDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of REST calls to upstream services, by service, method, path template and status.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"service", "method", "path", "status"})
+
+	upstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "errors_total",
+		Help:      "Number of failed REST calls to upstream services, by service, method, path template and reason.",
+	}, []string{"service", "method", "path", "reason"})
+
+	upstreamRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "retries_total",
+		Help:      "Number of retried REST calls to upstream services, by service, method and path template.",
+	}, []string{"service", "method", "path"})
+
+	grpcClientHandled = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "handled_total",
+		Help:      "Number of gRPC calls completed, by upstream service, full method and status code.",
+	}, []string{"service", "method", "code"})
+
+	grpcClientDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "handling_seconds",
+		Help:      "Latency of gRPC calls, by upstream service, full method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"service", "method", "code"})
+
+	grpcConnectionTransitions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc_client",
+		Name:      "connection_transitions_total",
+		Help:      "Number of gRPC client connection state changes, by address and states.",
+	}, []string{"address", "from", "to"})
+
+	authCache = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "token_cache_requests_total",
+		Help:      "Auth token cache lookups, by result (hit or miss).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTPRequest records a served request, route is the ServeMux pattern and never the raw path.
+func ObserveHTTPRequest(route, method string, status int, duration time.Duration) {
+	statusStr := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, statusStr).Inc()
+	httpRequestDuration.WithLabelValues(route, method, statusStr).Observe(duration.Seconds())
+}
+
+// TrackInFlight increments the in-flight gauge, the returned func must be called once the request is done.
+func TrackInFlight() func() {
+	httpInFlight.Inc()
+	return httpInFlight.Dec
+}
+
+func IncPanics() {
+	httpPanics.Inc()
+}
+
+// ObserveUpstreamRequest records a REST call to an upstream service, status is 0 when no response was received.
+func ObserveUpstreamRequest(service, method, pathTemplate string, status int, duration time.Duration) {
+	upstreamDuration.WithLabelValues(service, method, pathTemplate, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// IncUpstreamError records a failed REST call, reason is a short low-cardinality string (e.g. "transport", "5xx").
+func IncUpstreamError(service, method, pathTemplate, reason string) {
+	upstreamErrors.WithLabelValues(service, method, pathTemplate, reason).Inc()
+}
+
+func IncUpstreamRetry(service, method, pathTemplate string) {
+	upstreamRetries.WithLabelValues(service, method, pathTemplate).Inc()
+}
+
+func ObserveGrpcCall(service, fullMethod, code string, duration time.Duration) {
+	grpcClientHandled.WithLabelValues(service, fullMethod, code).Inc()
+	grpcClientDuration.WithLabelValues(service, fullMethod, code).Observe(duration.Seconds())
+}
+
+func IncGrpcConnectionTransition(address, from, to string) {
+	grpcConnectionTransitions.WithLabelValues(address, from, to).Inc()
+}
+
+func IncAuthCacheHit() {
+	authCache.WithLabelValues("hit").Inc()
+}
+
+func IncAuthCacheMiss() {
+	authCache.WithLabelValues("miss").Inc()
+}
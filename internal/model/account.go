@@ -54,8 +54,8 @@ func (m *AccountRequest) Validate() error {
 }
 
 type FindAccountRequest struct {
-	Currencies   []string `json:"currencies"`
-	AccountTypes []string `json:"accountTypes"`
+	Currencies   []string `json:"currencies" query:"currency"`
+	AccountTypes []string `json:"accountTypes" query:"type"`
 }
 
 func (m *FindAccountRequest) Validate() error {
@@ -5,25 +5,28 @@ import (
 	"net/http"
 	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
 	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/tracing"
 )
 
 type AccountProcessor interface {
-	LockAccount(ctx context.Context, userCtx model.UserContext, acctId string) (bool, error)
-	UnlockAccount(ctx context.Context, userCtx model.UserContext, acctId string) (bool, error)
-	FindAccountByID(ctx context.Context, userCtx model.UserContext, acctId string) (model.AccountResponse, error)
-	CreateAccount(ctx context.Context, userCtx model.UserContext, req model.AccountRequest) (model.AccountResponse, error)
-	UpdateAccount(ctx context.Context, userCtx model.UserContext, acctId string, req model.UpdateAccountRequest) (bool, error)
-	FindAccounts(ctx context.Context, userCtx model.UserContext, req model.FindAccountRequest) ([]model.AccountResponse, error)
+	LockAccount(ctx context.Context, acctId string) (bool, error)
+	UnlockAccount(ctx context.Context, acctId string) (bool, error)
+	FindAccountByID(ctx context.Context, acctId string) (model.AccountResponse, error)
+	CreateAccount(ctx context.Context, req model.AccountRequest) (model.AccountResponse, error)
+	UpdateAccount(ctx context.Context, acctId string, req model.UpdateAccountRequest) (bool, error)
+	FindAccounts(ctx context.Context, req model.FindAccountRequest) ([]model.AccountResponse, error)
 }
 
 type accountProcessor struct {
 	grpcAcctClient v1.AccountServiceClient
+	auditor        *audit.Auditor
 }
 
-func (ap *accountProcessor) CreateAccount(
-	ctx context.Context, userCtx model.UserContext,
-	req model.AccountRequest) (model.AccountResponse, error) {
+func (ap *accountProcessor) CreateAccount(ctx context.Context, req model.AccountRequest) (model.AccountResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.CreateAccount")
+	defer span.End()
 
 	if err := req.Validate(); err != nil {
 		return model.AccountResponse{}, &internal.ExternalError{
@@ -32,23 +35,26 @@ func (ap *accountProcessor) CreateAccount(
 		}
 	}
 
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
-
-	// make a call to the gRPC service to create an account
-	resp, err := ap.grpcAcctClient.CreateAccount(gRPCCtxWithHeaders, &v1.CreateAccountRequest{
+	// make a call to the gRPC service to create an account, metadata and deadline are added by the client interceptors
+	resp, err := ap.grpcAcctClient.CreateAccount(ctx, &v1.CreateAccountRequest{
 		Currency: req.Currency,
 		AcctType: req.AccountType,
 		Timezone: "UTC",
 	})
 	if err != nil {
-		return model.AccountResponse{}, handleGrpcError(err)
+		err = handleGrpcError(err)
+		ap.auditor.Record(ctx, audit.ActionAccountCreate, "", err)
+		return model.AccountResponse{}, err
 	}
+	ap.auditor.Record(ctx, audit.ActionAccountCreate, resp.Account.GetAccountId(), nil)
 
 	return convertAcctResponse(resp.Account, req.Timezone)
 }
 
-func (ap *accountProcessor) FindAccounts(ctx context.Context, userCtx model.UserContext,
-	req model.FindAccountRequest) ([]model.AccountResponse, error) {
+func (ap *accountProcessor) FindAccounts(ctx context.Context, req model.FindAccountRequest) ([]model.AccountResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.FindAccounts")
+	defer span.End()
+
 	if err := req.Validate(); err != nil {
 		return []model.AccountResponse{}, &internal.ExternalError{
 			Message: err.Error(),
@@ -56,8 +62,7 @@ func (ap *accountProcessor) FindAccounts(ctx context.Context, userCtx model.User
 		}
 	}
 
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
-	resp, err := ap.grpcAcctClient.FindAccountsByCurrencyOrType(gRPCCtxWithHeaders, &v1.FindAccountsByCurrencyOrTypeRequest{
+	resp, err := ap.grpcAcctClient.FindAccountsByCurrencyOrType(ctx, &v1.FindAccountsByCurrencyOrTypeRequest{
 		Currencies: req.Currencies,
 		AcctTypes:  req.AccountTypes,
 	})
@@ -85,10 +90,11 @@ func (ap *accountProcessor) FindAccounts(ctx context.Context, userCtx model.User
 	return accounts, nil
 }
 
-func (ap *accountProcessor) FindAccountByID(ctx context.Context, userCtx model.UserContext, acctId string) (model.AccountResponse, error) {
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
+func (ap *accountProcessor) FindAccountByID(ctx context.Context, acctId string) (model.AccountResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.FindAccountByID")
+	defer span.End()
 
-	resp, err := ap.grpcAcctClient.FindAccountById(gRPCCtxWithHeaders, &v1.FindAccountByIdRequest{
+	resp, err := ap.grpcAcctClient.FindAccountById(ctx, &v1.FindAccountByIdRequest{
 		AccountId:      acctId,
 		IncludeWallets: false,
 	})
@@ -106,39 +112,57 @@ func (ap *accountProcessor) FindAccountByID(ctx context.Context, userCtx model.U
 	return convertAcctResponse(resp.Account, "UTC")
 }
 
-func (ap *accountProcessor) LockAccount(ctx context.Context, userCtx model.UserContext, acctId string) (bool, error) {
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
-	resp, err := ap.grpcAcctClient.LockAccount(gRPCCtxWithHeaders, &v1.LockAccountRequest{
+func (ap *accountProcessor) LockAccount(ctx context.Context, acctId string) (bool, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.LockAccount")
+	defer span.End()
+
+	resp, err := ap.grpcAcctClient.LockAccount(ctx, &v1.LockAccountRequest{
 		AccountId: acctId,
 		Lock:      true,
 	})
 	if err != nil {
-		return false, handleGrpcError(err)
+		err = handleGrpcError(err)
+	}
+	ap.auditor.Record(ctx, audit.ActionAccountLock, acctId, err)
+	if err != nil {
+		return false, err
 	}
 	return resp.Success, nil
 }
 
-func (ap *accountProcessor) UnlockAccount(ctx context.Context, userCtx model.UserContext, acctId string) (bool, error) {
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
-	resp, err := ap.grpcAcctClient.LockAccount(gRPCCtxWithHeaders, &v1.LockAccountRequest{
+func (ap *accountProcessor) UnlockAccount(ctx context.Context, acctId string) (bool, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.UnlockAccount")
+	defer span.End()
+
+	resp, err := ap.grpcAcctClient.LockAccount(ctx, &v1.LockAccountRequest{
 		AccountId: acctId,
 		Lock:      false,
 	})
 	if err != nil {
-		return false, handleGrpcError(err)
+		err = handleGrpcError(err)
+	}
+	ap.auditor.Record(ctx, audit.ActionAccountUnlock, acctId, err)
+	if err != nil {
+		return false, err
 	}
 	return resp.Success, nil
 }
 
-func (ap *accountProcessor) UpdateAccount(ctx context.Context, userCtx model.UserContext, acctId string, req model.UpdateAccountRequest) (bool, error) {
-	gRPCCtxWithHeaders := createGrpcContextWithHeaders(ctx, userCtx)
-	resp, err := ap.grpcAcctClient.UpdateAccount(gRPCCtxWithHeaders, &v1.UpdateAccountRequest{
+func (ap *accountProcessor) UpdateAccount(ctx context.Context, acctId string, req model.UpdateAccountRequest) (bool, error) {
+	ctx, span := tracing.StartSpan(ctx, "AccountProcessor.UpdateAccount")
+	defer span.End()
+
+	resp, err := ap.grpcAcctClient.UpdateAccount(ctx, &v1.UpdateAccountRequest{
 		AccountId:   acctId,
 		Timezone:    &req.Timezone,
 		AccountType: &req.AccountType,
 	})
 	if err != nil {
-		return false, handleGrpcError(err)
+		err = handleGrpcError(err)
+	}
+	ap.auditor.Record(ctx, audit.ActionAccountUpdate, acctId, err)
+	if err != nil {
+		return false, err
 	}
 
 	return resp.Updated, err
@@ -193,6 +217,6 @@ func convertWalletResponse(wallet *v1.WalletResponse, timezone string) (model.Wa
 	}, nil
 }
 
-func NewAccountProcessor(grpcAcctService v1.AccountServiceClient) AccountProcessor {
-	return &accountProcessor{grpcAcctClient: grpcAcctService}
+func NewAccountProcessor(grpcAcctService v1.AccountServiceClient, auditor *audit.Auditor) AccountProcessor {
+	return &accountProcessor{grpcAcctClient: grpcAcctService, auditor: auditor}
 }
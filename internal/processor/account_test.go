@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	v1 "xrf197ilz35aq/gen/xrfq3/account/v1"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// auditEvents is an audit sink keeping the events it is given.
+type auditEvents []audit.Event
+
+func (e *auditEvents) Write(_ context.Context, event audit.Event) error {
+	*e = append(*e, event)
+	return nil
+}
+
+func (e *auditEvents) Close() error {
+	return nil
+}
+
+func newTestAuditor() (*audit.Auditor, *auditEvents) {
+	events := &auditEvents{}
+	return audit.NewAuditor(slog.New(slog.DiscardHandler), events), events
+}
+
+// fakeAccountClient answers the account RPCs the processor audits, err fails all of them.
+type fakeAccountClient struct {
+	v1.AccountServiceClient
+	err   error
+	locks []*v1.LockAccountRequest
+}
+
+func (c *fakeAccountClient) LockAccount(_ context.Context, in *v1.LockAccountRequest, _ ...grpc.CallOption) (*v1.LockAccountResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.locks = append(c.locks, in)
+	return &v1.LockAccountResponse{Success: true}, nil
+}
+
+func (c *fakeAccountClient) UpdateAccount(context.Context, *v1.UpdateAccountRequest, ...grpc.CallOption) (*v1.UpdateAccountResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &v1.UpdateAccountResponse{Updated: true}, nil
+}
+
+func (c *fakeAccountClient) CreateAccount(context.Context, *v1.CreateAccountRequest, ...grpc.CallOption) (*v1.CreateAccountResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	now := timestamppb.Now()
+	return &v1.CreateAccountResponse{Account: &v1.AccountResponse{AccountId: "acct-new", CreationTime: now, ModificationTime: now}}, nil
+}
+
+func TestAccountProcessor_Audit(t *testing.T) {
+	ctx := context.Background()
+	accountRequest := model.AccountRequest{Currency: "USD", AccountType: "Normal"}
+
+	t.Run("successful actions are audited with their target", func(t *testing.T) {
+		auditor, events := newTestAuditor()
+		grpcClient := &fakeAccountClient{}
+		accountProcessor := NewAccountProcessor(grpcClient, auditor)
+
+		_, err := accountProcessor.CreateAccount(ctx, accountRequest)
+		require.NoError(t, err)
+		_, err = accountProcessor.LockAccount(ctx, "acct-1")
+		require.NoError(t, err)
+		_, err = accountProcessor.UnlockAccount(ctx, "acct-1")
+		require.NoError(t, err)
+		_, err = accountProcessor.UpdateAccount(ctx, "acct-1", model.UpdateAccountRequest{Timezone: "UTC"})
+		require.NoError(t, err)
+
+		require.Len(t, *events, 4)
+		for i, expected := range []struct {
+			action audit.Action
+			target string
+		}{
+			{audit.ActionAccountCreate, "acct-new"},
+			{audit.ActionAccountLock, "acct-1"},
+			{audit.ActionAccountUnlock, "acct-1"},
+			{audit.ActionAccountUpdate, "acct-1"},
+		} {
+			assert.Equal(t, expected.action, (*events)[i].Action)
+			assert.Equal(t, expected.target, (*events)[i].Target)
+			assert.Equal(t, audit.OutcomeSuccess, (*events)[i].Outcome)
+		}
+		require.Len(t, grpcClient.locks, 2)
+		assert.True(t, grpcClient.locks[0].Lock)
+		assert.False(t, grpcClient.locks[1].Lock, "unlocking sends lock=false")
+	})
+
+	t.Run("failed actions are audited as failures", func(t *testing.T) {
+		auditor, events := newTestAuditor()
+		accountProcessor := NewAccountProcessor(&fakeAccountClient{err: status.Error(codes.NotFound, "account not found")}, auditor)
+
+		_, err := accountProcessor.CreateAccount(ctx, accountRequest)
+		assert.Error(t, err)
+		_, err = accountProcessor.UnlockAccount(ctx, "acct-1")
+		assert.Error(t, err)
+
+		require.Len(t, *events, 2)
+		assert.Equal(t, audit.ActionAccountCreate, (*events)[0].Action)
+		assert.Equal(t, audit.OutcomeFailure, (*events)[0].Outcome)
+		assert.Equal(t, audit.ActionAccountUnlock, (*events)[1].Action)
+		assert.Equal(t, audit.OutcomeFailure, (*events)[1].Outcome)
+		assert.Contains(t, (*events)[1].Reason, "account not found")
+	})
+
+	t.Run("requests rejected before the call are not audited", func(t *testing.T) {
+		auditor, events := newTestAuditor()
+		accountProcessor := NewAccountProcessor(&fakeAccountClient{}, auditor)
+
+		_, err := accountProcessor.CreateAccount(ctx, model.AccountRequest{Currency: "USD", AccountType: "Savings"})
+		assert.Error(t, err)
+		assert.Empty(t, *events)
+	})
+}
@@ -5,10 +5,11 @@ import (
 	v1 "xrf197ilz35aq/gen/xrfq1/asset/v1"
 	"xrf197ilz35aq/internal/model"
 	"xrf197ilz35aq/internal/service"
+	"xrf197ilz35aq/internal/tracing"
 )
 
 type AssetProcessor interface {
-	CreateAsset(ctx context.Context, userCtx model.UserContext, req model.AssetRequest) (bool, error)
+	CreateAsset(ctx context.Context, req model.AssetRequest) (bool, error)
 }
 
 type assetProcessor struct {
@@ -16,7 +17,10 @@ type assetProcessor struct {
 	orgService     service.OrgService
 }
 
-func (assetProcessor) CreateAsset(ctx context.Context, userCtx model.UserContext, req model.AssetRequest) (bool, error) {
+func (assetProcessor) CreateAsset(ctx context.Context, req model.AssetRequest) (bool, error) {
+	_, span := tracing.StartSpan(ctx, "AssetProcessor.CreateAsset")
+	defer span.End()
+
 	return true, nil
 }
 
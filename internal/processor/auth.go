@@ -2,18 +2,32 @@ package processor
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"log/slog"
 	"net/http"
+	"time"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/cache"
 	"xrf197ilz35aq/internal/client"
+	"xrf197ilz35aq/internal/metrics"
 	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/tracing"
 )
 
 type AuthProcessor struct {
 	apiClient client.ApiClient
+	// tokenCache holds recently validated tokens, keyed by their hash. Nil when caching is disabled.
+	tokenCache    *cache.MemoryCache[model.UserContext]
+	tokenCacheTTL time.Duration
+	auditor       *audit.Auditor
 }
 
 func (ap *AuthProcessor) GetAuthToken(ctx context.Context, log slog.Logger, authReq model.AuthRequest) (*model.AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "AuthProcessor.GetAuthToken")
+	defer span.End()
+
 	// 1. Validate authentication request
 	if err := authReq.Validate(); err != nil {
 		return nil, &internal.ExternalError{
@@ -24,7 +38,9 @@ func (ap *AuthProcessor) GetAuthToken(ctx context.Context, log slog.Logger, auth
 
 	// 2. Make request to create a user
 	var response client.ApiClientResponse[model.AuthResponse]
-	if err := ap.apiClient.Post(ctx, "/auth/token", authReq, nil, &response, log); err != nil {
+	err := ap.apiClient.Post(ctx, "/auth/token", authReq, nil, &response, log)
+	ap.auditor.Record(ctx, audit.ActionLogin, audit.Subject("email", authReq.Email), err)
+	if err != nil {
 		return nil, err
 	}
 
@@ -32,6 +48,9 @@ func (ap *AuthProcessor) GetAuthToken(ctx context.Context, log slog.Logger, auth
 }
 
 func (ap *AuthProcessor) ValidateAuthToken(ctx context.Context, log slog.Logger, req model.VerifyRevokeTokenReq) (*model.UserContext, error) {
+	ctx, span := tracing.StartSpan(ctx, "AuthProcessor.ValidateAuthToken")
+	defer span.End()
+
 	// 1. Validate request
 	if req.Token == "" {
 		return nil, &internal.ExternalError{
@@ -40,7 +59,17 @@ func (ap *AuthProcessor) ValidateAuthToken(ctx context.Context, log slog.Logger,
 		}
 	}
 
-	// 2. Make request to validate token
+	// 2. A token validated within the last tokenCacheTTL is trusted without calling the auth service again
+	cacheKey := hashToken(req.Token)
+	if ap.tokenCache != nil {
+		if userCtx, ok := ap.tokenCache.Get(cacheKey); ok {
+			metrics.IncAuthCacheHit()
+			return &userCtx, nil
+		}
+		metrics.IncAuthCacheMiss()
+	}
+
+	// 3. Make request to validate token
 	var response client.ApiClientResponse[model.UserContext]
 
 	// Add XRF-to-XRF-token
@@ -50,15 +79,29 @@ func (ap *AuthProcessor) ValidateAuthToken(ctx context.Context, log slog.Logger,
 	if err := ap.apiClient.Post(ctx, "/auth/token/verify-with-enriched", req, extraHeaders, &response, log); err != nil {
 		return nil, err
 	}
+
+	if ap.tokenCache != nil {
+		ap.tokenCache.Set(cacheKey, response.Data, ap.tokenCacheTTL)
+	}
 	return &response.Data, nil
 }
 
+// hashToken keeps raw tokens out of the cache.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func addXrfToXrfHeader(headers map[string]string) {
 	headers[internal.SrvToSrvToken] = getAppXRFToken()
 }
 
-func NewAuthProcessor(apiClient client.ApiClient) *AuthProcessor {
-	return &AuthProcessor{apiClient: apiClient}
+func NewAuthProcessor(apiClient client.ApiClient, config internal.AuthConfig, auditor *audit.Auditor) *AuthProcessor {
+	authProcessor := &AuthProcessor{apiClient: apiClient, tokenCacheTTL: config.TokenCacheTTL, auditor: auditor}
+	if config.TokenCacheTTL > 0 {
+		authProcessor.tokenCache = cache.NewMemoryCache[model.UserContext](config.TokenCacheSize)
+	}
+	return authProcessor
 }
 
 func getAppXRFToken() string {
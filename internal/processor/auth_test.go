@@ -0,0 +1,54 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/client"
+	"xrf197ilz35aq/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthProcessor_AuditsLogins(t *testing.T) {
+	newAuthProcessor := func(t *testing.T, status int, body string) (*AuthProcessor, *auditEvents) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(upstream.Close)
+		auditor, events := newTestAuditor()
+		return NewAuthProcessor(*client.NewApiClient(upstream.URL, internal.AppConfig{}), internal.AuthConfig{}, auditor), events
+	}
+	logger := slog.New(slog.DiscardHandler)
+	login := model.AuthRequest{Email: "Jane@Example.com", Password: "Passw0rd!"}
+
+	t.Run("a login is audited under the pseudonymized email", func(t *testing.T) {
+		authProcessor, events := newAuthProcessor(t, http.StatusOK, `{"code":200,"data":{"token":"token-1","expiry":60}}`)
+
+		_, err := authProcessor.GetAuthToken(context.Background(), *logger, login)
+		require.NoError(t, err)
+
+		require.Len(t, *events, 1)
+		assert.Equal(t, audit.ActionLogin, (*events)[0].Action)
+		assert.Equal(t, audit.OutcomeSuccess, (*events)[0].Outcome)
+		assert.Equal(t, audit.Subject("email", "jane@example.com"), (*events)[0].Target)
+		assert.NotContains(t, (*events)[0].Target, "example.com")
+	})
+
+	t.Run("a rejected login is audited as a failure", func(t *testing.T) {
+		authProcessor, events := newAuthProcessor(t, http.StatusUnauthorized, `{"error":"invalid email/password","code":401}`)
+
+		_, err := authProcessor.GetAuthToken(context.Background(), *logger, login)
+		assert.Error(t, err)
+
+		require.Len(t, *events, 1)
+		assert.Equal(t, audit.ActionLogin, (*events)[0].Action)
+		assert.Equal(t, audit.OutcomeFailure, (*events)[0].Outcome)
+	})
+}
@@ -1,16 +1,12 @@
 package processor
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"time"
 	"xrf197ilz35aq/internal"
-	"xrf197ilz35aq/internal/model"
+	grpcclient "xrf197ilz35aq/internal/client/grpc"
 
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -59,33 +55,8 @@ func checkConvertedGrpcTimeErr(err error) error {
 	return nil
 }
 
-func createGrpcContextWithHeaders(ctx context.Context, userCtx model.UserContext) context.Context {
-	// Create gRPC metadata (headers).
-	md := metadata.New(map[string]string{
-		internal.XrfUserFingerPrint: userCtx.Fingerprint,
-	})
-
-	// Create a new context with the metadata attached.
-	gRPCCtxWithHeaders := metadata.NewOutgoingContext(ctx, md)
-	return gRPCCtxWithHeaders
-}
-
+// handleGrpcError converts a gRPC status error into an HTTP error. The client interceptors already do it for
+// connections created by the ConnectionManager, it is kept for clients created without them and is a no-op otherwise.
 func handleGrpcError(err error) error {
-	if err != nil {
-		st, ok := status.FromError(err)
-		if ok {
-			// handle gRPC errors
-			if st.Code() == codes.NotFound {
-				return &internal.ExternalError{Code: 404, Message: st.Message()}
-			} else if st.Code() == codes.AlreadyExists {
-				return &internal.ExternalError{Code: 409, Message: st.Message()}
-			} else if st.Code() == codes.InvalidArgument {
-				return &internal.ExternalError{Code: 400, Message: st.Message()}
-			} else {
-				return &internal.APIClientError{Message: st.Message(), Code: 502}
-			}
-		}
-	}
-
-	return err
+	return grpcclient.ToHTTPError(err)
 }
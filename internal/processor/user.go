@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"time"
 	"xrf197ilz35aq/internal"
 	"xrf197ilz35aq/internal/client"
 	"xrf197ilz35aq/internal/model"
 	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/tracing"
 )
 
 type UserClientResponse struct {
@@ -22,6 +22,9 @@ type UserProcessor struct {
 }
 
 func (up *UserProcessor) CreateUser(ctx context.Context, log slog.Logger, userReq *model.UserRequest) (*model.UserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "UserProcessor.CreateUser")
+	defer span.End()
+
 	// 1. Validate user request
 	if err := userReq.Validate(); err != nil {
 		return nil, &internal.ExternalError{
@@ -31,11 +34,9 @@ func (up *UserProcessor) CreateUser(ctx context.Context, log slog.Logger, userRe
 	}
 
 	// 2. Make request to create user
+	// the deadline is the one of the request (application.requestTimeout), each attempt is bounded by the client
 	var clientResponse UserClientResponse
-	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	err := up.apiClient.Post(timeoutCtx, "/user", userReq, nil, &clientResponse, log)
+	err := up.apiClient.Post(ctx, "/user", userReq, nil, &clientResponse, log)
 	if err != nil {
 		return nil, err
 	}
@@ -44,8 +45,12 @@ func (up *UserProcessor) CreateUser(ctx context.Context, log slog.Logger, userRe
 }
 
 func (up *UserProcessor) GetUserProfile(ctx context.Context, log slog.Logger, userId, authToken string) (*model.UserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "UserProcessor.GetUserProfile")
+	defer span.End()
+
 	var userResponse UserClientResponse
 	path := fmt.Sprintf("/user/%s", userId)
+	ctx = client.ContextWithPathTemplate(ctx, "/user/{userId}")
 
 	if err := up.apiClient.Get(ctx, path, server.CreateAuthTokenHeader(authToken), &userResponse, log); err != nil {
 		return nil, err
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// MemoryLimiter keeps buckets in process memory, every replica enforces its own limits.
+type MemoryLimiter struct {
+	mut       sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := l.now()
+	l.purgeIdle(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+	b.lastSeen = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(limit, b.tokens, allowed), nil
+}
+
+// purgeIdle drops buckets that have not been used for a while, callers must hold the lock.
+// An idle bucket has refilled, so dropping it does not change the outcome of the next request.
+func (l *MemoryLimiter) purgeIdle(now time.Time) {
+	if now.Sub(l.lastPurge) < time.Minute {
+		return
+	}
+	l.lastPurge = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > 10*time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimiter_Allow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewMemoryLimiter()
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	t.Run("allows up to the burst", func(t *testing.T) {
+		for i := 2; i >= 0; i-- {
+			result, err := limiter.Allow(ctx, "fp:user-1", limit)
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, i, result.Remaining)
+		}
+	})
+
+	t.Run("rejects once the bucket is empty", func(t *testing.T) {
+		result, err := limiter.Allow(ctx, "fp:user-1", limit)
+		assert.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+		assert.Equal(t, 1500*time.Millisecond, result.ResetAfter)
+	})
+
+	t.Run("keys have their own buckets", func(t *testing.T) {
+		result, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("refills at the configured rate", func(t *testing.T) {
+		now = now.Add(500 * time.Millisecond)
+		result, err := limiter.Allow(ctx, "fp:user-1", limit)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+	})
+}
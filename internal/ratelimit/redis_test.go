@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLimiter_Allow(t *testing.T) {
+	server := miniredis.RunT(t)
+	now := time.Unix(1_700_000_000, 0)
+	server.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	limiter := NewRedisLimiter(client)
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	t.Run("allows up to the burst", func(t *testing.T) {
+		for i := 2; i >= 0; i-- {
+			result, err := limiter.Allow(ctx, "fp:user-1", limit)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, i, result.Remaining)
+		}
+	})
+
+	t.Run("rejects once the bucket is empty", func(t *testing.T) {
+		result, err := limiter.Allow(ctx, "fp:user-1", limit)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+		assert.Equal(t, 1500*time.Millisecond, result.ResetAfter)
+	})
+
+	t.Run("keys have their own buckets", func(t *testing.T) {
+		result, err := limiter.Allow(ctx, "ip:10.0.0.1", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("refills with the redis clock", func(t *testing.T) {
+		server.SetTime(now.Add(500 * time.Millisecond))
+		result, err := limiter.Allow(ctx, "fp:user-1", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 0, result.Remaining)
+	})
+
+	t.Run("idle buckets expire once they would be full", func(t *testing.T) {
+		ttl := server.TTL(redisKeyPrefix + "fp:user-1")
+		assert.Equal(t, 2500*time.Millisecond, ttl)
+	})
+
+	t.Run("reports an unavailable backend", func(t *testing.T) {
+		server.SetError("LOADING Redis is loading the dataset in memory")
+		defer server.SetError("")
+
+		_, err := limiter.Allow(ctx, "fp:user-2", limit)
+		assert.ErrorContains(t, err, "rate limit script failed")
+	})
+}
@@ -0,0 +1,164 @@
+// Package redact masks sensitive data (tokens, passwords, personal data) before it reaches the logs.
+// Fields are matched by JSON key or log attribute key, case-insensitively, on their suffix so that "token" also
+// masks "authToken" and "serviceToken". Headers are matched by name.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Mask replaces the value of sensitive fields and headers.
+const Mask = "[REDACTED]"
+
+// DefaultFields and DefaultHeaders are always masked, configuration can only add to them.
+var (
+	DefaultFields  = []string{"token", "password", "email", "encryptionKey", "fingerprint"}
+	DefaultHeaders = []string{"XRF-auth-token", "xrf-to-xrf-token", "Authorization", "Cookie", "Set-Cookie", "Xrf-debug-log"}
+)
+
+type rules struct {
+	fields  []string
+	headers map[string]bool
+}
+
+var (
+	current      atomic.Pointer[rules]
+	defaultRules = newRules(nil, nil)
+)
+
+func currentRules() *rules {
+	if r := current.Load(); r != nil {
+		return r
+	}
+	return defaultRules
+}
+
+// Configure sets the fields and headers masked in addition to the defaults.
+func Configure(fields, headers []string) {
+	current.Store(newRules(fields, headers))
+}
+
+func newRules(fields, headers []string) *rules {
+	r := &rules{headers: make(map[string]bool)}
+	for _, field := range append(append([]string{}, DefaultFields...), fields...) {
+		r.fields = append(r.fields, strings.ToLower(field))
+	}
+	for _, header := range append(append([]string{}, DefaultHeaders...), headers...) {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return r
+}
+
+// IsSensitiveField tells whether a JSON key or log attribute key holds sensitive data.
+func IsSensitiveField(key string) bool {
+	key = strings.ToLower(key)
+	for _, field := range currentRules().fields {
+		if strings.HasSuffix(key, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Headers returns a copy of header with the values of sensitive headers masked.
+func Headers(header http.Header) http.Header {
+	headers := currentRules().headers
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		if headers[http.CanonicalHeaderKey(name)] {
+			redacted[name] = []string{Mask}
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+// JSON returns body with the values of sensitive fields masked, at any depth. A body that is not JSON
+// cannot be scrubbed and is replaced by a description of it.
+func JSON(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return fmt.Sprintf("[non-JSON body omitted, %d bytes]", len(body))
+	}
+	scrubbed, err := json.Marshal(scrub(decoded))
+	if err != nil {
+		return fmt.Sprintf("[body omitted, %d bytes]", len(body))
+	}
+	return string(scrubbed)
+}
+
+func scrub(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if IsSensitiveField(key) {
+				if child != nil {
+					v[key] = Mask
+				}
+				continue
+			}
+			v[key] = scrub(child)
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = scrub(child)
+		}
+	}
+	return value
+}
+
+type valuer struct {
+	value any
+}
+
+// LogValue marshals the value lazily, only when the record is logged.
+func (v valuer) LogValue() slog.Value {
+	body, err := json.Marshal(v.value)
+	if err != nil {
+		return slog.StringValue(fmt.Sprintf("[%T omitted]", v.value))
+	}
+	return slog.StringValue(JSON(body))
+}
+
+// Value logs v (a struct, map or slice) as JSON with its sensitive fields masked.
+func Value(v any) slog.LogValuer {
+	return valuer{value: v}
+}
+
+// ReplaceAttr is a slog.HandlerOptions.ReplaceAttr masking attributes named after a sensitive field and
+// scrubbing structs, maps and slices logged as a whole, so that a careless `"user", user` does not leak.
+func ReplaceAttr(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		return attr
+	}
+	if IsSensitiveField(attr.Key) {
+		return slog.String(attr.Key, Mask)
+	}
+	if attr.Value.Kind() != slog.KindAny {
+		return attr
+	}
+	value := attr.Value.Any()
+	switch value.(type) {
+	case error, fmt.Stringer, json.RawMessage, []byte, time.Time, *slog.Source:
+		return attr
+	}
+	switch kind := reflect.Indirect(reflect.ValueOf(value)).Kind(); kind {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return slog.Any(attr.Key, valuer{value: value}.LogValue())
+	}
+	return attr
+}
@@ -0,0 +1,75 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSensitiveField(t *testing.T) {
+	for _, key := range []string{"token", "authToken", "PASSWORD", "email", "encryptionKey", "userFingerprint"} {
+		assert.True(t, IsSensitiveField(key), key)
+	}
+	for _, key := range []string{"userId", "tokens", "emailVerified", "status"} {
+		assert.False(t, IsSensitiveField(key), key)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	body := `{"userId":"u1","authToken":"t","user":{"email":"a@b.c","settings":[{"encryptionKey":"k","theme":"dark"}]},"password":null}`
+
+	var scrubbed map[string]any
+	require.NoError(t, json.Unmarshal([]byte(JSON([]byte(body))), &scrubbed))
+
+	assert.Equal(t, "u1", scrubbed["userId"])
+	assert.Equal(t, Mask, scrubbed["authToken"])
+	user := scrubbed["user"].(map[string]any)
+	assert.Equal(t, Mask, user["email"])
+	setting := user["settings"].([]any)[0].(map[string]any)
+	assert.Equal(t, Mask, setting["encryptionKey"], "nested values are scrubbed")
+	assert.Equal(t, "dark", setting["theme"])
+	assert.Nil(t, scrubbed["password"], "null values stay null")
+
+	assert.Equal(t, "[non-JSON body omitted, 9 bytes]", JSON([]byte("token=abc")))
+	assert.Empty(t, JSON(nil))
+}
+
+func TestHeaders(t *testing.T) {
+	t.Cleanup(func() { Configure(nil, nil) })
+	Configure(nil, []string{"X-Api-Key"})
+
+	header := http.Header{}
+	header.Set("Xrf-Auth-Token", "secret")
+	header.Set("X-Api-Key", "key")
+	header.Set("Content-Type", "application/json")
+
+	redacted := Headers(header)
+
+	assert.Equal(t, Mask, redacted.Get("Xrf-Auth-Token"))
+	assert.Equal(t, Mask, redacted.Get("X-Api-Key"), "configured headers are added to the defaults")
+	assert.Equal(t, "application/json", redacted.Get("Content-Type"))
+	assert.Equal(t, "secret", header.Get("Xrf-Auth-Token"), "the original header is left untouched")
+}
+
+func TestReplaceAttr(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{ReplaceAttr: ReplaceAttr}))
+	user := struct {
+		UserId      string `json:"userId"`
+		Fingerprint string `json:"fingerprint"`
+	}{UserId: "u1", Fingerprint: "fp"}
+
+	logger.Info("msg", "serviceToken", "t", "user", user, "body", Value(map[string]string{"email": "a@b.c"}), "count", 3)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, Mask, record["serviceToken"])
+	assert.JSONEq(t, `{"userId":"u1","fingerprint":"[REDACTED]"}`, record["user"].(string))
+	assert.JSONEq(t, `{"email":"[REDACTED]"}`, record["body"].(string))
+	assert.Equal(t, float64(3), record["count"])
+}
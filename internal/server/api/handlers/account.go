@@ -4,7 +4,6 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
-	"xrf197ilz35aq/internal"
 	"xrf197ilz35aq/internal/model"
 	"xrf197ilz35aq/internal/processor"
 	"xrf197ilz35aq/internal/server"
@@ -19,6 +18,15 @@ type accountHandler struct {
 
 var invalidUserCtxErr = errors.New("invalid user context object in context")
 
+type accountPathParams struct {
+	AccountId string `path:"accountId" validate:"required"`
+}
+
+var (
+	accountPathBinder  = request.MustParamsBinder[accountPathParams]()
+	findAccountsBinder = request.MustParamsBinder[model.FindAccountRequest]()
+)
+
 func (ah *accountHandler) createAccount(w http.ResponseWriter, r *http.Request) {
 	logger := server.LoggerFromContext(r.Context(), ah.defaultLogger)
 
@@ -35,7 +43,7 @@ func (ah *accountHandler) createAccount(w http.ResponseWriter, r *http.Request)
 	}
 
 	//// Call processor
-	savedAccount, err := ah.processor.CreateAccount(r.Context(), *userCtx, req)
+	savedAccount, err := ah.processor.CreateAccount(r.Context(), req)
 	if err != nil {
 		response.WriteErrorResponse(err, w, *logger)
 		return
@@ -50,11 +58,9 @@ func (ah *accountHandler) createAccount(w http.ResponseWriter, r *http.Request)
 
 func (ah *accountHandler) getAccountById(w http.ResponseWriter, r *http.Request) {
 	logger := server.LoggerFromContext(r.Context(), ah.defaultLogger)
-	accountId, isValid := getAndValidateId(r, "accountId")
-
-	if !isValid {
-		externalError := internal.ExternalError{Message: "Invalid/missing accountId", Code: http.StatusBadRequest}
-		response.WriteErrorResponse(&externalError, w, *logger)
+	var params accountPathParams
+	if err := accountPathBinder.Bind(r, &params); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
 		return
 	}
 
@@ -65,7 +71,7 @@ func (ah *accountHandler) getAccountById(w http.ResponseWriter, r *http.Request)
 	}
 
 	//// Call processor
-	savedAccount, err := ah.processor.FindAccountByID(r.Context(), *userCtx, accountId)
+	savedAccount, err := ah.processor.FindAccountByID(r.Context(), params.AccountId)
 
 	handleProcessorResponse(savedAccount, err, w, *logger, http.StatusOK)
 }
@@ -85,14 +91,13 @@ func (ah *accountHandler) updateAccount(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	accountId, isValid := getAndValidateId(r, "accountId")
-	if !isValid {
-		externalError := internal.ExternalError{Message: "Invalid/missing accountId", Code: http.StatusBadRequest}
-		response.WriteErrorResponse(&externalError, w, *logger)
+	var params accountPathParams
+	if err := accountPathBinder.Bind(r, &params); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
 		return
 	}
 
-	accountUpdated, err := ah.processor.UpdateAccount(r.Context(), *userCtx, accountId, req)
+	accountUpdated, err := ah.processor.UpdateAccount(r.Context(), params.AccountId, req)
 	if err == nil && !accountUpdated {
 		err = errors.New("account not updated")
 	}
@@ -109,14 +114,13 @@ func (ah *accountHandler) lockAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accountId, isValid := getAndValidateId(r, "accountId")
-	if !isValid {
-		externalError := internal.ExternalError{Message: "Invalid/missing accountId", Code: http.StatusBadRequest}
-		response.WriteErrorResponse(&externalError, w, *logger)
+	var params accountPathParams
+	if err := accountPathBinder.Bind(r, &params); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
 		return
 	}
 
-	locked, err := ah.processor.LockAccount(r.Context(), *userCtx, accountId)
+	locked, err := ah.processor.LockAccount(r.Context(), params.AccountId)
 
 	if err == nil && !locked {
 		err = errors.New("account not locked")
@@ -134,14 +138,13 @@ func (ah *accountHandler) unlockAccount(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	accountId, isValid := getAndValidateId(r, "accountId")
-	if !isValid {
-		externalError := internal.ExternalError{Message: "Invalid/missing accountId", Code: http.StatusBadRequest}
-		response.WriteErrorResponse(&externalError, w, *logger)
+	var params accountPathParams
+	if err := accountPathBinder.Bind(r, &params); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
 		return
 	}
 
-	unlocked, err := ah.processor.LockAccount(r.Context(), *userCtx, accountId)
+	unlocked, err := ah.processor.UnlockAccount(r.Context(), params.AccountId)
 
 	if err == nil && !unlocked {
 		err = errors.New("account has not been unlocked")
@@ -165,7 +168,7 @@ func (ah *accountHandler) getAccounts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userAccounts, err := ah.processor.FindAccounts(r.Context(), *userCtx, req)
+	userAccounts, err := ah.processor.FindAccounts(r.Context(), req)
 	if err != nil {
 		response.WriteErrorResponse(err, w, *logger)
 		return
@@ -178,7 +181,30 @@ func (ah *accountHandler) getAccounts(w http.ResponseWriter, r *http.Request) {
 	response.WriteResponse(data, w, *logger)
 }
 
+// listAccounts is the cacheable/bookmarkable form of getAccounts, the filters are read from the query string
+// e.g. GET /api/v1/accounts?currency=USD&currency=BTC&type=Normal
+func (ah *accountHandler) listAccounts(w http.ResponseWriter, r *http.Request) {
+	logger := server.LoggerFromContext(r.Context(), ah.defaultLogger)
+
+	var req model.FindAccountRequest
+	if err := findAccountsBinder.Bind(r, &req); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
+		return
+	}
+
+	userCtx, ok := server.UserFromContext(r.Context())
+	if !ok || userCtx == nil || userCtx.Fingerprint == "" {
+		response.WriteErrorResponse(invalidUserCtxErr, w, *logger)
+		return
+	}
+
+	userAccounts, err := ah.processor.FindAccounts(r.Context(), req)
+
+	handleProcessorResponse(userAccounts, err, w, *logger, http.StatusOK)
+}
+
 func (ah *accountHandler) RegisterRoutes(serveMux *http.ServeMux) {
+	serveMux.HandleFunc("GET /api/v1/accounts", ah.listAccounts)
 	serveMux.HandleFunc("POST /api/v1/accounts", ah.getAccounts)
 	serveMux.HandleFunc("POST /api/v1/account", ah.createAccount)
 	serveMux.HandleFunc("PUT /api/v1/accounts/{accountId}", ah.updateAccount)
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/processor"
+	"xrf197ilz35aq/internal/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAccountProcessor records the accounts locked and unlocked.
+type fakeAccountProcessor struct {
+	processor.AccountProcessor
+	locked, unlocked []string
+}
+
+func (p *fakeAccountProcessor) LockAccount(_ context.Context, acctId string) (bool, error) {
+	p.locked = append(p.locked, acctId)
+	return true, nil
+}
+
+func (p *fakeAccountProcessor) UnlockAccount(_ context.Context, acctId string) (bool, error) {
+	p.unlocked = append(p.unlocked, acctId)
+	return true, nil
+}
+
+func TestAccountHandler_LockAndUnlock(t *testing.T) {
+	accountProcessor := &fakeAccountProcessor{}
+	mux := http.NewServeMux()
+	NewAccountHandler(*slog.New(slog.DiscardHandler), accountProcessor).RegisterRoutes(mux)
+	serve := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, path, nil)
+		r = r.WithContext(server.ContextWithUserCtx(r.Context(), &model.UserContext{Fingerprint: "fp-1"}))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	unlock := serve("/api/v1/accounts/acct-1/unlock")
+	assert.Equal(t, http.StatusOK, unlock.Code)
+	assert.JSONEq(t, `{"code":200,"data":true}`, unlock.Body.String())
+	assert.Equal(t, []string{"acct-1"}, accountProcessor.unlocked)
+	assert.Empty(t, accountProcessor.locked, "unlocking must not lock the account")
+
+	assert.Equal(t, http.StatusOK, serve("/api/v1/accounts/acct-2/lock").Code)
+	assert.Equal(t, []string{"acct-2"}, accountProcessor.locked)
+	assert.Equal(t, []string{"acct-1"}, accountProcessor.unlocked)
+}
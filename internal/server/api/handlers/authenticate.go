@@ -39,7 +39,8 @@ func (auth *AuthHandler) authenticateUser(w http.ResponseWriter, r *http.Request
 }
 
 func (auth *AuthHandler) RegisterRoutes(serveMux *http.ServeMux) {
-	serveMux.HandleFunc("POST /api/v1/auth/token", auth.authenticateUser)
+	// credentials only, anything bigger than a few KB is not a real login attempt
+	serveMux.Handle("POST /api/v1/auth/token", request.WithBodyLimit(4<<10, auth.authenticateUser))
 }
 
 func NewAuthHandler(logger slog.Logger, authProcessor processor.AuthProcessor) *AuthHandler {
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+type configRoutes struct {
+	logger slog.Logger
+	// current returns the configuration in effect, it changes when the configuration is reloaded
+	current func() *internal.Config
+}
+
+// effectiveConfig serves the configuration the process runs with, secrets redacted. It belongs on the admin
+// server only, the dump still reveals the internal topology.
+func (cr *configRoutes) effectiveConfig(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	response.WriteResponse(response.DataResponse{Code: http.StatusOK, Data: cr.current().Redacted()}, w, cr.logger)
+}
+
+func (cr *configRoutes) RegisterRoutes(serveMux *http.ServeMux) {
+	serveMux.HandleFunc("GET /config", cr.effectiveConfig)
+}
+
+func NewReqConfigHandlers(logger slog.Logger, current func() *internal.Config) RequestHandler {
+	return &configRoutes{
+		logger:  logger,
+		current: current,
+	}
+}
@@ -3,11 +3,13 @@ package handlers
 import (
 	"log/slog"
 	"net/http"
+	"xrf197ilz35aq/internal/health"
 	"xrf197ilz35aq/internal/server/api/response"
 )
 
 type healthRoutes struct {
-	logger slog.Logger
+	logger  slog.Logger
+	monitor *health.Monitor
 }
 
 func (hr *healthRoutes) healthCheck(w http.ResponseWriter, _ *http.Request) {
@@ -22,12 +24,50 @@ func (hr *healthRoutes) healthCheck(w http.ResponseWriter, _ *http.Request) {
 	response.WriteResponse(data, w, hr.logger)
 }
 
+// liveness only tells that the process is serving requests, it must not depend on upstream services
+// or the orchestrator would restart healthy replicas during an upstream outage.
+func (hr *healthRoutes) liveness(w http.ResponseWriter, _ *http.Request) {
+	data := response.DataResponse{
+		Code: http.StatusOK,
+		Data: struct {
+			Live bool `json:"live"`
+		}{
+			Live: true,
+		},
+	}
+	response.WriteResponse(data, w, hr.logger)
+}
+
+// readiness answers from the last checks run by the monitor, it fails while a critical dependency is unhealthy.
+// The status of each dependency is only served by the admin server.
+func (hr *healthRoutes) readiness(w http.ResponseWriter, _ *http.Request) {
+	ready := true
+	if hr.monitor != nil {
+		ready = hr.monitor.Report().Ready
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	data := struct {
+		Ready bool `json:"ready"`
+	}{
+		Ready: ready,
+	}
+	response.WriteResponse(response.DataResponse{Code: code, Data: data}, w, hr.logger)
+}
+
 func (hr *healthRoutes) RegisterRoutes(serveMux *http.ServeMux) {
 	serveMux.HandleFunc("GET /health", hr.healthCheck)
+	serveMux.HandleFunc("GET /livez", hr.liveness)
+	serveMux.HandleFunc("GET /readyz", hr.readiness)
 }
 
-func NewReqHealthHandlers(logger slog.Logger) RequestHandler {
+func NewReqHealthHandlers(logger slog.Logger, monitor *health.Monitor) RequestHandler {
 	return &healthRoutes{
-		logger: logger,
+		logger:  logger,
+		monitor: monitor,
 	}
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server/api/request"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+// logLevelRequest changes the global level, or the level of Package and its sub-packages when it is set.
+// An empty Level restores the configured level, or removes the override of Package.
+type logLevelRequest struct {
+	Level   string `json:"level"`
+	Package string `json:"package"`
+}
+
+type logLevelRoutes struct {
+	logger slog.Logger
+}
+
+func (lr *logLevelRoutes) getLogLevels(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	response.WriteResponse(response.DataResponse{Code: http.StatusOK, Data: internal.CurrentLogLevels()}, w, lr.logger)
+}
+
+// setLogLevel lasts until the process restarts or the configured level changes on a reload.
+func (lr *logLevelRoutes) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var levelReq logLevelRequest
+	if err := request.DecodeJSONBody(r, &levelReq); err != nil {
+		response.WriteErrorResponse(err, w, lr.logger)
+		return
+	}
+
+	var err error
+	if levelReq.Package != "" {
+		err = internal.SetPackageLogLevel(levelReq.Package, levelReq.Level)
+	} else {
+		err = internal.SetLogLevel(levelReq.Level)
+	}
+	if err != nil {
+		response.WriteErrorResponse(&request.Err{Status: http.StatusBadRequest, Msg: err.Error(), Err: err}, w, lr.logger)
+		return
+	}
+
+	lr.logger.Warn("event=logLevelChanged", "level", levelReq.Level, "package", levelReq.Package, "remoteAddr", r.RemoteAddr)
+	response.WriteResponse(response.DataResponse{Code: http.StatusOK, Data: internal.CurrentLogLevels()}, w, lr.logger)
+}
+
+func (lr *logLevelRoutes) RegisterRoutes(serveMux *http.ServeMux) {
+	serveMux.HandleFunc("GET /loglevel", lr.getLogLevels)
+	serveMux.HandleFunc("PUT /loglevel", lr.setLogLevel)
+}
+
+func NewReqLogLevelHandlers(logger slog.Logger) RequestHandler {
+	return &logLevelRoutes{logger: logger}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"xrf197ilz35aq/internal/health"
+	"xrf197ilz35aq/internal/metrics"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+type statusRoutes struct {
+	logger      slog.Logger
+	monitor     *health.Monitor
+	connections metrics.ConnectionStateSource
+}
+
+// readinessDetail serves the last status of every dependency, with its latency and error. The public /readyz only
+// tells whether the service is ready, the detail reveals the internal topology.
+func (sr *statusRoutes) readinessDetail(w http.ResponseWriter, _ *http.Request) {
+	report := health.Report{Ready: true, Dependencies: []health.Status{}}
+	if sr.monitor != nil {
+		report = sr.monitor.Report()
+	}
+
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	response.WriteResponse(response.DataResponse{Code: code, Data: report}, w, sr.logger)
+}
+
+// connectionStatus serves the connectivity state of every gRPC connection, keyed by address.
+func (sr *statusRoutes) connectionStatus(w http.ResponseWriter, _ *http.Request) {
+	states := map[string]string{}
+	if sr.connections != nil {
+		states = sr.connections.ConnectionStates()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	response.WriteResponse(response.DataResponse{Code: http.StatusOK, Data: states}, w, sr.logger)
+}
+
+func (sr *statusRoutes) RegisterRoutes(serveMux *http.ServeMux) {
+	serveMux.HandleFunc("GET /readyz", sr.readinessDetail)
+	serveMux.HandleFunc("GET /connections", sr.connectionStatus)
+}
+
+func NewReqStatusHandlers(logger slog.Logger, monitor *health.Monitor, connections metrics.ConnectionStateSource) RequestHandler {
+	return &statusRoutes{
+		logger:      logger,
+		monitor:     monitor,
+		connections: connections,
+	}
+}
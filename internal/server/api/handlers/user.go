@@ -16,6 +16,12 @@ type userHandler struct {
 	processor     processor.UserProcessor
 }
 
+type userPathParams struct {
+	UserId string `path:"userId" validate:"required"`
+}
+
+var userPathBinder = request.MustParamsBinder[userPathParams]()
+
 func (uh *userHandler) createUser(w http.ResponseWriter, r *http.Request) {
 	logger := server.LoggerFromContext(r.Context(), uh.defaultLogger)
 	var userReq model.UserRequest
@@ -43,17 +49,16 @@ func (uh *userHandler) createUser(w http.ResponseWriter, r *http.Request) {
 func (uh *userHandler) getUser(w http.ResponseWriter, r *http.Request) {
 	logger := server.LoggerFromContext(r.Context(), uh.defaultLogger)
 
-	userId, isValid := getAndValidateId(r, "userId")
-	if !isValid {
-		externalError := internal.ExternalError{Message: "invalid user id", Code: http.StatusBadRequest}
-		response.WriteErrorResponse(&externalError, w, *logger)
+	var params userPathParams
+	if err := userPathBinder.Bind(r, &params); err != nil {
+		response.WriteErrorResponse(err, w, *logger)
 		return
 	}
 
 	authToken := r.Header.Get(internal.XrfAuthToken)
 
 	//// Call processor
-	createdUser, err := uh.processor.GetUserProfile(r.Context(), *logger, userId, authToken)
+	createdUser, err := uh.processor.GetUserProfile(r.Context(), *logger, params.UserId, authToken)
 	if err != nil {
 		response.WriteErrorResponse(err, w, *logger)
 		return
@@ -64,18 +69,10 @@ func (uh *userHandler) getUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (uh *userHandler) RegisterRoutes(serveMux *http.ServeMux) {
-	serveMux.HandleFunc("POST /api/v1/user", uh.createUser)
+	serveMux.Handle("POST /api/v1/user", request.WithBodyLimit(16<<10, uh.createUser))
 	serveMux.HandleFunc("GET /api/v1/user/{userId}", uh.getUser)
 }
 
 func NewUserReqHandler(logger slog.Logger, userProcessor processor.UserProcessor) RequestHandler {
 	return &userHandler{defaultLogger: logger, processor: userProcessor}
 }
-
-func getAndValidateId(req *http.Request, reqIdKey string) (string, bool) {
-	value := req.PathValue(reqIdKey)
-	if value == "" {
-		return "", false
-	}
-	return value, true
-}
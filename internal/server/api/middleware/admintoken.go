@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+// AdminTokenHandler is a middleware that requires the static admin token as a bearer token. It protects the admin
+// server only, the public API is authenticated by AuthenticationMiddleware.
+type AdminTokenHandler struct {
+	logger slog.Logger
+	token  string
+}
+
+func (ah *AdminTokenHandler) Handler(next http.Handler) http.Handler {
+	if ah.token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(ah.token)) != 1 {
+			ah.logger.Warn("event=adminRequestRejected", "method", r.Method, "url", r.URL.Path, "remoteAddr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			externalErr := &internal.ExternalError{Message: "invalid admin token", Code: http.StatusUnauthorized}
+			response.WriteErrorResponse(externalErr, w, ah.logger)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewAdminTokenHandler lets every request through when token is empty.
+func NewAdminTokenHandler(logger slog.Logger, token string) *AdminTokenHandler {
+	return &AdminTokenHandler{logger: logger, token: token}
+}
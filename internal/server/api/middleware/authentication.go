@@ -3,43 +3,62 @@ package middleware
 import (
 	"log/slog"
 	"net/http"
+	"slices"
+	"sync/atomic"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
 	"xrf197ilz35aq/internal/model"
 	"xrf197ilz35aq/internal/processor"
 	"xrf197ilz35aq/internal/server"
 	"xrf197ilz35aq/internal/server/api/response"
 )
 
+// builtInPublicRoutes are always served without an auth token, AuthConfig.PublicRoutes adds to them.
+var builtInPublicRoutes = []internal.PublicRoute{
+	{Path: "/health", Method: "ANY"},
+	{Path: "/livez", Method: "ANY"},
+	{Path: "/readyz", Method: "ANY"},
+	{Path: "/api/v1/user", Method: "POST"},
+	{Path: "/api/v1/auth", Method: "POST"},
+	{Path: "/api/v1/auth/token", Method: "POST"},
+}
+
 type AuthenticationMiddleware struct {
 	logger        slog.Logger
 	authProcessor processor.AuthProcessor
+	auditor       *audit.Auditor
+	// publicRoutes maps a path to the method served without auth token ("ANY" for all of them)
+	publicRoutes atomic.Pointer[map[string]string]
 }
 
 func (m *AuthenticationMiddleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		logger := server.LoggerFromContext(ctx, m.logger)
 		if m.shouldCheckRouteAuth(r) {
 			authToken := r.Header.Get(internal.XrfAuthToken)
 			if authToken == "" {
 				externalErr := &internal.ExternalError{Message: "invalid auth token", Code: 401}
-				response.WriteErrorResponse(externalErr, w, m.logger)
+				response.WriteErrorResponse(externalErr, w, *logger)
 				return
 			}
 
 			req := model.VerifyRevokeTokenReq{Token: authToken}
-			userCtx, err := m.authProcessor.ValidateAuthToken(r.Context(), m.logger, req)
+			userCtx, err := m.authProcessor.ValidateAuthToken(r.Context(), *logger, req)
 			if err != nil {
+				m.auditor.Record(ctx, audit.ActionTokenRejected, r.URL.Path, err)
 				externalErr := &internal.ExternalError{Message: err.Error(), Code: 401}
-				response.WriteErrorResponse(externalErr, w, m.logger)
+				response.WriteErrorResponse(externalErr, w, *logger)
 				return
 			}
 			if userCtx == nil {
 				externalErr := &internal.ExternalError{Message: "invalid auth token", Code: 401}
-				response.WriteErrorResponse(externalErr, w, m.logger)
+				m.auditor.Record(ctx, audit.ActionTokenRejected, r.URL.Path, externalErr)
+				response.WriteErrorResponse(externalErr, w, *logger)
 				return
 			}
 			// set context to the enriched context with the user context obj
-			m.logger.Debug("setting user context", "userCtx", userCtx)
+			logger.Debug("setting user context", "userId", userCtx.UserId)
 			ctx = server.ContextWithUserCtx(r.Context(), userCtx)
 		}
 
@@ -48,11 +67,7 @@ func (m *AuthenticationMiddleware) Handler(next http.Handler) http.Handler {
 }
 
 func (m *AuthenticationMiddleware) shouldCheckRouteAuth(r *http.Request) bool {
-	unCheckedRoutes := make(map[string]string)
-	unCheckedRoutes["/health"] = "ANY"
-	unCheckedRoutes["/api/v1/user"] = "POST"
-	unCheckedRoutes["/api/v1/auth"] = "POST"
-	unCheckedRoutes["/api/v1/auth/token"] = "POST"
+	unCheckedRoutes := *m.publicRoutes.Load()
 
 	route := r.URL.Path
 
@@ -65,9 +80,22 @@ func (m *AuthenticationMiddleware) shouldCheckRouteAuth(r *http.Request) bool {
 	return false
 }
 
-func NewAuthenticationMiddleware(logger slog.Logger, authProcessor processor.AuthProcessor) *AuthenticationMiddleware {
-	return &AuthenticationMiddleware{
+// UpdatePublicRoutes replaces the configured public routes (e.g. on a configuration reload), the built-in ones stay.
+func (m *AuthenticationMiddleware) UpdatePublicRoutes(routes []internal.PublicRoute) {
+	unCheckedRoutes := make(map[string]string, len(builtInPublicRoutes)+len(routes))
+	for _, route := range append(slices.Clone(routes), builtInPublicRoutes...) {
+		unCheckedRoutes[route.Path] = route.Method
+	}
+	m.publicRoutes.Store(&unCheckedRoutes)
+}
+
+func NewAuthenticationMiddleware(
+	logger slog.Logger, authProcessor processor.AuthProcessor, config internal.AuthConfig, auditor *audit.Auditor) *AuthenticationMiddleware {
+	authMiddleware := &AuthenticationMiddleware{
 		logger:        logger,
 		authProcessor: authProcessor,
+		auditor:       auditor,
 	}
+	authMiddleware.UpdatePublicRoutes(config.PublicRoutes)
+	return authMiddleware
 }
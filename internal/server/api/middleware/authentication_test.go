@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/client"
+	"xrf197ilz35aq/internal/processor"
+	"xrf197ilz35aq/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditEvents is an audit sink keeping the events it is given.
+type auditEvents []audit.Event
+
+func (e *auditEvents) Write(_ context.Context, event audit.Event) error {
+	*e = append(*e, event)
+	return nil
+}
+
+func (e *auditEvents) Close() error {
+	return nil
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	var verifications atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifications.Add(1)
+		// the token travels in the body of the verification
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"token":"valid-token"}` {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"token expired","code":401}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":200,"data":{"userId":"u1","fingerprint":"fp-1"}}`))
+	}))
+	defer upstream.Close()
+
+	events := &auditEvents{}
+	auditor := audit.NewAuditor(testLogger, events)
+	authProcessor := processor.NewAuthProcessor(*client.NewApiClient(upstream.URL, internal.AppConfig{}), internal.AuthConfig{}, auditor)
+	authMiddleware := NewAuthenticationMiddleware(*testLogger, *authProcessor, internal.AuthConfig{}, auditor)
+
+	var fingerprint string
+	handler := authMiddleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fingerprint = ""
+		if userCtx, ok := server.UserFromContext(r.Context()); ok {
+			fingerprint = userCtx.Fingerprint
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r = r.WithContext(server.ContextWithClientIP(r.Context(), "203.0.113.9"))
+		if token != "" {
+			r.Header.Set(internal.XrfAuthToken, token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("a valid token puts the user on the context", func(t *testing.T) {
+		*events = nil
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodGet, "/api/v1/accounts", "valid-token").Code)
+		assert.Equal(t, "fp-1", fingerprint)
+		assert.Empty(t, *events)
+	})
+
+	t.Run("a rejected token is audited", func(t *testing.T) {
+		*events = nil
+		w := serve(http.MethodGet, "/api/v1/accounts", "expired-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		require.Len(t, *events, 1)
+		assert.Equal(t, audit.ActionTokenRejected, (*events)[0].Action)
+		assert.Equal(t, audit.OutcomeFailure, (*events)[0].Outcome)
+		assert.Equal(t, "/api/v1/accounts", (*events)[0].Target)
+		assert.Equal(t, "203.0.113.9", (*events)[0].ClientIP)
+		assert.Empty(t, (*events)[0].Actor)
+	})
+
+	t.Run("a request without a token is rejected without calling upstream", func(t *testing.T) {
+		*events = nil
+		before := verifications.Load()
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/accounts", "").Code)
+		assert.Equal(t, before, verifications.Load())
+		assert.Empty(t, *events)
+	})
+
+	t.Run("public routes are served without a token", func(t *testing.T) {
+		*events = nil
+		assert.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/auth", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/auth", "").Code, "only for the public method")
+		assert.Empty(t, *events)
+	})
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"xrf197ilz35aq/internal/server/api/request"
+)
+
+// BodyLimitHandler is a middleware that sets the request body limit, the server wide one or the override of the
+// route set with request.WithBodyLimit, so that middleware reading the body before the route (e.g. idempotency)
+// applies the same limit as request.DecodeJSONBody.
+type BodyLimitHandler struct {
+	maxBytes int64
+	route    func(r *http.Request) http.Handler
+}
+
+func (bh *BodyLimitHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := bh.maxBytes
+		if bh.route != nil {
+			if routeMaxBytes, ok := request.RouteBodyLimit(bh.route(r)); ok {
+				maxBytes = routeMaxBytes
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(request.ContextWithBodyLimit(r.Context(), maxBytes)))
+	})
+}
+
+// NewBodyLimitHandler creates the middleware, route resolves the handler serving a request and may be nil.
+func NewBodyLimitHandler(maxBytes int64, route func(r *http.Request) http.Handler) *BodyLimitHandler {
+	if maxBytes <= 0 {
+		maxBytes = request.DefaultMaxBodyBytes
+	}
+	return &BodyLimitHandler{maxBytes: maxBytes, route: route}
+}
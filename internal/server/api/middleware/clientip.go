@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// ClientIPHandler is a middleware that puts the address of the client on the request context for the middlewares
+// after it (rate limits, logs, traces, audit). X-Forwarded-For is only believed when the request comes from a
+// trusted proxy, it is then read from the right, the first address that is not a trusted proxy is the client.
+type ClientIPHandler struct {
+	trusted []netip.Prefix
+}
+
+func (ch *ClientIPHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := server.ContextWithClientIP(r.Context(), ch.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (ch *ClientIPHandler) resolve(r *http.Request) string {
+	peer := remoteIP(r)
+	if len(ch.trusted) == 0 || !ch.isTrusted(peer) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values(forwardedForHeader) {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// whatever is left of a malformed hop was not written by a proxy we trust
+			break
+		}
+		client = addr.Unmap().String()
+		if !ch.isTrusted(client) {
+			break
+		}
+	}
+	return client
+}
+
+func (ch *ClientIPHandler) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range ch.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address resolved by ClientIPHandler, or the peer address when it did not run.
+func clientIP(r *http.Request) string {
+	if ip := server.ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewClientIPHandler trusts the proxies of AppConfig.TrustedProxies, entries that cannot be parsed are logged and
+// ignored (they are rejected by the configuration validation).
+func NewClientIPHandler(logger *slog.Logger, config internal.AppConfig) *ClientIPHandler {
+	var trusted []netip.Prefix
+	for _, proxy := range config.TrustedProxies {
+		prefix, err := internal.ParseIPPrefix(proxy)
+		if err != nil {
+			logger.Error("event=invalidTrustedProxy, ignoring it", "proxy", proxy, "error", err)
+			continue
+		}
+		trusted = append(trusted, prefix)
+	}
+	return &ClientIPHandler{trusted: trusted}
+}
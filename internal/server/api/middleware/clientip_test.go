@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIPHandler(t *testing.T) {
+	resolve := func(proxies []string, remoteAddr string, forwardedFor ...string) string {
+		var resolved string
+		handler := NewClientIPHandler(testLogger, internal.AppConfig{TrustedProxies: proxies}).
+			Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resolved = server.ClientIPFromContext(r.Context())
+				assert.Equal(t, resolved, clientIP(r))
+			}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		for _, value := range forwardedFor {
+			r.Header.Add(forwardedForHeader, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return resolved
+	}
+	proxies := []string{"10.0.0.0/8", "192.168.1.7"}
+
+	t.Run("uses the peer address without trusted proxies", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.9", resolve(nil, "203.0.113.9:4000", "198.51.100.1"))
+	})
+
+	t.Run("ignores X-Forwarded-For from a client that is not a trusted proxy", func(t *testing.T) {
+		assert.Equal(t, "203.0.113.9", resolve(proxies, "203.0.113.9:4000", "198.51.100.1"))
+	})
+
+	t.Run("takes the first untrusted address from the right", func(t *testing.T) {
+		// the client made up the first hop, the proxies appended the others
+		assert.Equal(t, "198.51.100.1", resolve(proxies, "10.1.2.3:4000", "1.2.3.4, 198.51.100.1", "192.168.1.7"))
+	})
+
+	t.Run("uses the leftmost address when every hop is a trusted proxy", func(t *testing.T) {
+		assert.Equal(t, "10.9.9.9", resolve(proxies, "10.1.2.3:4000", "10.9.9.9, 192.168.1.7"))
+	})
+
+	t.Run("stops at a malformed hop", func(t *testing.T) {
+		assert.Equal(t, "10.9.9.9", resolve(proxies, "10.1.2.3:4000", "198.51.100.1, not-an-ip, 10.9.9.9"))
+	})
+
+	t.Run("uses the trusted proxy when there is no header", func(t *testing.T) {
+		assert.Equal(t, "10.1.2.3", resolve(proxies, "10.1.2.3:4000"))
+	})
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/idempotency"
+	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/server/api/request"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// headers that describe the original request/connection and must not be replayed
+var nonReplayableHeaders = map[string]bool{
+	"Date":           true,
+	"Content-Length": true,
+	"Req-Trace-Id":   true,
+	"Traceparent":    true,
+	"Retry-After":    true,
+	// set by RateLimitHandler for the current request
+	"Ratelimit-Limit":     true,
+	"Ratelimit-Remaining": true,
+	"Ratelimit-Reset":     true,
+}
+
+// IdempotencyHandler is a middleware that replays the first response of a mutating request for repeats
+// carrying the same Idempotency-Key. It must run after AuthenticationMiddleware, keys are scoped per fingerprint.
+type IdempotencyHandler struct {
+	logger      slog.Logger
+	store       idempotency.Store
+	ttl         time.Duration
+	lockTimeout time.Duration
+}
+
+func (ih *IdempotencyHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(internal.IdempotencyKey)
+		if key == "" || !isMutatingMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := server.LoggerFromContext(r.Context(), ih.logger)
+		if len(key) > maxIdempotencyKeyLength {
+			externalErr := &internal.ExternalError{Message: "Idempotency-Key must not be longer than 255 characters", Code: http.StatusBadRequest}
+			response.WriteErrorResponse(externalErr, w, *logger)
+			return
+		}
+
+		requestHash, err := hashRequest(r)
+		if err != nil {
+			response.WriteErrorResponse(err, w, *logger)
+			return
+		}
+
+		storeKey := idempotencyScope(r) + ":" + key
+		existing, reserved, err := ih.store.Reserve(r.Context(), storeKey, requestHash, ih.lockTimeout)
+		if err != nil {
+			logger.Error("event=idempotencyReserveFailure", "error", err)
+			externalErr := &internal.ExternalError{Message: "request could not be processed, please retry", Code: http.StatusServiceUnavailable}
+			response.WriteErrorResponse(externalErr, w, *logger)
+			return
+		}
+
+		if !reserved {
+			ih.handleExisting(existing, requestHash, w, *logger)
+			return
+		}
+
+		capturingWriter := &responseWriter{ResponseWriter: w, status: http.StatusOK, capture: true}
+		// the client may have gone away by the time we store the response, the record is still needed
+		storeCtx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if !completed {
+				// a panic unwound the handler, free the key so that the request can be retried
+				_ = ih.store.Release(storeCtx, storeKey)
+			}
+		}()
+
+		next.ServeHTTP(capturingWriter, r)
+		completed = true
+
+		// server errors are not final, the client should be able to retry them with the same key
+		if capturingWriter.status >= http.StatusInternalServerError {
+			if err := ih.store.Release(storeCtx, storeKey); err != nil {
+				logger.Error("event=idempotencyReleaseFailure", "error", err)
+			}
+			return
+		}
+
+		record := idempotency.Record{
+			RequestHash: requestHash,
+			Status:      capturingWriter.status,
+			Header:      replayableHeaders(capturingWriter.Header()),
+			Body:        capturingWriter.body.Bytes(),
+			CreatedAt:   time.Now(),
+		}
+		if err := ih.store.Complete(storeCtx, storeKey, record, ih.ttl); err != nil {
+			logger.Error("event=idempotencyCompleteFailure", "error", err)
+		}
+	})
+}
+
+func (ih *IdempotencyHandler) handleExisting(existing *idempotency.Record, requestHash string, w http.ResponseWriter, logger slog.Logger) {
+	if existing.RequestHash != requestHash {
+		externalErr := &internal.ExternalError{Message: "Idempotency-Key has already been used for a different request", Code: http.StatusConflict}
+		response.WriteErrorResponse(externalErr, w, logger)
+		return
+	}
+
+	if !existing.Completed {
+		w.Header().Set("Retry-After", "1")
+		externalErr := &internal.ExternalError{Message: "a request with this Idempotency-Key is still being processed", Code: http.StatusConflict}
+		response.WriteErrorResponse(externalErr, w, logger)
+		return
+	}
+
+	logger.Info("event=idempotentReplay", "status", existing.Status)
+	for k, values := range existing.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.Header().Set(internal.IdempotentReplayed, "true")
+	w.WriteHeader(existing.Status)
+	if _, err := w.Write(existing.Body); err != nil {
+		logger.Error("event=idempotentReplayFailure", "error", err)
+	}
+}
+
+// hashRequest fingerprints the method, target and body so that a reused key with a different payload can be detected.
+// The body is read within the request body limit and restored for the handler.
+func hashRequest(r *http.Request) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, request.BodyLimitFromContext(r.Context())))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return "", &internal.ExternalError{Message: "Request body is too large", Code: http.StatusRequestEntityTooLarge}
+			}
+			return "", &internal.ExternalError{Message: "Request body could not be read", Code: http.StatusBadRequest}
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// idempotencyScope scopes keys to the authenticated fingerprint, or to the client IP for public routes.
+func idempotencyScope(r *http.Request) string {
+	if userCtx, ok := server.UserFromContext(r.Context()); ok && userCtx != nil && userCtx.Fingerprint != "" {
+		return "fp:" + userCtx.Fingerprint
+	}
+	return "ip:" + clientIP(r)
+}
+
+func replayableHeaders(header http.Header) http.Header {
+	replayable := make(http.Header, len(header))
+	for k, values := range header {
+		if nonReplayableHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		replayable[k] = append([]string(nil), values...)
+	}
+	return replayable
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func NewIdempotencyHandler(logger slog.Logger, store idempotency.Store, config internal.IdempotencyConfig) *IdempotencyHandler {
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	lockTimeout := config.LockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = time.Minute
+	}
+	return &IdempotencyHandler{
+		logger:      logger,
+		store:       store,
+		ttl:         ttl,
+		lockTimeout: lockTimeout,
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/idempotency"
+	"xrf197ilz35aq/internal/server/api/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.DiscardHandler)
+
+func TestIdempotencyHandler(t *testing.T) {
+	var calls atomic.Int32
+	created := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-Account", "acct-1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"accountId":"acct-1"}`))
+	})
+
+	store := idempotency.NewMemoryStore()
+	handler := NewIdempotencyHandler(*testLogger, store, internal.IdempotencyConfig{}).Handler(created)
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/account", strings.NewReader(body))
+		r.Header.Set(internal.IdempotencyKey, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := send("key-1", `{"currency":"USD"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	t.Run("replays the stored response", func(t *testing.T) {
+		replay := send("key-1", `{"currency":"USD"}`)
+		assert.Equal(t, http.StatusCreated, replay.Code)
+		assert.Equal(t, first.Body.String(), replay.Body.String())
+		assert.Equal(t, "acct-1", replay.Header().Get("X-Account"))
+		assert.Equal(t, "true", replay.Header().Get(internal.IdempotentReplayed))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("rejects a reused key with a different payload", func(t *testing.T) {
+		conflict := send("key-1", `{"currency":"BTC"}`)
+		assert.Equal(t, http.StatusConflict, conflict.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("rejects a repeat while the first request is in flight", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/account", strings.NewReader(`{}`))
+		requestHash, err := hashRequest(r)
+		assert.NoError(t, err)
+		_, reserved, _ := store.Reserve(r.Context(), idempotencyScope(r)+":key-2", requestHash, time.Minute)
+		assert.True(t, reserved)
+
+		inFlight := send("key-2", `{}`)
+		assert.Equal(t, http.StatusConflict, inFlight.Code)
+		assert.Equal(t, "1", inFlight.Header().Get("Retry-After"))
+	})
+
+	t.Run("ignores requests without a key", func(t *testing.T) {
+		send("", `{"currency":"USD"}`)
+		send("", `{"currency":"USD"}`)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+}
+
+func TestIdempotencyHandler_RouteBodyLimit(t *testing.T) {
+	var calls atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/user", request.WithBodyLimit(16, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	route := func(r *http.Request) http.Handler {
+		handler, _ := mux.Handler(r)
+		return handler
+	}
+	idempotencyMiddleware := NewIdempotencyHandler(*testLogger, idempotency.NewMemoryStore(), internal.IdempotencyConfig{})
+	handler := NewBodyLimitHandler(request.DefaultMaxBodyBytes, route).Handler(idempotencyMiddleware.Handler(mux))
+
+	send := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/user", strings.NewReader(body))
+		r.Header.Set(internal.IdempotencyKey, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// over the limit of the route, well under the server wide one
+	tooLarge := send(`{"name":"more than sixteen bytes"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code)
+	assert.Equal(t, int32(0), calls.Load())
+
+	assert.Equal(t, http.StatusCreated, send(`{}`).Code, "the key is not held by the rejected request")
+	assert.Equal(t, int32(1), calls.Load())
+}
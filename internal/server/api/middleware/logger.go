@@ -3,25 +3,47 @@ package middleware
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"xrf197ilz35aq/internal"
 	"xrf197ilz35aq/internal/random"
+	"xrf197ilz35aq/internal/redact"
 	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/tracing"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// responseWriter is a wrapper around http.ResponseWriter that captures the status code
+const maxRequestIdLength = 128
+
+// responseWriter is a wrapper around http.ResponseWriter that captures the status code, the size of the body and,
+// when the body logging policy asks for it, the body itself up to limit bytes.
 type responseWriter struct {
 	http.ResponseWriter
 	status int
-	body   bytes.Buffer // // A buffer for the response body
+	size   int
+	// capture is set for bodies that are logged, captureErrors adds the bodies of 4xx/5xx responses.
+	capture       bool
+	captureErrors bool
+	limit         int
+	overflow      bool
+	body          bytes.Buffer // A buffer for the response body
 }
 
 // WriteHeader writes the status code to the response.
 func (w *responseWriter) WriteHeader(status int) {
 	w.status = status
+	if status >= http.StatusBadRequest && w.captureErrors {
+		w.capture = true
+	}
 	w.ResponseWriter.WriteHeader(status)
 }
 
@@ -31,30 +53,71 @@ func (w *responseWriter) Header() http.Header {
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
-	w.body.Write(b) // Write to the buffer
+	w.size += len(b)
+	if w.capture && !w.overflow {
+		if w.limit > 0 && w.body.Len()+len(b) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b) // Write to the buffer
+		}
+	}
 	return w.ResponseWriter.Write(b)
 }
 
-// LoggerHandler is a middleware that logs requests.
+// Flush sends what was written so far to the client, streaming handlers rely on it (over HTTP/1.1 and HTTP/2).
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the writer of the server (deadlines, full duplex).
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// LoggerHandler is a middleware that logs requests. Response bodies are logged according to the policy of the route
+// (none, metadata or full), always with sensitive fields masked. Requests sending the debug token in the
+// Xrf-debug-log header get a request-scoped logger logging at DEBUG, whatever the configured levels are.
 type LoggerHandler struct {
-	logger *slog.Logger
+	logger     *slog.Logger
+	debugToken string
+	body       internal.LogBodyConfig
+	// routes are sorted by descending prefix length so that the most specific rule matches first.
+	routes []internal.LogBodyRule
+	sample func() float64
 }
 
 func (lh *LoggerHandler) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestId := GenerateRequestId()
+		r = withRequestContext(r)
+		requestId := server.RequestIdFromContext(r.Context())
+
 		start := time.Now()
-		loggerWithReqId := lh.logger.With("requestId", requestId)
+		logger := lh.logger
+		if lh.isDebugRequest(r) {
+			logger = internal.WithForcedLevel(logger, slog.LevelDebug)
+		}
+		loggerWithReqId := requestScopedLogger(logger, r.Context())
 
 		path := r.URL.Path
 		loggerWithReqId.Info("event=incomingRequest", "method", r.Method, "url", path, "remoteAddr", r.RemoteAddr)
+		loggerWithReqId.Debug("event=requestHeaders", "headers", redact.Headers(r.Header))
 
 		// Wrap the response writer to capture the status code.
+		policy := lh.policyFor(path)
 		wrappedWriter := &responseWriter{
 			ResponseWriter: w,
 			status:         http.StatusOK,
+			capture:        policy == internal.LogBodyFull,
+			captureErrors:  policy != internal.LogBodyNone,
+		}
+		// large bodies are only kept for the sampled requests
+		if lh.body.LargeBodyBytes > 0 && lh.sample() >= lh.body.LargeSampleRate {
+			wrappedWriter.limit = lh.body.LargeBodyBytes
 		}
-		wrappedWriter.Header().Set("req-trace-id", requestId)
+		wrappedWriter.Header().Set(internal.RequestIdHeader, requestId)
 
 		// 4. Create a new context with our request-scoped logger.
 		ctx := context.WithValue(r.Context(), server.LoggerContextKey, loggerWithReqId)
@@ -66,19 +129,109 @@ func (lh *LoggerHandler) Handler(next http.Handler) http.Handler {
 		timeTaken := time.Since(start)
 		status := wrappedWriter.status
 
-		data := wrappedWriter.body.String()
+		attrs := []any{"url", path, "latency", timeTaken, "status", status}
+		if policy != internal.LogBodyNone {
+			attrs = append(attrs, "bodyBytes", wrappedWriter.size, "contentType", wrappedWriter.Header().Get(internal.ContentType))
+		}
+		bodyKey := "body"
 		if status >= 400 {
-			loggerWithReqId.Error("event=response", "url", path, "latency", timeTaken, "error", data, "status", status)
+			bodyKey = "error"
+		}
+		switch {
+		case wrappedWriter.overflow:
+			attrs = append(attrs, "bodyOmitted", "large body not sampled")
+		case wrappedWriter.capture:
+			attrs = append(attrs, bodyKey, redact.JSON(wrappedWriter.body.Bytes()))
+		}
+
+		if status >= 400 {
+			loggerWithReqId.Error("event=response", attrs...)
 		} else {
-			loggerWithReqId.Info("event=response", "url", path, "latency", timeTaken, "status", status, "body", data)
+			loggerWithReqId.Info("event=response", attrs...)
 		}
 	})
 }
 
-func NewLoggerHandler(logger *slog.Logger) *LoggerHandler {
+func (lh *LoggerHandler) policyFor(path string) string {
+	for _, rule := range lh.routes {
+		if strings.HasPrefix(path, rule.PathPrefix) {
+			return rule.Policy
+		}
+	}
+	if lh.body.Policy != "" {
+		return lh.body.Policy
+	}
+	return internal.LogBodyMetadata
+}
+
+func (lh *LoggerHandler) isDebugRequest(r *http.Request) bool {
+	token := r.Header.Get(internal.XrfDebugLog)
+	return lh.debugToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(lh.debugToken)) == 1
+}
+
+func NewLoggerHandler(logger *slog.Logger, config internal.LogConfig) *LoggerHandler {
+	routes := slices.Clone(config.Body.Routes)
+	slices.SortStableFunc(routes, func(a, b internal.LogBodyRule) int {
+		return len(b.PathPrefix) - len(a.PathPrefix)
+	})
 	return &LoggerHandler{
-		logger: logger,
+		logger:     logger,
+		debugToken: config.DebugToken,
+		body:       config.Body,
+		routes:     routes,
+		sample:     rand.Float64,
+	}
+}
+
+// withRequestContext accepts the caller's request id, or generates one, and extracts the caller's W3C trace context
+// into the request context. It is a no-op when an outer middleware already did it.
+func withRequestContext(r *http.Request) *http.Request {
+	ctx := r.Context()
+	if server.RequestIdFromContext(ctx) != "" {
+		return r
+	}
+
+	requestId := r.Header.Get(internal.RequestIdHeader)
+	if !isValidRequestId(requestId) {
+		requestId = GenerateRequestId()
+	}
+	ctx = server.ContextWithRequestId(ctx, requestId)
+	ctx = tracing.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx = context.WithValue(ctx, serverSpanKey{}, &serverSpanHolder{})
+	return r.WithContext(ctx)
+}
+
+type serverSpanKey struct{}
+
+// serverSpanHolder lets outer middlewares (i.e. RecoveryHandler) see the server span started further down the chain.
+type serverSpanHolder struct {
+	spanContext trace.SpanContext
+}
+
+// requestScopedLogger returns a logger that tags every line with the request id and trace context of ctx.
+func requestScopedLogger(logger *slog.Logger, ctx context.Context) *slog.Logger {
+	traceId, spanId := tracing.Ids(ctx)
+	if holder, ok := ctx.Value(serverSpanKey{}).(*serverSpanHolder); ok && holder.spanContext.IsValid() && traceId == "" {
+		traceId, spanId = holder.spanContext.TraceID().String(), holder.spanContext.SpanID().String()
+	}
+	return logger.With(
+		"requestId", server.RequestIdFromContext(ctx),
+		"traceId", traceId,
+		"spanId", spanId,
+	)
+}
+
+// isValidRequestId only accepts short ids made of URL safe characters, anything else is replaced with our own id.
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range requestId {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
 	}
+	return true
 }
 
 func GenerateRequestId() string {
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// responseRecord serves body on path through a LoggerHandler and returns the attributes of its event=response record.
+func responseRecord(t *testing.T, config internal.LogConfig, path string, status int, body string) map[string]any {
+	t.Helper()
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	handler := NewLoggerHandler(logger, config).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(internal.ContentType, internal.ApplicationJson)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, body, w.Body.String(), "the client always gets the whole body")
+
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == "event=response" {
+			return record
+		}
+	}
+	t.Fatal("no event=response record")
+	return nil
+}
+
+func TestLoggerHandler_BodyPolicies(t *testing.T) {
+	config := internal.LogConfig{Body: internal.LogBodyConfig{
+		Policy: internal.LogBodyFull,
+		Routes: []internal.LogBodyRule{
+			{PathPrefix: "/api/v1", Policy: internal.LogBodyMetadata},
+			{PathPrefix: "/api/v1/auth", Policy: internal.LogBodyNone},
+		},
+	}}
+	body := `{"userId":"u1","token":"secret"}`
+
+	t.Run("full logs the redacted body", func(t *testing.T) {
+		record := responseRecord(t, config, "/health", http.StatusOK, body)
+		assert.JSONEq(t, `{"userId":"u1","token":"[REDACTED]"}`, record["body"].(string))
+	})
+
+	t.Run("metadata logs the size and content type", func(t *testing.T) {
+		record := responseRecord(t, config, "/api/v1/account", http.StatusOK, body)
+		assert.NotContains(t, record, "body")
+		assert.Equal(t, float64(len(body)), record["bodyBytes"])
+		assert.Equal(t, internal.ApplicationJson, record["contentType"])
+	})
+
+	t.Run("metadata logs error bodies", func(t *testing.T) {
+		record := responseRecord(t, config, "/api/v1/account", http.StatusBadRequest, `{"error":"bad email","email":"a@b.c"}`)
+		assert.JSONEq(t, `{"error":"bad email","email":"[REDACTED]"}`, record["error"].(string))
+	})
+
+	t.Run("the longest prefix wins and none logs nothing", func(t *testing.T) {
+		record := responseRecord(t, config, "/api/v1/auth/token", http.StatusUnauthorized, body)
+		assert.NotContains(t, record, "error")
+		assert.NotContains(t, record, "bodyBytes")
+	})
+
+	t.Run("large bodies are not logged unless sampled", func(t *testing.T) {
+		large := config
+		large.Body.LargeBodyBytes = 10
+		record := responseRecord(t, large, "/health", http.StatusOK, body)
+		assert.NotContains(t, record, "body")
+		assert.Equal(t, "large body not sampled", record["bodyOmitted"])
+
+		large.Body.LargeSampleRate = 1
+		record = responseRecord(t, large, "/health", http.StatusOK, body)
+		assert.Contains(t, record, "body")
+	})
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+	"xrf197ilz35aq/internal/metrics"
+)
+
+// unmatchedRoute labels requests that did not match any route, so random paths cannot blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+// MetricsHandler is a middleware that records request count, latency and in-flight requests per route pattern.
+// It must run inside RecoveryHandler, panics are recorded as 500s.
+type MetricsHandler struct {
+	routePattern RoutePatternFunc
+}
+
+func (mh *MetricsHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mh.routePattern(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		done := metrics.TrackInFlight()
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			done()
+			if recovered := recover(); recovered != nil {
+				metrics.ObserveHTTPRequest(route, r.Method, http.StatusInternalServerError, time.Since(start))
+				panic(recovered)
+			}
+			metrics.ObserveHTTPRequest(route, r.Method, recorder.status, time.Since(start))
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+func NewMetricsHandler(routePattern RoutePatternFunc) *MetricsHandler {
+	return &MetricsHandler{routePattern: routePattern}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestCount reads the xrf_se_http_requests_total series of route and status from the shared registry, tests assert
+// the change from a value read before since other tests (or runs) count requests too.
+func requestCount(t *testing.T, route string, status string) float64 {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "xrf_se_http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["route"] == route && labels["method"] == http.MethodGet && labels["status"] == status {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetricsHandler(t *testing.T) {
+	const route = "GET /api/v1/accounts/{accountId}"
+	handler := NewMetricsHandler(func(r *http.Request) string {
+		if r.URL.Path == "/unknown" {
+			return ""
+		}
+		return route
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	routeBefore := requestCount(t, route, "404")
+	unmatchedBefore := requestCount(t, unmatchedRoute, "404")
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/accounts/acct-1", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/accounts/acct-2", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unknown", nil))
+
+	// both account lookups share the route pattern series, the unknown path gets the "unmatched" series
+	assert.Equal(t, routeBefore+2, requestCount(t, route, "404"))
+	assert.Equal(t, unmatchedBefore+1, requestCount(t, unmatchedRoute, "404"))
+}
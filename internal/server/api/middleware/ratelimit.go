@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/ratelimit"
+	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+const defaultRateLimitGroup = "default"
+
+// RateLimitKey is what the buckets of a RateLimitHandler are keyed by.
+type RateLimitKey string
+
+const (
+	// RateLimitByIP limits every request by client IP with the rateLimit.ip limits, it runs before
+	// AuthenticationMiddleware so that requests with an invalid token are limited before their token is checked upstream.
+	RateLimitByIP RateLimitKey = "ip"
+	// RateLimitByUser limits authenticated requests by fingerprint, it runs after AuthenticationMiddleware and lets
+	// anonymous requests through (RateLimitByIP covers them).
+	RateLimitByUser RateLimitKey = "fp"
+)
+
+type rateLimitGroup struct {
+	name       string
+	pathPrefix string
+	limit      ratelimit.Limit
+}
+
+type rateLimitRules struct {
+	// groups are sorted by descending prefix length so that the most specific group matches first.
+	groups       []rateLimitGroup
+	defaultLimit ratelimit.Limit
+}
+
+// RateLimitHandler is a middleware that applies token bucket limits per route group, with one bucket per client IP
+// or per user depending on its RateLimitKey.
+type RateLimitHandler struct {
+	logger  slog.Logger
+	limiter ratelimit.Limiter
+	keyBy   RateLimitKey
+	rules   atomic.Pointer[rateLimitRules]
+}
+
+func (rh *RateLimitHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groupName, limit := rh.limitFor(r.URL.Path)
+		if limit.Unlimited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, ok := rh.identity(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := server.LoggerFromContext(r.Context(), rh.logger)
+		key := groupName + ":" + identity
+		result, err := rh.limiter.Allow(r.Context(), key, limit)
+		if err != nil {
+			// fail open, an unavailable limiter backend must not take the API down with it
+			logger.Error("event=rateLimitFailure", "group", groupName, "keyBy", rh.keyBy, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(max(result.Remaining, 0)))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+		if !result.Allowed {
+			header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			logger.Warn("event=rateLimited", "group", groupName, "keyBy", rh.keyBy, "clientIp", clientIP(r))
+			externalErr := &internal.ExternalError{Message: "too many requests, slow down", Code: http.StatusTooManyRequests}
+			response.WriteErrorResponse(externalErr, w, *logger)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rh *RateLimitHandler) limitFor(path string) (string, ratelimit.Limit) {
+	rules := rh.rules.Load()
+	for _, group := range rules.groups {
+		if strings.HasPrefix(path, group.pathPrefix) {
+			return group.name, group.limit
+		}
+	}
+	return defaultRateLimitGroup, rules.defaultLimit
+}
+
+// Update replaces the default limit and the groups (e.g. on a configuration reload), the limits per IP for
+// RateLimitByIP and the ones per user otherwise. Buckets already filled keep their tokens and refill at the new rate.
+func (rh *RateLimitHandler) Update(config internal.RateLimitConfig) {
+	rules := config.RateLimitRules
+	if rh.keyBy == RateLimitByIP {
+		rules = config.IP
+	}
+	groups := make([]rateLimitGroup, 0, len(rules.Groups))
+	for _, group := range rules.Groups {
+		groups = append(groups, rateLimitGroup{
+			name:       group.Name,
+			pathPrefix: group.PathPrefix,
+			limit:      toLimit(group.RateLimitRule),
+		})
+	}
+	slices.SortStableFunc(groups, func(a, b rateLimitGroup) int {
+		return len(b.pathPrefix) - len(a.pathPrefix)
+	})
+	rh.rules.Store(&rateLimitRules{groups: groups, defaultLimit: toLimit(rules.Default)})
+}
+
+// identity returns the bucket key of the request, false when the request is not limited by this handler.
+func (rh *RateLimitHandler) identity(r *http.Request) (string, bool) {
+	if rh.keyBy == RateLimitByIP {
+		return "ip:" + clientIP(r), true
+	}
+	if userCtx, ok := server.UserFromContext(r.Context()); ok && userCtx != nil && userCtx.Fingerprint != "" {
+		return "fp:" + userCtx.Fingerprint, true
+	}
+	return "", false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func toLimit(rule internal.RateLimitRule) ratelimit.Limit {
+	return ratelimit.Limit{Rate: rule.RequestsPerSecond, Burst: rule.Burst}
+}
+
+func NewRateLimitHandler(logger slog.Logger, limiter ratelimit.Limiter, config internal.RateLimitConfig, keyBy RateLimitKey) *RateLimitHandler {
+	rateLimitHandler := &RateLimitHandler{
+		logger:  logger,
+		limiter: limiter,
+		keyBy:   keyBy,
+	}
+	rateLimitHandler.Update(config)
+	return rateLimitHandler
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/ratelimit"
+	"xrf197ilz35aq/internal/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("redis is down")
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	rules := internal.RateLimitRules{
+		Default: internal.RateLimitRule{RequestsPerSecond: 1, Burst: 2},
+		Groups: []internal.RateLimitGroup{
+			{Name: "health", PathPrefix: "/health"},
+			{Name: "api", PathPrefix: "/api/v1", RateLimitRule: internal.RateLimitRule{RequestsPerSecond: 1, Burst: 3}},
+			{Name: "auth", PathPrefix: "/api/v1/auth", RateLimitRule: internal.RateLimitRule{RequestsPerSecond: 1, Burst: 1}},
+		},
+	}
+	config := internal.RateLimitConfig{Enabled: true, RateLimitRules: rules, IP: rules}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	newRequest := func(path, ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = ip + ":50000"
+		return r
+	}
+	serve := func(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("rejects with a 429 and retry headers once the bucket is empty", func(t *testing.T) {
+		handler := NewRateLimitHandler(*testLogger, ratelimit.NewMemoryLimiter(), config, RateLimitByIP).Handler(ok)
+
+		first := serve(handler, newRequest("/accounts", "10.0.0.1"))
+		assert.Equal(t, http.StatusNoContent, first.Code)
+		assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1", first.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, first.Header().Get("Retry-After"))
+
+		serve(handler, newRequest("/accounts", "10.0.0.1"))
+		limited := serve(handler, newRequest("/accounts", "10.0.0.1"))
+		assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+		assert.JSONEq(t, `{"error":"too many requests, slow down","code":429}`, limited.Body.String())
+		assert.Equal(t, "0", limited.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "1", limited.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusNoContent, serve(handler, newRequest("/accounts", "10.0.0.2")).Code, "other IPs have their own bucket")
+	})
+
+	t.Run("the most specific group applies and groups have their own buckets", func(t *testing.T) {
+		handler := NewRateLimitHandler(*testLogger, ratelimit.NewMemoryLimiter(), config, RateLimitByIP).Handler(ok)
+
+		assert.Equal(t, "1", serve(handler, newRequest("/api/v1/auth/login", "10.0.0.1")).Header().Get("RateLimit-Limit"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(handler, newRequest("/api/v1/auth/login", "10.0.0.1")).Code)
+
+		api := serve(handler, newRequest("/api/v1/user", "10.0.0.1"))
+		assert.Equal(t, http.StatusNoContent, api.Code)
+		assert.Equal(t, "3", api.Header().Get("RateLimit-Limit"))
+	})
+
+	t.Run("a group without a rate is not limited", func(t *testing.T) {
+		handler := NewRateLimitHandler(*testLogger, ratelimit.NewMemoryLimiter(), config, RateLimitByIP).Handler(ok)
+
+		for range 5 {
+			w := serve(handler, newRequest("/health", "10.0.0.1"))
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("per user limits only apply to authenticated requests", func(t *testing.T) {
+		handler := NewRateLimitHandler(*testLogger, ratelimit.NewMemoryLimiter(), config, RateLimitByUser).Handler(ok)
+		authenticated := func(fingerprint, ip string) *http.Request {
+			r := newRequest("/accounts", ip)
+			return r.WithContext(server.ContextWithUserCtx(r.Context(), &model.UserContext{Fingerprint: fingerprint}))
+		}
+
+		serve(handler, authenticated("fp-1", "10.0.0.1"))
+		serve(handler, authenticated("fp-1", "10.0.0.2"))
+		assert.Equal(t, http.StatusTooManyRequests, serve(handler, authenticated("fp-1", "10.0.0.3")).Code, "the bucket follows the user")
+
+		for range 3 {
+			w := serve(handler, newRequest("/accounts", "10.0.0.1"))
+			assert.Equal(t, http.StatusNoContent, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("fails open when the limiter is unavailable", func(t *testing.T) {
+		handler := NewRateLimitHandler(*testLogger, failingLimiter{}, config, RateLimitByIP).Handler(ok)
+
+		assert.Equal(t, http.StatusNoContent, serve(handler, newRequest("/accounts", "10.0.0.1")).Code)
+	})
+
+	t.Run("limits change on update", func(t *testing.T) {
+		rateLimitHandler := NewRateLimitHandler(*testLogger, ratelimit.NewMemoryLimiter(), config, RateLimitByIP)
+		handler := rateLimitHandler.Handler(ok)
+
+		updated := config
+		updated.IP.Default = internal.RateLimitRule{}
+		rateLimitHandler.Update(updated)
+		assert.Empty(t, serve(handler, newRequest("/accounts", "10.0.0.1")).Header().Get("RateLimit-Limit"))
+	})
+
+	t.Run("limits per IP are separate from the limits per user", func(t *testing.T) {
+		separate := config
+		separate.IP = internal.RateLimitRules{Default: internal.RateLimitRule{RequestsPerSecond: 1, Burst: 10}}
+		limiter := ratelimit.NewMemoryLimiter()
+		byIP := NewRateLimitHandler(*testLogger, limiter, separate, RateLimitByIP).Handler(ok)
+		byUser := NewRateLimitHandler(*testLogger, limiter, separate, RateLimitByUser).Handler(ok)
+		r := newRequest("/api/v1/auth/login", "10.0.0.1")
+		r = r.WithContext(server.ContextWithUserCtx(r.Context(), &model.UserContext{Fingerprint: "fp-1"}))
+
+		assert.Equal(t, "10", serve(byIP, r).Header().Get("RateLimit-Limit"), "the IP default applies, not the user groups")
+		assert.Equal(t, "1", serve(byUser, r).Header().Get("RateLimit-Limit"))
+	})
+}
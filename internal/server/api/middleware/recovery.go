@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+// statusRecorder records the response status and whether the status line has already been sent to the client.
+// Unlike responseWriter it does not buffer the body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.status = http.StatusOK
+		w.wroteHeader = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what was written so far to the client, streaming handlers rely on it (over HTTP/1.1 and HTTP/2).
+func (w *statusRecorder) Flush() {
+	if !w.wroteHeader {
+		w.status = http.StatusOK
+		w.wroteHeader = true
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the writer of the server (deadlines, full duplex).
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// handlerPanic is a panic recovered on the goroutine of a handler (see TimeoutHandler) and raised again on the serving
+// goroutine, stack is the one of the handler, the serving goroutine would only show the middleware re-panicking.
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p handlerPanic) String() string {
+	return fmt.Sprint(p.value)
+}
+
+// RecoveryHandler is a middleware that turns panics in handlers/processors into 500 responses.
+// It must be the outermost middleware, it assigns the request id/trace context so the panic can be logged against it.
+type RecoveryHandler struct {
+	logger *slog.Logger
+	panics atomic.Uint64
+	// onPanic is called for every recovered panic, e.g. to increment a metric.
+	onPanic func(r *http.Request)
+}
+
+func (rh *RecoveryHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestContext(r)
+		trackingWriter := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			stack := debug.Stack()
+			if p, ok := recovered.(handlerPanic); ok {
+				recovered, stack = p.value, p.stack
+			}
+			// http.ErrAbortHandler is net/http's way of aborting a response, let it do its job
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(recovered)
+			}
+
+			rh.panics.Add(1)
+			if rh.onPanic != nil {
+				rh.onPanic(r)
+			}
+
+			logger := requestScopedLogger(rh.logger, r.Context())
+			logger.Error("event=panicRecovered",
+				"method", r.Method, "url", r.URL.Path, "panic", fmt.Sprint(recovered), "stack", string(stack))
+
+			if trackingWriter.wroteHeader {
+				// part of the response is already on the wire, the only honest thing left is to abort the connection
+				panic(http.ErrAbortHandler)
+			}
+
+			serverErr := &internal.ServerError{Message: "internal server error", Err: fmt.Errorf("panic: %v", recovered)}
+			response.WriteErrorResponse(serverErr, trackingWriter, *logger)
+			logger.Error("event=response", "url", r.URL.Path, "status", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(trackingWriter, r)
+	})
+}
+
+// PanicCount returns the number of panics recovered since the server started.
+func (rh *RecoveryHandler) PanicCount() uint64 {
+	return rh.panics.Load()
+}
+
+// OnPanic registers a callback that is invoked for every recovered panic.
+func (rh *RecoveryHandler) OnPanic(fn func(r *http.Request)) {
+	rh.onPanic = fn
+}
+
+func NewRecoveryHandler(logger *slog.Logger) *RecoveryHandler {
+	return &RecoveryHandler{logger: logger}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryHandler(t *testing.T) {
+	t.Run("writes a 500 when nothing was sent yet", func(t *testing.T) {
+		recovery := NewRecoveryHandler(testLogger)
+		handler := recovery.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var account *struct{ Id string }
+			_ = account.Id
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/account", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":"internal server error","code":500}`, w.Body.String())
+		assert.Equal(t, uint64(1), recovery.PanicCount())
+	})
+
+	t.Run("aborts the response when headers were already sent", func(t *testing.T) {
+		recovery := NewRecoveryHandler(testLogger)
+		handler := recovery.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			panic("half way through")
+		}))
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+		})
+		assert.Equal(t, uint64(1), recovery.PanicCount())
+	})
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStreamingThroughMiddleware checks that flushes reach the client over HTTP/2 through every wrapping writer.
+func TestStreamingThroughMiddleware(t *testing.T) {
+	firstRead := make(chan struct{})
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(internal.ContentType, "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n"))
+		assert.NoError(t, http.NewResponseController(w).Flush())
+		// the second event is only sent once the client got the first one
+		<-firstRead
+		_, _ = w.Write([]byte("data: second\n"))
+	})
+	route := func(*http.Request) string { return "GET /events" }
+	handler := NewRecoveryHandler(testLogger).Handler(
+		NewMetricsHandler(route).Handler(
+			NewTracingHandler(route).Handler(
+				NewLoggerHandler(testLogger, internal.LogConfig{}).Handler(stream),
+			),
+		),
+	)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+
+	reader := bufio.NewReader(resp.Body)
+	first, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: first\n", first)
+	close(firstRead)
+	second, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: second\n", second)
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/server/api/response"
+)
+
+// errBufferedResponse is returned to handlers flushing or hijacking the response of a route with a deadline.
+var errBufferedResponse = errors.New("the response is buffered until the handler returns, only streaming routes can flush or hijack it")
+
+// timeoutWriter buffers the response until the handler returns, so that a 504 can still be sent when the deadline
+// expires first. Writes made after that are discarded.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	mut         sync.Mutex
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+	// finished is set when the handler returned, its response is sent even if the deadline expired meanwhile.
+	finished bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+// FlushError makes http.ResponseController.Flush fail rather than send part of a response that may become a 504.
+func (w *timeoutWriter) FlushError() error {
+	return errBufferedResponse
+}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errBufferedResponse
+}
+
+// Unwrap lets http.ResponseController reach the writer of the server (deadlines, full duplex).
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+func (w *timeoutWriter) finish() {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.finished = true
+}
+
+// expire discards the writes to come, it returns false when the handler had already returned.
+func (w *timeoutWriter) expire() bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.finished {
+		return false
+	}
+	w.timedOut = true
+	return true
+}
+
+// writeTo sends the response of a handler that returned.
+func (w *timeoutWriter) writeTo(dst http.ResponseWriter) {
+	header := dst.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	dst.WriteHeader(w.status)
+	_, _ = dst.Write(w.body.Bytes())
+}
+
+type timeoutRules struct {
+	// routes are sorted by descending prefix length so that the most specific route matches first.
+	routes         []internal.RouteTimeout
+	defaultTimeout time.Duration
+}
+
+// TimeoutHandler is a middleware that sets the deadline of the route on the request context, upstream calls made with
+// it are cancelled when it expires and the client gets a 504 if the handler is still running, the response of a
+// handler that returned is sent as it is. Streaming routes
+// opt out, their response is written as it comes and the server write timeout is lifted, their context is cancelled
+// by EndStreams instead so that they end when the server drains.
+type TimeoutHandler struct {
+	logger     slog.Logger
+	rules      atomic.Pointer[timeoutRules]
+	draining   context.Context
+	endStreams context.CancelFunc
+	// onPanic is called for the panics of handlers that outlived their deadline, RecoveryHandler no longer sees them.
+	onPanic func(r *http.Request)
+}
+
+func (th *TimeoutHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := th.routeFor(r)
+		if route.Streaming {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				server.LoggerFromContext(r.Context(), th.logger).Warn("failed to lift the write deadline of a streaming route", "error", err)
+			}
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			stop := context.AfterFunc(th.draining, cancel)
+			defer stop()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if route.Timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{w: w, header: make(http.Header), status: http.StatusOK}
+		done := make(chan struct{})
+		panicked := make(chan handlerPanic, 1)
+		go func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					panicked <- handlerPanic{value: recovered, stack: debug.Stack()}
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			tw.finish()
+			close(done)
+		}()
+
+		select {
+		case recovered := <-panicked:
+			// re-panic on the serving goroutine so that RecoveryHandler sees it, with the stack of the handler
+			panic(recovered)
+		case <-done:
+			tw.writeTo(w)
+		case <-ctx.Done():
+			if !tw.expire() {
+				// the handler returned as the deadline expired, its response stands
+				tw.writeTo(w)
+				return
+			}
+			go th.watchLatePanic(r, done, panicked)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				th.writeTimeout(w, r, route.Timeout)
+			}
+		}
+	})
+}
+
+// watchLatePanic logs and reports a panic of a handler still running once its response was given up, the serving
+// goroutine is gone and would never read it.
+func (th *TimeoutHandler) watchLatePanic(r *http.Request, done <-chan struct{}, panicked <-chan handlerPanic) {
+	select {
+	case <-done:
+	case p := <-panicked:
+		if err, ok := p.value.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+			return
+		}
+		logger := server.LoggerFromContext(r.Context(), th.logger)
+		logger.Error("event=panicAfterTimeout",
+			"method", r.Method, "url", r.URL.Path, "panic", fmt.Sprint(p.value), "stack", string(p.stack))
+		if th.onPanic != nil {
+			th.onPanic(r)
+		}
+	}
+}
+
+func (th *TimeoutHandler) writeTimeout(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	logger := server.LoggerFromContext(r.Context(), th.logger)
+	logger.Warn("event=requestTimeout", "method", r.Method, "url", r.URL.Path, "timeout", timeout)
+	externalErr := &internal.ExternalError{Message: "request timed out", Code: http.StatusGatewayTimeout}
+	response.WriteErrorResponse(externalErr, w, *logger)
+}
+
+func (th *TimeoutHandler) routeFor(r *http.Request) internal.RouteTimeout {
+	rules := th.rules.Load()
+	for _, route := range rules.routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) && (route.Method == "" || route.Method == r.Method) {
+			return route
+		}
+	}
+	return internal.RouteTimeout{Timeout: rules.defaultTimeout}
+}
+
+// EndStreams cancels the context of the streaming requests, in flight and to come, http.Server.Shutdown would
+// otherwise wait for them until its deadline.
+func (th *TimeoutHandler) EndStreams() {
+	if th.draining.Err() == nil {
+		th.logger.Info("event=streamsEnding")
+	}
+	th.endStreams()
+}
+
+// OnPanic registers a callback that is invoked for every panic of a handler that outlived its deadline.
+func (th *TimeoutHandler) OnPanic(fn func(r *http.Request)) {
+	th.onPanic = fn
+}
+
+// Update replaces the deadlines (e.g. on a configuration reload), requests in flight keep theirs.
+func (th *TimeoutHandler) Update(config internal.AppConfig) {
+	routes := slices.Clone(config.RouteTimeouts)
+	slices.SortStableFunc(routes, func(a, b internal.RouteTimeout) int {
+		return len(b.PathPrefix) - len(a.PathPrefix)
+	})
+	th.rules.Store(&timeoutRules{routes: routes, defaultTimeout: config.RequestTimeout})
+}
+
+func NewTimeoutHandler(logger slog.Logger, config internal.AppConfig) *TimeoutHandler {
+	draining, endStreams := context.WithCancel(context.Background())
+	timeoutHandler := &TimeoutHandler{logger: logger, draining: draining, endStreams: endStreams}
+	timeoutHandler.Update(config)
+	return timeoutHandler
+}
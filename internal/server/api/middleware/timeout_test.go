@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutHandler(t *testing.T) {
+	config := internal.AppConfig{
+		RequestTimeout: 20 * time.Millisecond,
+		RouteTimeouts: []internal.RouteTimeout{
+			{PathPrefix: "/api/v1", Timeout: time.Second},
+			{PathPrefix: "/api/v1/user", Method: http.MethodPost, Timeout: 10 * time.Millisecond},
+			{PathPrefix: "/api/v1/events", Streaming: true},
+		},
+	}
+	serve := func(handler http.HandlerFunc, method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		NewTimeoutHandler(*testLogger, config).Handler(handler).ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	waitForDeadline := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// an upstream call cancelled by the deadline usually ends up as an error response, once the call unwound
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	t.Run("a request running past its deadline gets a 504", func(t *testing.T) {
+		w := serve(waitForDeadline, http.MethodGet, "/health")
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.JSONEq(t, `{"error":"request timed out","code":504}`, w.Body.String())
+	})
+
+	t.Run("the route deadline is on the request context", func(t *testing.T) {
+		var timeout time.Duration
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok := r.Context().Deadline()
+			assert.True(t, ok)
+			timeout = time.Until(deadline)
+			w.Header().Set("X-Test", "kept")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("created"))
+		}, http.MethodPut, "/api/v1/user")
+
+		assert.Greater(t, timeout, 500*time.Millisecond, "the method of the most specific route does not match")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "kept", w.Header().Get("X-Test"))
+		assert.Equal(t, "created", w.Body.String())
+	})
+
+	t.Run("the most specific route wins", func(t *testing.T) {
+		start := time.Now()
+		w := serve(waitForDeadline, http.MethodPost, "/api/v1/user")
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("streaming routes have no deadline", func(t *testing.T) {
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			_, ok := r.Context().Deadline()
+			assert.False(t, ok)
+			_, buffered := w.(*timeoutWriter)
+			assert.False(t, buffered, "the response is written as it comes")
+			_, _ = w.Write([]byte("data: event\n"))
+		}, http.MethodGet, "/api/v1/events")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("streams end when the server drains", func(t *testing.T) {
+		timeoutHandler := NewTimeoutHandler(*testLogger, config)
+		handler := timeoutHandler.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			_, _ = w.Write([]byte("event: end\n"))
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+		}()
+		timeoutHandler.EndStreams()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the stream did not end")
+		}
+
+		// a stream started while draining ends right away
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+		assert.Equal(t, "event: end\n", w.Body.String())
+	})
+
+	t.Run("the response of a handler that returned is not replaced by a 504", func(t *testing.T) {
+		tw := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+		tw.WriteHeader(http.StatusCreated)
+		tw.finish()
+
+		assert.False(t, tw.expire(), "the deadline expired after the handler returned")
+		w := httptest.NewRecorder()
+		tw.writeTo(w)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		unfinished := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+		assert.True(t, unfinished.expire())
+		_, err := unfinished.Write([]byte("late"))
+		assert.ErrorIs(t, err, http.ErrHandlerTimeout)
+	})
+
+	t.Run("flushing or hijacking a buffered response fails", func(t *testing.T) {
+		w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		handler := NewTimeoutHandler(*testLogger, config).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			controller := http.NewResponseController(w)
+			assert.ErrorIs(t, controller.Flush(), errBufferedResponse)
+			_, _, err := controller.Hijack()
+			assert.ErrorIs(t, err, errBufferedResponse)
+			_, isFlusher := w.(http.Flusher)
+			assert.False(t, isFlusher)
+			// the other controls reach the writer of the server
+			assert.NoError(t, controller.SetWriteDeadline(time.Now().Add(time.Minute)))
+		}))
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/accounts", nil))
+
+		assert.False(t, w.Flushed)
+		assert.Equal(t, "partial", w.Body.String())
+		assert.True(t, w.deadlineSet)
+	})
+
+	t.Run("panics reach the serving goroutine with the stack of the handler", func(t *testing.T) {
+		var out bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&out, nil))
+		recovery := NewRecoveryHandler(logger)
+		handler := recovery.Handler(NewTimeoutHandler(*logger, config).Handler(http.HandlerFunc(panickingHandler)))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, uint64(1), recovery.PanicCount())
+		var entry struct {
+			Panic string `json:"panic"`
+			Stack string `json:"stack"`
+		}
+		require.NoError(t, json.NewDecoder(&out).Decode(&entry))
+		assert.Equal(t, "boom", entry.Panic)
+		assert.Contains(t, entry.Stack, "middleware.panickingHandler")
+	})
+
+	t.Run("a panic after the deadline is logged and reported", func(t *testing.T) {
+		var out bytes.Buffer
+		timeoutHandler := NewTimeoutHandler(*slog.New(slog.NewJSONHandler(&out, nil)), config)
+		reported := make(chan string, 1)
+		timeoutHandler.OnPanic(func(r *http.Request) { reported <- r.URL.Path })
+
+		w := httptest.NewRecorder()
+		timeoutHandler.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			panickingHandler(w, r)
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+
+		select {
+		case path := <-reported:
+			assert.Equal(t, "/health", path)
+		case <-time.After(time.Second):
+			require.Fail(t, "the late panic was not reported")
+		}
+		assert.Contains(t, out.String(), "event=panicAfterTimeout")
+		assert.Contains(t, out.String(), "middleware.panickingHandler")
+	})
+}
+
+func panickingHandler(http.ResponseWriter, *http.Request) {
+	panic("boom")
+}
+
+// deadlineRecorder is a recorder supporting write deadlines, like the writer of the server.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlineSet bool
+}
+
+func (r *deadlineRecorder) SetWriteDeadline(time.Time) error {
+	r.deadlineSet = true
+	return nil
+}
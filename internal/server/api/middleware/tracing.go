@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/tracing"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RoutePatternFunc returns the ServeMux pattern (e.g. "GET /api/v1/accounts/{accountId}") a request is routed to.
+type RoutePatternFunc func(r *http.Request) string
+
+// TracingHandler is a middleware that starts the server span of every request, as a child of the caller's
+// span when a traceparent header was sent. It must run inside RecoveryHandler and outside LoggerHandler.
+type TracingHandler struct {
+	routePattern RoutePatternFunc
+}
+
+func (th *TracingHandler) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestContext(r)
+		route := th.routePattern(r)
+		spanName := route
+		if spanName == "" {
+			spanName = fmt.Sprintf("HTTP %s", r.Method)
+		}
+
+		ctx, span := tracing.Tracer().Start(r.Context(), spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(clientIP(r)),
+			),
+		)
+		defer span.End()
+
+		if holder, ok := ctx.Value(serverSpanKey{}).(*serverSpanHolder); ok {
+			holder.spanContext = span.SpanContext()
+		}
+
+		// let the caller correlate the response with our trace
+		responseTrace := map[string]string{}
+		tracing.Inject(ctx, responseTrace)
+		if traceParent := responseTrace[internal.TraceParentHeader]; traceParent != "" {
+			w.Header().Set(internal.TraceParentHeader, traceParent)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				span.SetStatus(codes.Error, fmt.Sprint(recovered))
+				span.SetAttributes(semconv.HTTPResponseStatusCode(http.StatusInternalServerError))
+				panic(recovered)
+			}
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+			if recorder.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(recorder.status))
+			}
+		}()
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+	})
+}
+
+func NewTracingHandler(routePattern RoutePatternFunc) *TracingHandler {
+	return &TracingHandler{routePattern: routePattern}
+}
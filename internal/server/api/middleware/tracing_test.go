@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server"
+	"xrf197ilz35aq/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingHandler(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), internal.TracingConfig{})
+	assert.NoError(t, err)
+	defer func() { _ = shutdown(context.Background()) }()
+
+	const callerTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var outbound map[string]string
+	handler := NewTracingHandler(func(r *http.Request) string { return "GET /api/v1/accounts" }).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			outbound = server.PropagationHeaders(r.Context())
+		}),
+	)
+
+	t.Run("continues the caller's trace and forwards it upstream", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/accounts", nil)
+		r.Header.Set(internal.RequestIdHeader, "caller-req-1")
+		r.Header.Set(internal.TraceParentHeader, "00-"+callerTraceId+"-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		assert.Equal(t, "caller-req-1", outbound[internal.RequestIdHeader])
+		assert.Contains(t, outbound[internal.TraceParentHeader], callerTraceId)
+		assert.NotContains(t, outbound[internal.TraceParentHeader], "00f067aa0ba902b7")
+		assert.Contains(t, w.Header().Get(internal.TraceParentHeader), callerTraceId)
+	})
+
+	t.Run("starts a new trace and request id when none was sent", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/accounts", nil)
+		r.Header.Set(internal.RequestIdHeader, "not a valid id!")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.NotEmpty(t, outbound[internal.RequestIdHeader])
+		assert.NotEqual(t, "not a valid id!", outbound[internal.RequestIdHeader])
+		assert.True(t, strings.HasPrefix(outbound[internal.TraceParentHeader], "00-"))
+		assert.NotContains(t, outbound[internal.TraceParentHeader], callerTraceId)
+	})
+}
@@ -0,0 +1,390 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Struct tags understood by BindParams
+//
+//	query:"currency"            -> value(s) read from the URL query string
+//	path:"accountId"            -> value read from the route pattern wildcard (r.PathValue)
+//	default:"UTC"               -> used when the parameter is absent (comma separated for slices)
+//	validate:"required,uuid"    -> required, uuid, min=N, max=N, minLen=N, maxLen=N, oneof=a|b|c
+//
+// min and max bound the value of numeric fields (or of every element of a numeric slice), they cannot be set on
+// other fields. minLen and maxLen bound the length of every value as it appears in the request, whatever the
+// field type, so a string field holding digits is still checked by length.
+const (
+	queryTag    = "query"
+	pathTag     = "path"
+	defaultTag  = "default"
+	validateTag = "validate"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+
+	// bindings caches the *binding (or the error) of every type bound so far, the tags are parsed once per type.
+	bindings sync.Map
+)
+
+// paramField is a bindable field of a struct with its parsed tags.
+type paramField struct {
+	index int
+	field reflect.StructField
+	rules paramRules
+}
+
+type binding struct {
+	fields []paramField
+	err    error
+}
+
+// bindingOf parses and checks the tags of t once, an invalid tag (e.g. min on a string field) is a programming error
+// reported for every request until it is fixed, ParamsBinder reports it when it is created instead.
+func bindingOf(t reflect.Type) *binding {
+	if cached, ok := bindings.Load(t); ok {
+		return cached.(*binding)
+	}
+	b := &binding{}
+	b.fields, b.err = parseFields(t)
+	cached, _ := bindings.LoadOrStore(t, b)
+	return cached.(*binding)
+}
+
+func parseFields(t reflect.Type) ([]paramField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("BindParams destination must be a struct, got %s", t.Kind())
+	}
+	var fields []paramField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || (field.Tag.Get(pathTag) == "" && field.Tag.Get(queryTag) == "") {
+			continue
+		}
+		if !isSupported(field.Type) {
+			return nil, fmt.Errorf("%s.%s has the unsupported parameter type %s", t, field.Name, field.Type)
+		}
+		rules, err := parseRules(field.Tag.Get(validateTag))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t, field.Name, err)
+		}
+		if (rules.min != nil || rules.max != nil) && !isNumeric(field.Type) {
+			return nil, fmt.Errorf("%s.%s: min/max only apply to numeric fields, it is %s (use minLen/maxLen)", t, field.Name, field.Type)
+		}
+		fields = append(fields, paramField{index: i, field: field, rules: rules})
+	}
+	return fields, nil
+}
+
+// ParamsBinder binds the parameters of T, its tags are checked when the binder is created. Binders are meant to be
+// package variables built with MustParamsBinder, so that an invalid tag fails when the package is loaded, in tests too.
+type ParamsBinder[T any] struct {
+	binding *binding
+}
+
+func NewParamsBinder[T any]() (*ParamsBinder[T], error) {
+	b := bindingOf(reflect.TypeFor[T]())
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &ParamsBinder[T]{binding: b}, nil
+}
+
+// MustParamsBinder is NewParamsBinder panicking on invalid tags.
+func MustParamsBinder[T any]() *ParamsBinder[T] {
+	binder, err := NewParamsBinder[T]()
+	if err != nil {
+		panic("request: " + err.Error())
+	}
+	return binder
+}
+
+// Bind fills dst like BindParams.
+func (b *ParamsBinder[T]) Bind(r *http.Request, dst *T) error {
+	return bind(r, reflect.ValueOf(dst).Elem(), b.binding.fields)
+}
+
+// BindParams fills dst from the request query string and path values using the struct tags above.
+// Like DecodeJSONBody, every failure is returned as an *Err carrying the HTTP status to respond with, invalid tags
+// are a 500 (see ParamsBinder to catch them earlier).
+func BindParams[T any](r *http.Request, dst *T) error {
+	b := bindingOf(reflect.TypeFor[T]())
+	if b.err != nil {
+		return &Err{Status: http.StatusInternalServerError, Err: b.err, Msg: "Internal :: " + b.err.Error()}
+	}
+	return bind(r, reflect.ValueOf(dst).Elem(), b.fields)
+}
+
+func bind(r *http.Request, value reflect.Value, fields []paramField) error {
+	query := r.URL.Query()
+	for _, f := range fields {
+		name, source, raw := lookupParam(r, query, f.field)
+		if name == "" {
+			continue
+		}
+
+		if len(raw) == 0 {
+			if def, ok := f.field.Tag.Lookup(defaultTag); ok {
+				raw = splitDefault(def, f.field.Type)
+			}
+		}
+		if len(raw) == 0 {
+			if f.rules.required {
+				return &Err{Status: http.StatusBadRequest, Msg: fmt.Sprintf("Missing required %s parameter %q", source, name)}
+			}
+			continue
+		}
+
+		if err := setField(value.Field(f.index), raw); err != nil {
+			return &Err{
+				Status: http.StatusBadRequest,
+				Err:    err,
+				Msg:    fmt.Sprintf("Request contains an invalid value for the %q %s parameter", name, source),
+			}
+		}
+
+		if err := f.rules.check(raw); err != nil {
+			return &Err{
+				Status: http.StatusBadRequest,
+				Err:    err,
+				Msg:    fmt.Sprintf("Invalid %s parameter %q: %s", source, name, err.Error()),
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookupParam returns the parameter name, where it came from and its raw values.
+func lookupParam(r *http.Request, query map[string][]string, field reflect.StructField) (string, string, []string) {
+	if name, ok := field.Tag.Lookup(pathTag); ok && name != "" {
+		pathValue := r.PathValue(name)
+		if pathValue == "" {
+			return name, pathTag, nil
+		}
+		return name, pathTag, []string{pathValue}
+	}
+
+	if name, ok := field.Tag.Lookup(queryTag); ok && name != "" {
+		values := make([]string, 0, len(query[name]))
+		for _, v := range query[name] {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return name, queryTag, values
+	}
+
+	return "", "", nil
+}
+
+func splitDefault(def string, fieldType reflect.Type) []string {
+	if def == "" {
+		return nil
+	}
+	if fieldType.Kind() == reflect.Slice {
+		return strings.Split(def, ",")
+	}
+	return []string{def}
+}
+
+func setField(field reflect.Value, raw []string) error {
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(raw), len(raw))
+		for i, v := range raw {
+			if err := setScalar(slice.Index(i), v); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	if len(raw) > 1 {
+		return fmt.Errorf("expected a single value, got %d", len(raw))
+	}
+	return setScalar(field, raw[0])
+}
+
+func setScalar(field reflect.Value, raw string) error {
+	switch field.Type() {
+	case durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", field.Type())
+	}
+	return nil
+}
+
+// isSupported reports whether setField can set a field of fieldType.
+func isSupported(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == durationType || fieldType == timeType {
+		return true
+	}
+	switch fieldType.Kind() {
+	case reflect.String, reflect.Bool:
+		return true
+	}
+	return isNumeric(fieldType)
+}
+
+// isNumeric reports whether min/max apply to the values of fieldType, durations are not numbers in a request.
+func isNumeric(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == durationType {
+		return false
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+type paramRules struct {
+	required bool
+	uuid     bool
+	min      *float64
+	max      *float64
+	minLen   *int
+	maxLen   *int
+	oneOf    map[string]bool
+}
+
+func parseRules(tag string) (paramRules, error) {
+	rules := paramRules{}
+	if tag == "" {
+		return rules, nil
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		key, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch key {
+		case "required":
+			rules.required = true
+		case "uuid":
+			rules.uuid = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return rules, fmt.Errorf("%s must be a number, got %q", key, arg)
+			}
+			if key == "min" {
+				rules.min = &n
+			} else {
+				rules.max = &n
+			}
+		case "minLen", "maxLen":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return rules, fmt.Errorf("%s must be a length, got %q", key, arg)
+			}
+			if key == "minLen" {
+				rules.minLen = &n
+			} else {
+				rules.maxLen = &n
+			}
+		case "oneof":
+			rules.oneOf = make(map[string]bool)
+			for _, v := range strings.Split(arg, "|") {
+				rules.oneOf[v] = true
+			}
+		default:
+			return rules, fmt.Errorf("unknown validation rule %q", rule)
+		}
+	}
+	return rules, nil
+}
+
+// check applies the validation rules to every raw value, min/max are only accepted on numeric fields whose values
+// were already parsed.
+func (rules paramRules) check(raw []string) error {
+	for _, v := range raw {
+		if rules.uuid {
+			if _, err := uuid.Parse(v); err != nil {
+				return fmt.Errorf("%q is not a valid UUID", v)
+			}
+		}
+		if rules.oneOf != nil && !rules.oneOf[v] {
+			return fmt.Errorf("%q is not an accepted value", v)
+		}
+
+		if rules.minLen != nil && len(v) < *rules.minLen {
+			return fmt.Errorf("%q must be at least %d characters long", v, *rules.minLen)
+		}
+		if rules.maxLen != nil && len(v) > *rules.maxLen {
+			return fmt.Errorf("%q must be at most %d characters long", v, *rules.maxLen)
+		}
+		if rules.min == nil && rules.max == nil {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		if rules.min != nil && n < *rules.min {
+			return fmt.Errorf("%q must be at least %v", v, *rules.min)
+		}
+		if rules.max != nil && n > *rules.max {
+			return fmt.Errorf("%q must be at most %v", v, *rules.max)
+		}
+	}
+	return nil
+}
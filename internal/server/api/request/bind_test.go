@@ -0,0 +1,157 @@
+package request_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal/server/api/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bindTarget struct {
+	Currencies []string      `query:"currency"`
+	Type       string        `query:"type" default:"Normal" validate:"oneof=Normal|Escrow"`
+	Limit      int           `query:"limit" default:"20" validate:"min=1,max=100"`
+	Wallets    bool          `query:"wallets"`
+	Window     time.Duration `query:"window"`
+	Reference  string        `query:"reference" validate:"minLen=3,maxLen=5"`
+	AccountId  string        `path:"accountId" validate:"required,uuid"`
+}
+
+// bind routes the request through a ServeMux so that r.PathValue is populated like in the real server.
+func bind(t *testing.T, target string) (bindTarget, error) {
+	t.Helper()
+	var dst bindTarget
+	var bindErr error
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /accounts/{accountId}", func(w http.ResponseWriter, r *http.Request) {
+		bindErr = request.BindParams(r, &dst)
+	})
+	mux.HandleFunc("GET /accounts", func(w http.ResponseWriter, r *http.Request) {
+		bindErr = request.BindParams(r, &dst)
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	return dst, bindErr
+}
+
+func TestBindParams(t *testing.T) {
+	const accountId = "8a4e1a9c-54b1-4c36-9d6c-7f1c4e2f6b10"
+
+	t.Run("binds repeated values, types and defaults", func(t *testing.T) {
+		dst, err := bind(t, "/accounts/"+accountId+"?currency=USD&currency=BTC&wallets=true&window=5m")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"USD", "BTC"}, dst.Currencies)
+		assert.Equal(t, "Normal", dst.Type)
+		assert.Equal(t, 20, dst.Limit)
+		assert.True(t, dst.Wallets)
+		assert.Equal(t, 5*time.Minute, dst.Window)
+		assert.Equal(t, accountId, dst.AccountId)
+	})
+
+	t.Run("rejects values that do not match the field type", func(t *testing.T) {
+		_, err := bind(t, "/accounts/"+accountId+"?limit=ten")
+		assertBadRequest(t, err, `Request contains an invalid value for the "limit" query parameter`)
+	})
+
+	t.Run("rejects repeated values for a scalar field", func(t *testing.T) {
+		_, err := bind(t, "/accounts/"+accountId+"?type=Normal&type=Escrow")
+		assertBadRequest(t, err, `Request contains an invalid value for the "type" query parameter`)
+	})
+
+	t.Run("applies validation rules", func(t *testing.T) {
+		_, err := bind(t, "/accounts/"+accountId+"?limit=500")
+		assertBadRequest(t, err, `Invalid query parameter "limit": "500" must be at most 100`)
+
+		_, err = bind(t, "/accounts/"+accountId+"?type=Savings")
+		assertBadRequest(t, err, `Invalid query parameter "type": "Savings" is not an accepted value`)
+
+		_, err = bind(t, "/accounts/not-a-uuid")
+		assertBadRequest(t, err, `Invalid path parameter "accountId": "not-a-uuid" is not a valid UUID`)
+	})
+
+	t.Run("minLen and maxLen check the length, also of digits", func(t *testing.T) {
+		dst, err := bind(t, "/accounts/"+accountId+"?reference=007")
+		assert.NoError(t, err)
+		assert.Equal(t, "007", dst.Reference)
+
+		_, err = bind(t, "/accounts/"+accountId+"?reference=12")
+		assertBadRequest(t, err, `Invalid query parameter "reference": "12" must be at least 3 characters long`)
+
+		_, err = bind(t, "/accounts/"+accountId+"?reference=123456")
+		assertBadRequest(t, err, `Invalid query parameter "reference": "123456" must be at most 5 characters long`)
+	})
+
+	t.Run("min and max check the value of numbers", func(t *testing.T) {
+		dst, err := bind(t, "/accounts/"+accountId+"?limit=100")
+		assert.NoError(t, err)
+		assert.Equal(t, 100, dst.Limit)
+
+		_, err = bind(t, "/accounts/"+accountId+"?limit=0")
+		assertBadRequest(t, err, `Invalid query parameter "limit": "0" must be at least 1`)
+	})
+
+	t.Run("min and max are refused on non numeric fields", func(t *testing.T) {
+		var dst struct {
+			Code string `query:"code" validate:"min=2"`
+		}
+		r := httptest.NewRequest(http.MethodGet, "/accounts?code=12345", nil)
+		err := request.BindParams(r, &dst)
+
+		var reqErr *request.Err
+		if assert.True(t, errors.As(err, &reqErr)) {
+			assert.Equal(t, http.StatusInternalServerError, reqErr.Status)
+		}
+	})
+
+	t.Run("the binder reports invalid tags when it is created", func(t *testing.T) {
+		type numberAsString struct {
+			Code string `query:"code" validate:"min=2"`
+		}
+		type badLength struct {
+			Code string `query:"code" validate:"maxLen=five"`
+		}
+		type unknownRule struct {
+			Code string `query:"code" validate:"required,email"`
+		}
+		type unsupportedType struct {
+			Codes map[string]string `query:"codes"`
+		}
+
+		_, err := request.NewParamsBinder[numberAsString]()
+		assert.ErrorContains(t, err, "min/max only apply to numeric fields")
+		_, err = request.NewParamsBinder[badLength]()
+		assert.ErrorContains(t, err, `maxLen must be a length, got "five"`)
+		_, err = request.NewParamsBinder[unknownRule]()
+		assert.ErrorContains(t, err, `unknown validation rule "email"`)
+		_, err = request.NewParamsBinder[unsupportedType]()
+		assert.ErrorContains(t, err, "unsupported parameter type")
+		assert.Panics(t, func() { request.MustParamsBinder[numberAsString]() })
+
+		type page struct {
+			Limit int `query:"limit" validate:"min=1,max=100"`
+		}
+		binder, err := request.NewParamsBinder[page]()
+		assert.NoError(t, err)
+		var dst page
+		assert.NoError(t, binder.Bind(httptest.NewRequest(http.MethodGet, "/accounts?limit=5", nil), &dst))
+		assert.Equal(t, 5, dst.Limit)
+	})
+
+	t.Run("reports missing required parameters", func(t *testing.T) {
+		_, err := bind(t, "/accounts?currency=USD")
+		assertBadRequest(t, err, `Missing required path parameter "accountId"`)
+	})
+}
+
+func assertBadRequest(t *testing.T, err error, msg string) {
+	t.Helper()
+	var reqErr *request.Err
+	if assert.True(t, errors.As(err, &reqErr), "expected *request.Err, got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, reqErr.Status)
+		assert.Equal(t, msg, reqErr.Msg)
+	}
+}
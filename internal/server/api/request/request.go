@@ -1,6 +1,7 @@
 package request
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,11 @@ import (
 	"xrf197ilz35aq/internal"
 )
 
+// DefaultMaxBodyBytes is the request body limit used when neither the server nor the route configured one.
+const DefaultMaxBodyBytes int64 = 1 << 20 // 1MB
+
+type bodyLimitKey struct{}
+
 type Err struct {
 	Status int
 	Err    error
@@ -30,6 +36,9 @@ func DecodeJSONBody[T any](r *http.Request, dst *T) error {
 		}
 	}
 
+	// MaxBytesReader makes the decoder fail with *http.MaxBytesError once the limit is crossed.
+	r.Body = http.MaxBytesReader(nil, r.Body, BodyLimitFromContext(r.Context()))
+
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 
@@ -41,9 +50,51 @@ func DecodeJSONBody[T any](r *http.Request, dst *T) error {
 	return nil
 }
 
+// ContextWithBodyLimit returns a new context with the maximum number of bytes DecodeJSONBody will read.
+func ContextWithBodyLimit(ctx context.Context, maxBytes int64) context.Context {
+	if maxBytes <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bodyLimitKey{}, maxBytes)
+}
+
+// BodyLimitFromContext returns the body limit set for the request, or DefaultMaxBodyBytes if none was set.
+func BodyLimitFromContext(ctx context.Context) int64 {
+	if maxBytes, ok := ctx.Value(bodyLimitKey{}).(int64); ok && maxBytes > 0 {
+		return maxBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// WithBodyLimit overrides the server wide body limit for a single route, used while registering routes
+// e.g. serveMux.Handle("POST /api/v1/asset", request.WithBodyLimit(4<<20, ah.createAsset)).
+// Middleware reading the body before the route is served looks the limit up with RouteBodyLimit.
+func WithBodyLimit(maxBytes int64, next http.HandlerFunc) http.Handler {
+	return bodyLimitedRoute{maxBytes: maxBytes, next: next}
+}
+
+// RouteBodyLimit returns the body limit of a route handler created with WithBodyLimit.
+func RouteBodyLimit(handler http.Handler) (int64, bool) {
+	route, ok := handler.(bodyLimitedRoute)
+	if !ok || route.maxBytes <= 0 {
+		return 0, false
+	}
+	return route.maxBytes, true
+}
+
+type bodyLimitedRoute struct {
+	maxBytes int64
+	next     http.HandlerFunc
+}
+
+func (br bodyLimitedRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	br.next(w, r.WithContext(ContextWithBodyLimit(r.Context(), br.maxBytes)))
+}
+
 func parseError(err error) *Err {
 	var syntaxError *json.SyntaxError
 	var maxBytesError *http.MaxBytesError
+	var externalError *internal.ExternalError
 	var unmarshalTypeError *json.UnmarshalTypeError
 	var invalidUnmarshalError *json.InvalidUnmarshalError
 
@@ -62,9 +113,14 @@ func parseError(err error) *Err {
 	// Catching error types like trying to assign a string in the
 	// JSON request body to an int field.
 	// Interpolate the relevant field name and position into the error message
-	case errors.Is(err, unmarshalTypeError):
-		msg := fmt.Sprintf("Request contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-		return &Err{Status: http.StatusBadRequest, Msg: msg}
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field == "" {
+			msg := fmt.Sprintf("Request body must be a JSON %s, got %s", jsonKind(unmarshalTypeError.Type.Kind().String()), unmarshalTypeError.Value)
+			return &Err{Status: http.StatusBadRequest, Err: err, Msg: msg}
+		}
+		msg := fmt.Sprintf("Request contains an invalid value for the %q field (at position %d): expected %s, got %s",
+			unmarshalTypeError.Field, unmarshalTypeError.Offset, jsonKind(unmarshalTypeError.Type.Kind().String()), unmarshalTypeError.Value)
+		return &Err{Status: http.StatusBadRequest, Err: err, Msg: msg}
 
 	// Catch the error caused by extra unexpected fields in the request
 	// body. https://github.com/golang/go/issues/29035 regarding turning this into a sentinel error.
@@ -79,9 +135,17 @@ func parseError(err error) *Err {
 		return &Err{Status: http.StatusBadRequest, Msg: msg}
 
 	// Catch any error caused by the request body being too large.
-	case errors.Is(err, maxBytesError):
-		msg := "Request body must not be larger than 1MB"
-		return &Err{Status: http.StatusBadRequest, Msg: msg}
+	case errors.As(err, &maxBytesError):
+		msg := fmt.Sprintf("Request body must not be larger than %s", formatBytes(maxBytesError.Limit))
+		return &Err{Status: http.StatusRequestEntityTooLarge, Msg: msg}
+
+	// Validation errors raised by the model's own UnmarshalJSON (e.g. model.UserRequest).
+	case errors.As(err, &externalError):
+		status := externalError.Code
+		if status < 400 || status >= 500 {
+			status = http.StatusBadRequest
+		}
+		return &Err{Status: status, Msg: externalError.Message}
 
 	case errors.As(err, &invalidUnmarshalError):
 		msg := "Request body must contain a valid JSON pointer"
@@ -94,3 +158,30 @@ func parseError(err error) *Err {
 		}
 	}
 }
+
+// jsonKind converts a Go kind into the JSON type a client would have to send.
+func jsonKind(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "array"
+	case kind == "struct", kind == "map":
+		return "object"
+	default:
+		return kind
+	}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
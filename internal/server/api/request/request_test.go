@@ -0,0 +1,157 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"xrf197ilz35aq/internal"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type decodeTarget struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func decodeErr(t *testing.T, body string) error {
+	t.Helper()
+	var dst decodeTarget
+	return json.NewDecoder(strings.NewReader(body)).Decode(&dst)
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		msg    string
+	}{
+		{
+			name:   "syntax error",
+			err:    &json.SyntaxError{Offset: 7},
+			status: http.StatusBadRequest,
+			msg:    "Request contains badly-formed JSON (at position 7)",
+		},
+		{
+			name:   "unexpected EOF",
+			err:    io.ErrUnexpectedEOF,
+			status: http.StatusBadRequest,
+			msg:    "Request contains badly-formed JSON",
+		},
+		{
+			name:   "field type mismatch",
+			err:    decodeErr(t, `{"count": "ten"}`),
+			status: http.StatusBadRequest,
+			msg:    `Request contains an invalid value for the "count" field (at position 15): expected number, got string`,
+		},
+		{
+			name:   "body type mismatch",
+			err:    decodeErr(t, `[1, 2]`),
+			status: http.StatusBadRequest,
+			msg:    "Request body must be a JSON object, got array",
+		},
+		{
+			name:   "unknown field",
+			err:    errors.New(`json: unknown field "nickname"`),
+			status: http.StatusBadRequest,
+			msg:    `Request body contains unknown field "nickname"`,
+		},
+		{
+			name:   "empty body",
+			err:    io.EOF,
+			status: http.StatusBadRequest,
+			msg:    "Request body must not be empty",
+		},
+		{
+			name:   "body too large",
+			err:    fmt.Errorf("read: %w", &http.MaxBytesError{Limit: 4 << 10}),
+			status: http.StatusRequestEntityTooLarge,
+			msg:    "Request body must not be larger than 4KB",
+		},
+		{
+			name:   "validation error from UnmarshalJSON",
+			err:    &internal.ExternalError{Message: "Invalid email address", Code: http.StatusBadRequest},
+			status: http.StatusBadRequest,
+			msg:    "Invalid email address",
+		},
+		{
+			name:   "invalid unmarshal target",
+			err:    &json.InvalidUnmarshalError{},
+			status: http.StatusBadRequest,
+			msg:    "Request body must contain a valid JSON pointer",
+		},
+		{
+			name:   "anything else",
+			err:    errors.New("connection reset"),
+			status: http.StatusInternalServerError,
+			msg:    "Internal :: Err=connection reset",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			parsed := parseError(tc.err)
+			assert.Equal(t, tc.status, parsed.Status)
+			assert.Equal(t, tc.msg, parsed.Msg)
+		})
+	}
+}
+
+func TestDecodeJSONBody(t *testing.T) {
+	newRequest := func(body, contentType string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set(internal.ContentType, contentType)
+		return r
+	}
+
+	t.Run("decodes a valid body", func(t *testing.T) {
+		var dst decodeTarget
+		err := DecodeJSONBody(newRequest(`{"name": "xrf", "count": 2}`, internal.ApplicationJson), &dst)
+		assert.NoError(t, err)
+		assert.Equal(t, decodeTarget{Name: "xrf", Count: 2}, dst)
+	})
+
+	t.Run("rejects unsupported content types", func(t *testing.T) {
+		var dst decodeTarget
+		err := DecodeJSONBody(newRequest(`name=xrf`, "application/x-www-form-urlencoded"), &dst)
+		assertStatus(t, err, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("enforces the body limit from the context", func(t *testing.T) {
+		var dst decodeTarget
+		r := newRequest(fmt.Sprintf(`{"name": %q}`, strings.Repeat("x", 64)), internal.ApplicationJson)
+		r = r.WithContext(ContextWithBodyLimit(context.Background(), 32))
+		err := DecodeJSONBody(r, &dst)
+		assertStatus(t, err, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("route limit overrides the server limit", func(t *testing.T) {
+		var decodeErr error
+		handler := WithBodyLimit(16, func(w http.ResponseWriter, r *http.Request) {
+			var dst decodeTarget
+			decodeErr = DecodeJSONBody(r, &dst)
+		})
+		r := newRequest(`{"name": "more than sixteen bytes"}`, internal.ApplicationJson)
+		handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(ContextWithBodyLimit(r.Context(), DefaultMaxBodyBytes)))
+		assertStatus(t, decodeErr, http.StatusRequestEntityTooLarge)
+
+		maxBytes, ok := RouteBodyLimit(handler)
+		assert.True(t, ok)
+		assert.Equal(t, int64(16), maxBytes)
+	})
+}
+
+func assertStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var reqErr *Err
+	if assert.True(t, errors.As(err, &reqErr), "expected *Err, got %v", err) {
+		assert.Equal(t, status, reqErr.Status)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net/http"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/server/api/request"
 )
 
 type DataResponse struct {
@@ -57,8 +58,14 @@ func WriteErrorResponse(errObj error, w http.ResponseWriter, logger slog.Logger)
 	var externalError *internal.ExternalError
 	var apiClientError *internal.APIClientError
 	var serverError *internal.ServerError
+	var requestErr *request.Err
 
 	switch {
+	case errors.As(errObj, &requestErr):
+		if requestErr.Status >= 400 {
+			statusCode = requestErr.Status
+		}
+		msg = requestErr.Msg
 	case errors.As(errObj, &externalError):
 		if externalError.Code >= 400 {
 			statusCode = externalError.Code
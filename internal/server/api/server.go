@@ -1,23 +1,48 @@
 package api
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
 	"time"
 	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/audit"
+	"xrf197ilz35aq/internal/health"
+	"xrf197ilz35aq/internal/idempotency"
+	"xrf197ilz35aq/internal/metrics"
 	"xrf197ilz35aq/internal/processor"
+	"xrf197ilz35aq/internal/ratelimit"
 	"xrf197ilz35aq/internal/server/api/handlers"
 	"xrf197ilz35aq/internal/server/api/middleware"
 )
 
-func CreateServer(logger *slog.Logger, appConfig internal.AppConfig, processors *processor.Processors) *http.Server {
+// Dependencies are the shared components used by the middleware chain, they are created (and closed) by main.
+type Dependencies struct {
+	RateLimiter      ratelimit.Limiter
+	IdempotencyStore idempotency.Store
+	HealthMonitor    *health.Monitor
+	// Auditor records rejected auth tokens, nil disables it.
+	Auditor *audit.Auditor
+	// ConfigWatcher, when set, pushes reloaded rate limits and public routes to the middleware.
+	ConfigWatcher *internal.ConfigWatcher
+	// TLSConfig, when set, terminates TLS with the certificates of AppConfig.TLS (see certs.Reloader.ServerTLSConfig).
+	TLSConfig *tls.Config
+}
+
+func CreateServer(logger *slog.Logger, config *internal.Config, processors *processor.Processors, deps Dependencies) (*http.Server, error) {
+	appConfig := config.Application
+
 	serverMux := http.NewServeMux()
 
 	reqHandlers := make([]handlers.RequestHandler, 0)
 
 	// create request (routes) handlers
-	healthReqHandler := handlers.NewReqHealthHandlers(*logger)
+	healthReqHandler := handlers.NewReqHealthHandlers(*logger, deps.HealthMonitor)
 	authReqHandler := handlers.NewAuthHandler(*logger, processors.AuthProcessor)
 	assetReqHandler := handlers.NewAssetHandler(*logger, processors.AssetProcessor)
 	userReqHandler := handlers.NewUserReqHandler(*logger, processors.UserProcessor)
@@ -37,19 +62,185 @@ func CreateServer(logger *slog.Logger, appConfig internal.AppConfig, processors
 	}
 
 	// middlewares
-	loggerMiddleware := middleware.NewLoggerHandler(logger)
-	authMiddleware := middleware.NewAuthenticationMiddleware(*logger, processors.AuthProcessor)
+	routePattern := func(r *http.Request) string {
+		_, pattern := serverMux.Handler(r)
+		return pattern
+	}
+	routeHandler := func(r *http.Request) http.Handler {
+		handler, _ := serverMux.Handler(r)
+		return handler
+	}
+	recoveryMiddleware := middleware.NewRecoveryHandler(logger)
+	recoveryMiddleware.OnPanic(func(*http.Request) { metrics.IncPanics() })
+	clientIPMiddleware := middleware.NewClientIPHandler(logger, appConfig)
+	metricsMiddleware := middleware.NewMetricsHandler(routePattern)
+	tracingMiddleware := middleware.NewTracingHandler(routePattern)
+	loggerMiddleware := middleware.NewLoggerHandler(logger, config.Log)
+	timeoutMiddleware := middleware.NewTimeoutHandler(*logger, appConfig)
+	timeoutMiddleware.OnPanic(func(*http.Request) { metrics.IncPanics() })
+	bodyLimitMiddleware := middleware.NewBodyLimitHandler(appConfig.MaxBodyBytes, routeHandler)
+	authMiddleware := middleware.NewAuthenticationMiddleware(*logger, processors.AuthProcessor, config.Auth, deps.Auditor)
+	idempotencyMiddleware := middleware.NewIdempotencyHandler(*logger, deps.IdempotencyStore, config.Idempotency)
 
-	// wrap middlewares around the server
-	handler := loggerMiddleware.Handler(
-		authMiddleware.Handler(serverMux),
+	// wrap middlewares around the server, requests are limited per client IP (rateLimit.ip) before their token is
+	// checked and per user once they are authenticated
+	var handler http.Handler = idempotencyMiddleware.Handler(serverMux)
+	if config.RateLimit.Enabled && deps.RateLimiter != nil {
+		ipRateLimitMiddleware := middleware.NewRateLimitHandler(*logger, deps.RateLimiter, config.RateLimit, middleware.RateLimitByIP)
+		userRateLimitMiddleware := middleware.NewRateLimitHandler(*logger, deps.RateLimiter, config.RateLimit, middleware.RateLimitByUser)
+		handler = ipRateLimitMiddleware.Handler(authMiddleware.Handler(userRateLimitMiddleware.Handler(handler)))
+		if deps.ConfigWatcher != nil {
+			deps.ConfigWatcher.Subscribe(func(change internal.ConfigChange) {
+				if change.Has("rateLimit") {
+					ipRateLimitMiddleware.Update(change.Current.RateLimit)
+					userRateLimitMiddleware.Update(change.Current.RateLimit)
+				}
+			})
+		}
+	} else {
+		handler = authMiddleware.Handler(handler)
+	}
+	if deps.ConfigWatcher != nil {
+		deps.ConfigWatcher.Subscribe(func(change internal.ConfigChange) {
+			if change.Has("auth.publicRoutes") {
+				authMiddleware.UpdatePublicRoutes(change.Current.Auth.PublicRoutes)
+			}
+			if change.Has("application.requestTimeout") || change.Has("application.routeTimeouts") {
+				timeoutMiddleware.Update(change.Current.Application)
+			}
+		})
+	}
+	handler = recoveryMiddleware.Handler(
+		clientIPMiddleware.Handler(
+			metricsMiddleware.Handler(
+				tracingMiddleware.Handler(
+					loggerMiddleware.Handler(
+						timeoutMiddleware.Handler(
+							bodyLimitMiddleware.Handler(handler),
+						),
+					),
+				),
+			),
+		),
 	)
 
-	return &http.Server{
+	server := &http.Server{
 		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 16 * time.Minute,
-		IdleTimeout:  16 * time.Minute,
+		ReadTimeout:  durationOr(appConfig.ReadTimeout, 10*time.Second),
+		WriteTimeout: durationOr(appConfig.WriteTimeout, 16*time.Minute),
+		IdleTimeout:  durationOr(appConfig.IdleTimeout, 16*time.Minute),
 		Addr:         fmt.Sprintf(":%d", appConfig.Port),
+		Protocols:    new(http.Protocols),
+	}
+	server.Protocols.SetHTTP1(true)
+	// Shutdown waits for the requests in flight, streams are told to end since they would not on their own
+	server.RegisterOnShutdown(timeoutMiddleware.EndStreams)
+	if deps.TLSConfig != nil {
+		tlsConfig, err := serverTLSConfig(deps.TLSConfig, appConfig.TLS)
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = tlsConfig
+		server.Protocols.SetHTTP2(true)
+	} else if appConfig.H2C {
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return server, nil
+}
+
+// serverTLSConfig applies the versions, cipher suites and ALPN protocols of config to a copy of base, and to the
+// config base picks per handshake (see certs.Reloader.ServerTLSConfig), base is left as it is.
+func serverTLSConfig(base *tls.Config, config internal.ServerTLSConfig) (*tls.Config, error) {
+	minVersion, err := config.TLSMinVersion()
+	if err != nil {
+		return nil, fmt.Errorf("invalid application.tls.minVersion: %w", err)
+	}
+	cipherSuites, err := config.CipherSuiteIds()
+	if err != nil {
+		return nil, fmt.Errorf("invalid application.tls.cipherSuites: %w", err)
+	}
+	apply := func(tlsConfig *tls.Config) {
+		tlsConfig.MinVersion = minVersion
+		tlsConfig.CipherSuites = cipherSuites
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	tlsConfig := base.Clone()
+	apply(tlsConfig)
+	if getConfigForClient := base.GetConfigForClient; getConfigForClient != nil {
+		tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			handshakeConfig, err := getConfigForClient(hello)
+			if err != nil || handshakeConfig == nil {
+				return handshakeConfig, err
+			}
+			handshakeConfig = handshakeConfig.Clone()
+			apply(handshakeConfig)
+			return handshakeConfig, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// durationOr returns fallback for the timeouts left unset in the configuration.
+func durationOr(timeout, fallback time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return fallback
+}
+
+// CreateRedirectServer creates the server redirecting plain HTTP requests on AppConfig.TLS.RedirectPort to
+// AppConfig.TLS.PublicHost with the same path and query. The Host header of the request is not used, a client
+// could otherwise have the redirect point anywhere.
+func CreateRedirectServer(appConfig internal.AppConfig) *http.Server {
+	host := appConfig.TLS.PublicHost
+	if _, _, err := net.SplitHostPort(host); err != nil && appConfig.Port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(appConfig.Port))
+	}
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+
+	return &http.Server{
+		Handler:      redirect,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  30 * time.Second,
+		Addr:         fmt.Sprintf(":%d", appConfig.TLS.RedirectPort),
+	}
+}
+
+// AdminDependencies are the components the admin server reports on, they are created by main.
+type AdminDependencies struct {
+	HealthMonitor *health.Monitor
+	Connections   metrics.ConnectionStateSource
+	// TLSConfig, when set, is used to serve the admin endpoints over TLS (see AdminConfig.TLS).
+	TLSConfig *tls.Config
+}
+
+// CreateAdminServer creates the server for operational endpoints (metrics, pprof, effective configuration, log levels,
+// readiness detail and connection states), it listens on its own port so that these endpoints are never reachable
+// through the public listener, nor go through its middleware.
+func CreateAdminServer(logger *slog.Logger, configWatcher *internal.ConfigWatcher, deps AdminDependencies) *http.Server {
+	config := configWatcher.Current()
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /metrics", metrics.Handler())
+	adminMux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	adminMux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	adminMux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	adminMux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	adminMux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	handlers.NewReqConfigHandlers(*logger, configWatcher.Current).RegisterRoutes(adminMux)
+	handlers.NewReqLogLevelHandlers(*logger).RegisterRoutes(adminMux)
+	handlers.NewReqStatusHandlers(*logger, deps.HealthMonitor, deps.Connections).RegisterRoutes(adminMux)
+
+	adminTokenMiddleware := middleware.NewAdminTokenHandler(*logger, config.Admin.Token)
+
+	return &http.Server{
+		Handler:     adminTokenMiddleware.Handler(adminMux),
+		ReadTimeout: 10 * time.Second,
+		Addr:        fmt.Sprintf(":%d", config.Application.AdminPort),
+		TLSConfig:   deps.TLSConfig,
 	}
 }
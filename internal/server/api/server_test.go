@@ -0,0 +1,264 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xrf197ilz35aq/internal"
+	"xrf197ilz35aq/internal/certs"
+	"xrf197ilz35aq/internal/certs/certtest"
+	"xrf197ilz35aq/internal/client"
+	"xrf197ilz35aq/internal/processor"
+	"xrf197ilz35aq/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type connectionStates map[string]string
+
+func (c connectionStates) ConnectionStates() map[string]string {
+	return c
+}
+
+func TestCreateAdminServer(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	config := &internal.Config{Admin: internal.AdminConfig{Token: "admin-secret"}}
+	watcher := internal.NewConfigWatcher(internal.DevelopEnv, config, logger)
+	server := CreateAdminServer(logger, watcher, AdminDependencies{Connections: connectionStates{"localhost:50053": "READY"}})
+
+	serve := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/metrics", "/debug/pprof/", "/config", "/loglevel", "/readyz", "/connections"} {
+		t.Run(path, func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, serve(path, "").Code, "the admin token is required")
+			assert.Equal(t, http.StatusUnauthorized, serve(path, "wrong").Code)
+			assert.Equal(t, http.StatusOK, serve(path, "admin-secret").Code)
+		})
+	}
+
+	assert.JSONEq(t, `{"code":200,"data":{"localhost:50053":"READY"}}`, serve("/connections", "admin-secret").Body.String())
+}
+
+// startServer serves server on a random local port until the end of the test and returns its address.
+func startServer(t *testing.T, server *http.Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		if server.TLSConfig != nil {
+			_ = server.ServeTLS(listener, "", "")
+		} else {
+			_ = server.Serve(listener)
+		}
+	}()
+	t.Cleanup(func() { _ = server.Close() })
+	return listener.Addr().String()
+}
+
+func TestCreateServer_Protocols(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	config := &internal.Config{Application: internal.AppConfig{Port: 8443}}
+
+	t.Run("HTTP/2 is negotiated over TLS", func(t *testing.T) {
+		ca := certtest.NewCA(t, "server-ca")
+		pair := ca.Server(t, "localhost")
+		dir := t.TempDir()
+		reloader, err := certs.NewReloader(internal.TLSConfig{
+			CertFile: certtest.WriteFile(t, dir, "server.crt", pair.CertPEM),
+			KeyFile:  certtest.WriteFile(t, dir, "server.key", pair.KeyPEM),
+		}, logger)
+		require.NoError(t, err)
+		tlsConfig := *config
+		tlsConfig.Application.TLS = internal.ServerTLSConfig{MinVersion: "1.3"}
+		baseTLSConfig := reloader.ServerTLSConfig()
+		server, err := CreateServer(logger, &tlsConfig, &processor.Processors{}, Dependencies{TLSConfig: baseTLSConfig})
+		require.NoError(t, err)
+		assert.Equal(t, uint16(tls.VersionTLS12), baseTLSConfig.MinVersion, "the config of the caller is not changed")
+		assert.Empty(t, baseTLSConfig.NextProtos)
+		addr := startServer(t, server)
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get("https://" + addr + "/livez")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+
+		_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: ca.Pool(), ServerName: "localhost", MaxVersion: tls.VersionTLS12,
+		}}}).Get("https://" + addr + "/livez")
+		assert.Error(t, err, "TLS 1.2 is below the minimum version")
+	})
+
+	t.Run("an invalid TLS setting is an error", func(t *testing.T) {
+		tlsConfig := *config
+		tlsConfig.Application.TLS = internal.ServerTLSConfig{MinVersion: "1.1"}
+		_, err := CreateServer(logger, &tlsConfig, &processor.Processors{}, Dependencies{TLSConfig: &tls.Config{}})
+		assert.ErrorContains(t, err, "invalid application.tls.minVersion")
+	})
+
+	t.Run("h2c serves HTTP/2 without TLS", func(t *testing.T) {
+		h2cConfig := *config
+		h2cConfig.Application.H2C = true
+		server, err := CreateServer(logger, &h2cConfig, &processor.Processors{}, Dependencies{})
+		require.NoError(t, err)
+		addr := startServer(t, server)
+
+		transport := &http.Transport{Protocols: new(http.Protocols)}
+		transport.Protocols.SetUnencryptedHTTP2(true)
+		resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/livez")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, 2, resp.ProtoMajor)
+
+		resp, err = http.Get("http://" + addr + "/livez")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, 1, resp.ProtoMajor, "HTTP/1.1 is still served")
+	})
+}
+
+func TestCreateRedirectServer(t *testing.T) {
+	redirect := func(appConfig internal.AppConfig, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		CreateRedirectServer(appConfig).Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, nil))
+		return w
+	}
+
+	w := redirect(internal.AppConfig{Port: 8443, TLS: internal.ServerTLSConfig{PublicHost: "api.example.com"}},
+		"http://evil.example.org:8080/api/v1/accounts?type=savings")
+	assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+	assert.Equal(t, "https://api.example.com:8443/api/v1/accounts?type=savings", w.Header().Get("Location"), "the Host header is not used")
+
+	w = redirect(internal.AppConfig{Port: 8443, TLS: internal.ServerTLSConfig{PublicHost: "api.example.com"}}, "/health")
+	assert.Equal(t, "https://api.example.com:8443/health", w.Header().Get("Location"))
+
+	w = redirect(internal.AppConfig{Port: 8443, TLS: internal.ServerTLSConfig{PublicHost: "api.example.com:443"}}, "/health")
+	assert.Equal(t, "https://api.example.com:443/health", w.Header().Get("Location"), "the port of the public host is kept")
+
+	w = redirect(internal.AppConfig{Port: 443, TLS: internal.ServerTLSConfig{PublicHost: "api.example.com"}}, "/health")
+	assert.Equal(t, "https://api.example.com/health", w.Header().Get("Location"))
+}
+
+// slowAccountProcessor locks accounts once released, whatever the deadline of the request.
+type slowAccountProcessor struct {
+	processor.AccountProcessor
+	release chan struct{}
+}
+
+func (p *slowAccountProcessor) LockAccount(context.Context, string) (bool, error) {
+	<-p.release
+	return true, nil
+}
+
+func (p *slowAccountProcessor) UnlockAccount(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+func TestCreateServer_RequestTimeout(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	authUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"code":200,"data":{"userId":"u1","fingerprint":"fp-1"}}`))
+	}))
+	defer authUpstream.Close()
+	accountProcessor := &slowAccountProcessor{release: make(chan struct{})}
+	t.Cleanup(func() { close(accountProcessor.release) })
+
+	config := &internal.Config{Application: internal.AppConfig{Port: 8008, RequestTimeout: 50 * time.Millisecond}}
+	processors := &processor.Processors{
+		AuthProcessor:    *processor.NewAuthProcessor(*client.NewApiClient(authUpstream.URL, internal.AppConfig{}), config.Auth, nil),
+		AccountProcessor: accountProcessor,
+	}
+	server, err := CreateServer(logger, config, processors, Dependencies{})
+	require.NoError(t, err)
+	addr := startServer(t, server)
+
+	patch := func(path string) *http.Response {
+		req, err := http.NewRequest(http.MethodPatch, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		req.Header.Set(internal.XrfAuthToken, "token-1")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	start := time.Now()
+	resp := patch("/api/v1/accounts/acct-1/lock")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"request timed out","code":504}`, string(body))
+	assert.Less(t, time.Since(start), time.Second, "the client does not wait for the handler")
+
+	resp = patch("/api/v1/accounts/acct-1/unlock")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"code":200,"data":true}`, string(body))
+}
+
+func TestCreateServer_RateLimitsPerUser(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	authUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var verification struct {
+			Token string `json:"token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&verification)
+		_, _ = fmt.Fprintf(w, `{"code":200,"data":{"userId":"u1","fingerprint":"fp-%s"}}`, verification.Token)
+	}))
+	defer authUpstream.Close()
+
+	config := &internal.Config{Application: internal.AppConfig{Port: 8008}}
+	config.RateLimit = internal.RateLimitConfig{
+		Enabled:        true,
+		RateLimitRules: internal.RateLimitRules{Default: internal.RateLimitRule{RequestsPerSecond: 0.01, Burst: 2}},
+		IP:             internal.RateLimitRules{Default: internal.RateLimitRule{RequestsPerSecond: 0.01, Burst: 10}},
+	}
+	processors := &processor.Processors{
+		AuthProcessor:    *processor.NewAuthProcessor(*client.NewApiClient(authUpstream.URL, internal.AppConfig{}), config.Auth, nil),
+		AccountProcessor: &slowAccountProcessor{},
+	}
+	server, err := CreateServer(logger, config, processors, Dependencies{RateLimiter: ratelimit.NewMemoryLimiter()})
+	require.NoError(t, err)
+	addr := startServer(t, server)
+
+	unlock := func(token string) int {
+		req, err := http.NewRequest(http.MethodPatch, "http://"+addr+"/api/v1/accounts/acct-1/unlock", nil)
+		require.NoError(t, err)
+		req.Header.Set(internal.XrfAuthToken, token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// both users come from 127.0.0.1
+	assert.Equal(t, http.StatusOK, unlock("a"))
+	assert.Equal(t, http.StatusOK, unlock("a"))
+	assert.Equal(t, http.StatusTooManyRequests, unlock("a"))
+	assert.Equal(t, http.StatusOK, unlock("b"), "users behind the same IP do not share a bucket")
+	assert.Equal(t, http.StatusOK, unlock("b"))
+	assert.Equal(t, http.StatusTooManyRequests, unlock("b"))
+}
@@ -3,13 +3,18 @@ package server
 import (
 	"context"
 	"log/slog"
+	"xrf197ilz35aq/internal"
 	"xrf197ilz35aq/internal/model"
+	"xrf197ilz35aq/internal/tracing"
 )
 
 // ContextKey is a custom type to avoid key collisions in the context map.
 type ContextKey string
 
 const LoggerContextKey = ContextKey("logger")
+const RequestIdContextKey = ContextKey("request-id")
+const ServiceTokenContextKey = ContextKey("service-token")
+const ClientIPContextKey = ContextKey("client-ip")
 
 // LoggerFromContext is a helper function to retrieve the logger from the context.
 // It ensures type safety and returns a default logger if none is found.
@@ -22,6 +27,50 @@ func LoggerFromContext(ctx context.Context, defaultLogger slog.Logger) *slog.Log
 	return &defaultLogger
 }
 
+// ContextWithRequestId returns a new context with the given request id.
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, RequestIdContextKey, requestId)
+}
+
+// RequestIdFromContext retrieves the request id from the context, it returns an empty string if none was set.
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(RequestIdContextKey).(string)
+	return requestId
+}
+
+// ContextWithClientIP returns a new context with the address of the client that sent the request.
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ClientIPContextKey, ip)
+}
+
+// ClientIPFromContext retrieves the client address from the context, it returns an empty string if none was set.
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ClientIPContextKey).(string)
+	return ip
+}
+
+// ContextWithServiceToken overrides the service-to-service token sent on upstream calls made with ctx.
+func ContextWithServiceToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ServiceTokenContextKey, token)
+}
+
+// ServiceTokenFromContext retrieves the service-to-service token from the context, it returns an empty string if none was set.
+func ServiceTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(ServiceTokenContextKey).(string)
+	return token
+}
+
+// PropagationHeaders returns the request id and W3C trace context headers to send on an outbound (HTTP or gRPC) call.
+// The traceparent refers to the span active in ctx, i.e. the client span of the outbound call when there is one.
+func PropagationHeaders(ctx context.Context) map[string]string {
+	headers := make(map[string]string, 3)
+	if requestId := RequestIdFromContext(ctx); requestId != "" {
+		headers[internal.RequestIdHeader] = requestId
+	}
+	tracing.Inject(ctx, headers)
+	return headers
+}
+
 func CreateAuthTokenHeader(token string) map[string]string {
 	xrfAuthToken := "xrf-auth-token"
 	return map[string]string{